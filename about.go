@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Build information, set at build time with e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// commit and buildTime fall back to the VCS information Go embeds when the
+// binary is built from a git checkout.
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// buildInfo returns the commit and build time of the running binary, or
+// "unknown" for what isn't known.
+func buildInfo() (string, string) {
+	rev, built := commit, buildTime
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+				if len(rev) > 7 {
+					rev = rev[:7]
+				}
+			case setting.Key == "vcs.time" && built == "":
+				built = setting.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	return rev, built
+}
+
+// aboutHandler answers /about with the version of the bot and the model that
+// answers the user.
+func aboutHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+
+		rev, built := buildInfo()
+		return c.Send(fmt.Sprintf("gogemini %s (commit %s, built %s)\nModel: %s", version, rev, built, userModel(cfg, settings)))
+	}
+}
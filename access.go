@@ -0,0 +1,50 @@
+package main
+
+import (
+	"slices"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// isAdmin reports whether user is one of the bot admins from ADMIN_IDS.
+func isAdmin(cfg *Config, user *tele.User) bool {
+	return user != nil && slices.Contains(cfg.AdminIDs, user.ID)
+}
+
+// isAllowed reports whether a user may use the bot. Admins are always
+// allowed, blocked users never are, and an empty allowlist allows everyone
+// else.
+func isAllowed(cfg *Config, userID int64) bool {
+	switch {
+	case slices.Contains(cfg.AdminIDs, userID):
+		return true
+	case slices.Contains(cfg.BlockedIDs, userID):
+		return false
+	case len(cfg.AllowedIDs) == 0:
+		return true
+	default:
+		return slices.Contains(cfg.AllowedIDs, userID)
+	}
+}
+
+// accessMiddleware rejects updates from users not allowed by ALLOWED_IDS and
+// BLOCKED_IDS.
+func accessMiddleware(cfg *Config) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			sender := c.Sender()
+			if sender == nil || isAllowed(cfg, sender.ID) {
+				return next(c)
+			}
+
+			updateLogger(c).Info("Rejected update from unauthorized user")
+			if c.Callback() != nil {
+				return c.Respond(&tele.CallbackResponse{Text: cfg.AccessDeniedMessage})
+			}
+			if c.Message() == nil || cfg.AccessDeniedMessage == "" {
+				return nil
+			}
+			return c.Send(cfg.AccessDeniedMessage)
+		}
+	}
+}
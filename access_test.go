@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestIsAllowed(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		user int64
+		want bool
+	}{
+		{"no lists", Config{}, 1, true},
+		{"on allowlist", Config{AllowedIDs: []int64{1, 2}}, 2, true},
+		{"not on allowlist", Config{AllowedIDs: []int64{1, 2}}, 3, false},
+		{"blocked", Config{BlockedIDs: []int64{1}}, 1, false},
+		{"blocked and allowed", Config{AllowedIDs: []int64{1}, BlockedIDs: []int64{1}}, 1, false},
+		{"admin not on allowlist", Config{AllowedIDs: []int64{1}, AdminIDs: []int64{9}}, 9, true},
+		{"blocked admin", Config{BlockedIDs: []int64{9}, AdminIDs: []int64{9}}, 9, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAllowed(&tt.cfg, tt.user); got != tt.want {
+				t.Errorf("isAllowed(%d) = %v, want %v", tt.user, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// encryptAPIKey encrypts a user's Gemini API key with AES-GCM under a key
+// derived from API_KEY_SECRET. The result is base64 with the nonce first.
+func encryptAPIKey(secret, apiKey string) (string, error) {
+	gcm, err := apiKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %v", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(apiKey), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptAPIKey reverses encryptAPIKey.
+func decryptAPIKey(secret, encrypted string) (string, error) {
+	gcm, err := apiKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted key: %v", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted key is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting key: %v", err)
+	}
+	return string(plain), nil
+}
+
+func apiKeyCipher(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// userConfig returns the configuration for requests made for sender: cfg
+// with the user's own Gemini API key when they set one with /mykey, or cfg
+// itself otherwise.
+func userConfig(cfg *Config, sender *tele.User) *Config {
+	if cfg.APIKeySecret == "" || sender == nil {
+		return cfg
+	}
+
+	settings, err := getUserSettings(cfg, sender.ID)
+	if err != nil {
+		slog.Error("Error getting user settings", "user_id", sender.ID, "err", err)
+		return cfg
+	}
+	if settings.APIKey == "" {
+		return cfg
+	}
+
+	apiKey, err := decryptAPIKey(cfg.APIKeySecret, settings.APIKey)
+	if err != nil {
+		// The secret may have changed, the global key still works
+		slog.Error("Error decrypting the API key", "user_id", sender.ID, "err", err)
+		return cfg
+	}
+
+	userCfg := *cfg
+	userCfg.GeminiAPIKey = apiKey
+	return &userCfg
+}
+
+// myKeyHandler stores the user's own Gemini API key with /mykey <key>, which
+// is then used for their requests, and removes it with /mykey clear. Keys
+// are only accepted in private chats and the message holding one is deleted.
+func myKeyHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if cfg.APIKeySecret == "" {
+			return c.Send("Personal API keys are not enabled on this bot")
+		}
+		if c.Chat().Type != tele.ChatPrivate {
+			if c.Message().Payload != "" {
+				// Don't leave the key readable in the group
+				if err := c.Delete(); err != nil {
+					updateLogger(c).Error("Error deleting a message with an API key", "err", err)
+				}
+			}
+			return c.Send("Please send /mykey in a private chat with me")
+		}
+
+		payload := strings.TrimSpace(c.Message().Payload)
+		switch {
+		case payload == "":
+			settings, err := getUserSettings(cfg, c.Sender().ID)
+			if err != nil {
+				updateLogger(c).Error("Error getting user settings", "err", err)
+			}
+			status := "You are using the bot's API key"
+			if settings.APIKey != "" {
+				status = "You are using your own API key"
+			}
+			return c.Send(status + "\n\nUsage: /mykey <Gemini API key> to use your own quota, /mykey clear to go back to the bot's key")
+
+		case strings.EqualFold(payload, "clear"):
+			if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.APIKey = "" }); err != nil {
+				updateLogger(c).Error("Error removing API key", "err", err)
+				return c.Send("Error removing your key")
+			}
+			return c.Send("Your key was removed, the bot's key is used again")
+		}
+
+		if err := c.Delete(); err != nil {
+			updateLogger(c).Error("Error deleting a message with an API key", "err", err)
+		}
+		if len(payload) < 20 || len(payload) > 200 || strings.ContainsAny(payload, " \t\n") {
+			return c.Send("That doesn't look like a Gemini API key")
+		}
+
+		encrypted, err := encryptAPIKey(cfg.APIKeySecret, payload)
+		if err != nil {
+			updateLogger(c).Error("Error encrypting API key", "err", err)
+			return c.Send("Error saving your key")
+		}
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.APIKey = encrypted }); err != nil {
+			updateLogger(c).Error("Error saving API key", "err", err)
+			return c.Send("Error saving your key")
+		}
+		return c.Send("Your key was saved and will be used for your requests. I deleted your message so it doesn't stay in the chat")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestAPIKeyRoundTrip(t *testing.T) {
+	for _, apiKey := range []string{"AIzaSyExampleKey123", "", "ключ с пробелами"} {
+		encrypted, err := encryptAPIKey("secret", apiKey)
+		if err != nil {
+			t.Fatalf("encryptAPIKey(%q) error = %v", apiKey, err)
+		}
+		if apiKey != "" && encrypted == apiKey {
+			t.Errorf("encryptAPIKey(%q) returned the key as is", apiKey)
+		}
+
+		got, err := decryptAPIKey("secret", encrypted)
+		if err != nil {
+			t.Fatalf("decryptAPIKey() error = %v", err)
+		}
+		if got != apiKey {
+			t.Errorf("decryptAPIKey() = %q, want %q", got, apiKey)
+		}
+	}
+}
+
+func TestEncryptAPIKeyUsesFreshNonce(t *testing.T) {
+	first, _ := encryptAPIKey("secret", "key")
+	second, _ := encryptAPIKey("secret", "key")
+	if first == second {
+		t.Error("encrypting the same key twice gave the same result")
+	}
+}
+
+func TestDecryptAPIKeyErrors(t *testing.T) {
+	encrypted, err := encryptAPIKey("secret", "AIzaSyExampleKey123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(encrypted)
+	sealed[len(sealed)-1] ^= 1
+	tampered := base64.StdEncoding.EncodeToString(sealed)
+
+	tests := []struct {
+		name      string
+		secret    string
+		encrypted string
+	}{
+		{"wrong secret", "other secret", encrypted},
+		{"tampered", "secret", tampered},
+		{"not base64", "secret", "not base64!"},
+		{"too short", "secret", base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := decryptAPIKey(tt.secret, tt.encrypted); err == nil {
+				t.Errorf("decryptAPIKey() = %q, want an error", got)
+			}
+		})
+	}
+}
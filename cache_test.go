@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	clock := newFakeClock()
+	cache := newLRUCache[string, int](2, time.Minute)
+	cache.now = clock.Now
+
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Get() on an empty cache hit")
+	}
+
+	cache.Set("a", 1)
+	if got, ok := cache.Get("a"); !ok || got != 1 {
+		t.Errorf("Get(a) = %d, %v, want 1, true", got, ok)
+	}
+
+	// Entries expire after the TTL, counted from the last Set
+	clock.Advance(50 * time.Second)
+	cache.Set("a", 2)
+	clock.Advance(50 * time.Second)
+	if got, ok := cache.Get("a"); !ok || got != 2 {
+		t.Errorf("Get(a) after an update = %d, %v, want 2, true", got, ok)
+	}
+	clock.Advance(11 * time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Get(a) hit after the TTL")
+	}
+
+	// The least recently used entry is evicted when the cache is full
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Set("c", 3)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b wasn't evicted")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := cache.Get(key); !ok || got != want {
+			t.Errorf("Get(%s) = %d, %v, want %d, true", key, got, ok, want)
+		}
+	}
+
+	cache.Delete("a")
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Get(a) hit after Delete")
+	}
+}
+
+func TestLRUCacheDisabled(t *testing.T) {
+	cache := newLRUCache[string, int](0, time.Minute)
+	cache.Set("a", 1)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("a cache of size 0 stored an entry")
+	}
+}
+
+func TestNewPromptKey(t *testing.T) {
+	if newPromptKey(1, "  Hello   World ") != newPromptKey(1, "hello world") {
+		t.Error("prompts differing in case and spaces got different keys")
+	}
+	if newPromptKey(1, "hello") == newPromptKey(2, "hello") {
+		t.Error("prompts of different users got the same key")
+	}
+}
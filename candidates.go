@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxCandidates is the most answers /candidates asks Gemini for at once.
+const maxCandidates = 4
+
+var (
+	candidateMenu = &tele.ReplyMarkup{}
+	btnCandidate  = candidateMenu.Data("Use this answer", "candidate")
+)
+
+// candidateMarkup returns the button that makes the alternative answer with
+// the given index the one kept in the history of the answer sent as
+// replyMsgID.
+func candidateMarkup(replyMsgID, index int) *tele.ReplyMarkup {
+	markup := &tele.ReplyMarkup{}
+	btn := btnCandidate
+	btn.Data = fmt.Sprintf("%d|%d", replyMsgID, index)
+	markup.Inline(markup.Row(btn))
+	return markup
+}
+
+// sendAlternatives sends the other candidates of an answer as separate
+// messages, each with a button to keep it instead of the first one. Gemini
+// may return fewer candidates than requested.
+func sendAlternatives(c tele.Context, cfg *Config, settings UserSettings, reply *tele.Message, modelTurn Message) {
+	total := len(modelTurn.Alternatives) + 1
+	for i, alt := range modelTurn.Alternatives {
+		text := fmt.Sprintf("Answer %d of %d:\n\n%s", i+2, total, alt)
+		if _, err := deliverReply(c, cfg, noPlaceholder(c, reply), settings, text, candidateMarkup(reply.ID, i)); err != nil {
+			updateLogger(c).Error("Error sending alternative answer", "err", err)
+			return
+		}
+	}
+}
+
+// chooseCandidateHandler keeps the alternative answer whose button was pressed
+// in the history, in place of the answer that was stored.
+func chooseCandidateHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		replyID, indexText, _ := strings.Cut(c.Data(), "|")
+		replyMsgID, err1 := strconv.Atoi(replyID)
+		index, err2 := strconv.Atoi(indexText)
+		if err1 != nil || err2 != nil {
+			return c.Respond()
+		}
+
+		if err := chooseCandidate(cfg, c.Sender().ID, c.Chat().ID, replyMsgID, index); err != nil {
+			updateLogger(c).Error("Error choosing answer", "err", err)
+			return c.Respond(&tele.CallbackResponse{Text: "Couldn't switch to this answer"})
+		}
+
+		if _, err := b.EditReplyMarkup(c.Message(), nil); err != nil {
+			updateLogger(c).Error("Error removing answer button", "err", err)
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "This answer will be used to continue the conversation"})
+	}
+}
+
+// chooseCandidate swaps the stored text of the answer sent as replyMsgID of
+// chatID with its alternative at index, so the other one can still be chosen
+// later.
+func chooseCandidate(cfg *Config, telegramID, chatID int64, replyMsgID, index int) error {
+	historySaves.Wait(telegramID)
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("no history found for this user")
+	}
+
+	messages := user.SessionMessages()
+	for i := range messages {
+		turn := &messages[i]
+		if !turn.sentAs(telegramID, chatID, replyMsgID) {
+			continue
+		}
+		if index < 0 || index >= len(turn.Alternatives) {
+			return fmt.Errorf("answer %d has no alternative %d", replyMsgID, index)
+		}
+		turn.Message, turn.Alternatives[index] = turn.Alternatives[index], turn.Message
+		return patchHistory(cfg, user)
+	}
+	return fmt.Errorf("message %d not found in history", replyMsgID)
+}
+
+// alternativeTexts returns the answers of the other candidates, skipping
+// empty ones and duplicates of the first answer.
+func alternativeTexts(candidates []Candidate, first string) []string {
+	var texts []string
+	for _, candidate := range candidates {
+		text := candidateText(candidate.Content.Parts)
+		if text != "" && text != first {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+// candidatesHandler sets with /candidates <n> how many answers are generated
+// for every message.
+func candidatesHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		n, err := strconv.Atoi(strings.TrimSpace(c.Message().Payload))
+		if err != nil || n < 1 || n > maxCandidates {
+			return c.Send(fmt.Sprintf("Usage: /candidates <1-%d> to get several answers to choose from, 1 for a single answer", maxCandidates))
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.Candidates = n }); err != nil {
+			updateLogger(c).Error("Error saving candidates setting", "err", err)
+			return c.Send("Error saving your preference")
+		}
+
+		if n == 1 {
+			return c.Send("You will get a single answer again")
+		}
+		return c.Send(fmt.Sprintf("You will get up to %d answers to choose from. Answers aren't streamed meanwhile", n))
+	}
+}
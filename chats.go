@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxPersonaLength bounds the persona so it can't crowd out the conversation.
+const maxPersonaLength = 1000
+
+// ChatSettings holds settings shared by everyone in a chat.
+type ChatSettings struct {
+	ID      int64  `json:"id,omitempty"`
+	ChatID  int64  `json:"chatId"`
+	Persona string `json:"persona"`
+	// PinnedContext sends the chat's pinned message along with prompts
+	PinnedContext bool `json:"pinnedContext,omitempty"`
+}
+
+// chatSettingsCache keeps the settings of recent chats so every prompt
+// doesn't fetch them from the store. A chat without stored settings is
+// cached as the zero value. Changes are written through it. main sizes it
+// like settingsCache.
+var chatSettingsCache = newLRUCache[int64, ChatSettings](0, 0)
+
+// chatLocks serializes changes to the settings of a chat, the way userLocks
+// does for users.
+var chatLocks = newKeyedLocks()
+
+// getChatSettings fetches the settings of a chat from the store. It returns
+// nil if the chat has none stored.
+func getChatSettings(cfg *Config, chatID int64) (*ChatSettings, error) {
+	var chats []ChatSettings
+	if err := storeRequest(cfg, "GET", fmt.Sprintf("chats?chatId=%d", chatID), nil, &chats); err != nil {
+		return nil, fmt.Errorf("error getting chat settings from API: %w", err)
+	}
+
+	if len(chats) == 0 {
+		return nil, nil
+	}
+	return &chats[0], nil
+}
+
+// cachedChatSettings returns the settings of a chat, from the cache when
+// possible. The cache is filled under the chat's lock, so a read can't
+// replace settings updateChatSettings just wrote. In STATELESS mode chat
+// settings are only kept in memory.
+func cachedChatSettings(cfg *Config, chatID int64) (ChatSettings, error) {
+	if cfg.Stateless {
+		return statelessChatSettings.Get(chatID), nil
+	}
+	if settings, ok := chatSettingsCache.Get(chatID); ok {
+		return settings, nil
+	}
+
+	defer chatLocks.Lock(chatID)()
+	if settings, ok := chatSettingsCache.Get(chatID); ok {
+		return settings, nil
+	}
+	stored, err := getChatSettings(cfg, chatID)
+	if err != nil {
+		return ChatSettings{}, err
+	}
+	settings := ChatSettings{ChatID: chatID}
+	if stored != nil {
+		settings = *stored
+	}
+	chatSettingsCache.Set(chatID, settings)
+	return settings, nil
+}
+
+// saveChatPersona stores the persona of a chat.
+func saveChatPersona(cfg *Config, chatID int64, persona string) error {
+	return updateChatSettings(cfg, chatID, func(s *ChatSettings) { s.Persona = persona })
+}
+
+// updateChatSettings applies update to the settings of a chat and stores
+// them, creating its settings record if needed.
+func updateChatSettings(cfg *Config, chatID int64, update func(*ChatSettings)) error {
+	if cfg.Stateless {
+		statelessChatSettings.Update(chatID, update)
+		return nil
+	}
+
+	defer chatLocks.Lock(chatID)()
+	settings, err := getChatSettings(cfg, chatID)
+	if err != nil {
+		return err
+	}
+
+	method, path := "POST", "chats"
+	if settings != nil {
+		method, path = "PATCH", fmt.Sprintf("chats/%d", settings.ID)
+	} else {
+		settings = &ChatSettings{ChatID: chatID}
+	}
+	update(settings)
+
+	var stored ChatSettings
+	if err := storeRequest(cfg, method, path, settings, &stored); err != nil {
+		// The store may or may not have the change, read it again next time
+		chatSettingsCache.Delete(chatID)
+		return fmt.Errorf("error saving chat settings: %w", err)
+	}
+	if settings.ID == 0 {
+		settings.ID = stored.ID
+	}
+	chatSettingsCache.Set(chatID, *settings)
+	return nil
+}
+
+// chatPersona returns the persona configured for a chat, or an empty string.
+func chatPersona(cfg *Config, chatID int64) string {
+	settings, err := cachedChatSettings(cfg, chatID)
+	if err != nil {
+		slog.Error("Error getting chat settings", "chat_id", chatID, "err", err)
+		return ""
+	}
+	return settings.Persona
+}
+
+// isChatAdmin reports whether the user may change settings of the chat.
+// Everyone is the admin of their own private chat.
+func isChatAdmin(b *tele.Bot, chat *tele.Chat, user *tele.User) (bool, error) {
+	if chat.Type == tele.ChatPrivate {
+		return true, nil
+	}
+
+	member, err := b.ChatMemberOf(chat, user)
+	if err != nil {
+		return false, err
+	}
+	return member.Role == tele.Creator || member.Role == tele.Administrator, nil
+}
+
+// setPersonaHandler lets chat administrators set the persona used for every
+// answer in the chat. "/setpersona off" removes it.
+func setPersonaHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		isAdmin, err := isChatAdmin(b, c.Chat(), c.Sender())
+		if err != nil {
+			updateLogger(c).Error("Error checking chat admin", "err", err)
+			return c.Send("Error checking your permissions")
+		}
+		if !isAdmin {
+			return c.Send("Only chat administrators can change the persona")
+		}
+
+		persona := strings.TrimSpace(c.Message().Payload)
+		if persona == "" {
+			current := chatPersona(cfg, c.Chat().ID)
+			if current == "" {
+				current = "none"
+			}
+			return c.Send("Current persona: " + current + "\n\nUsage: /setpersona You are a pirate who answers {{username}} in rhymes\nPersonas may use {{username}}, {{date}} and {{language}}\nUse /setpersona off to remove it")
+		}
+		if persona == "off" {
+			persona = ""
+		}
+		if utf8.RuneCountInString(persona) > maxPersonaLength {
+			return c.Send(fmt.Sprintf("The persona is too long, please keep it under %d characters", maxPersonaLength))
+		}
+		if err := validatePersona(persona); err != nil {
+			return c.Send(err.Error())
+		}
+
+		if err := saveChatPersona(cfg, c.Chat().ID, persona); err != nil {
+			updateLogger(c).Error("Error saving chat persona", "err", err)
+			return c.Send("Error saving the persona")
+		}
+
+		if persona == "" {
+			return c.Send("The persona was removed")
+		}
+		return c.Send("The persona for this chat was updated!")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeChatStore serves the chats collection of Mokky from memory.
+type fakeChatStore struct {
+	mu    sync.Mutex
+	chats map[int64]*ChatSettings
+	reads int
+}
+
+func newFakeChatStore(t *testing.T) (*fakeChatStore, *Config) {
+	store := &fakeChatStore{chats: make(map[int64]*ChatSettings)}
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+	return store, &Config{MokkyURL: server.URL + "/", StoreTimeout: 5 * time.Second}
+}
+
+func (s *fakeChatStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/chats":
+		s.reads++
+		chatID, _ := strconv.ParseInt(r.URL.Query().Get("chatId"), 10, 64)
+		chats := []ChatSettings{}
+		for _, chat := range s.chats {
+			if chat.ChatID == chatID {
+				chats = append(chats, *chat)
+			}
+		}
+		json.NewEncoder(w).Encode(chats)
+	case r.Method == http.MethodPost && r.URL.Path == "/chats":
+		var chat ChatSettings
+		json.NewDecoder(r.Body).Decode(&chat)
+		chat.ID = int64(len(s.chats) + 1)
+		s.chats[chat.ID] = &chat
+		json.NewEncoder(w).Encode(chat)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/chats/"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/chats/"), 10, 64)
+		chat, ok := s.chats[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(chat)
+		json.NewEncoder(w).Encode(chat)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// persona returns the stored persona of the chat.
+func (s *fakeChatStore) persona(chatID int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, chat := range s.chats {
+		if chat.ChatID == chatID {
+			return chat.Persona
+		}
+	}
+	return ""
+}
+
+func useChatSettingsCache(t *testing.T) {
+	old := chatSettingsCache
+	chatSettingsCache = newLRUCache[int64, ChatSettings](10, time.Hour)
+	t.Cleanup(func() { chatSettingsCache = old })
+}
+
+func TestSetPersonaAdminGate(t *testing.T) {
+	tests := []struct {
+		name        string
+		chat        *tele.Chat
+		role        tele.MemberStatus
+		wantText    string
+		wantPersona string
+	}{
+		{"group member", &tele.Chat{ID: -100, Type: tele.ChatGroup}, tele.Member, "Only chat administrators can change the persona", ""},
+		{"group administrator", &tele.Chat{ID: -100, Type: tele.ChatGroup}, tele.Administrator, "The persona for this chat was updated!", "a pirate"},
+		{"supergroup creator", &tele.Chat{ID: -100, Type: tele.ChatSuperGroup}, tele.Creator, "The persona for this chat was updated!", "a pirate"},
+		{"private chat", &tele.Chat{ID: 1, Type: tele.ChatPrivate}, "", "The persona for this chat was updated!", "a pirate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useChatSettingsCache(t)
+			store, cfg := newFakeChatStore(t)
+			b, api := newTestBot(t)
+			api.answers["getChatMember"] = `{"user":{"id":1},"status":"` + string(tt.role) + `"}`
+
+			c := b.NewContext(tele.Update{Message: &tele.Message{
+				Sender:  &tele.User{ID: 1},
+				Chat:    tt.chat,
+				Text:    "/setpersona a pirate",
+				Payload: "a pirate",
+			}})
+			if err := setPersonaHandler(b, cfg)(c); err != nil {
+				t.Fatalf("setPersonaHandler() error = %v", err)
+			}
+
+			if texts := api.Texts(); len(texts) != 1 || texts[0] != tt.wantText {
+				t.Errorf("replies = %q, want %q", texts, tt.wantText)
+			}
+			if got := store.persona(tt.chat.ID); got != tt.wantPersona {
+				t.Errorf("stored persona = %q, want %q", got, tt.wantPersona)
+			}
+			if tt.chat.Type == tele.ChatPrivate && len(api.Calls("getChatMember")) > 0 {
+				t.Error("getChatMember was called in a private chat")
+			}
+		})
+	}
+}
+
+func TestSetPersonaLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		persona  string
+		wantText string
+	}{
+		{"at the limit", strings.Repeat("я", maxPersonaLength), "The persona for this chat was updated!"},
+		{"over the limit", strings.Repeat("я", maxPersonaLength+1), "The persona is too long, please keep it under 1000 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useChatSettingsCache(t)
+			_, cfg := newFakeChatStore(t)
+			b, api := newTestBot(t)
+
+			c := b.NewContext(tele.Update{Message: &tele.Message{
+				Sender:  &tele.User{ID: 1},
+				Chat:    &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+				Text:    "/setpersona " + tt.persona,
+				Payload: tt.persona,
+			}})
+			if err := setPersonaHandler(b, cfg)(c); err != nil {
+				t.Fatalf("setPersonaHandler() error = %v", err)
+			}
+			if texts := api.Texts(); len(texts) != 1 || texts[0] != tt.wantText {
+				t.Errorf("replies = %q, want %q", texts, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestChatSettingsCache(t *testing.T) {
+	useChatSettingsCache(t)
+	store, cfg := newFakeChatStore(t)
+
+	// Chats without settings are cached too
+	for i := 0; i < 3; i++ {
+		if got := chatPersona(cfg, -100); got != "" {
+			t.Fatalf("chatPersona() = %q, want none", got)
+		}
+	}
+	if store.reads != 1 {
+		t.Errorf("store was read %d times, want 1", store.reads)
+	}
+
+	if err := saveChatPersona(cfg, -100, "a pirate"); err != nil {
+		t.Fatalf("saveChatPersona() error = %v", err)
+	}
+	if err := updateChatSettings(cfg, -100, func(s *ChatSettings) { s.PinnedContext = true }); err != nil {
+		t.Fatalf("updateChatSettings() error = %v", err)
+	}
+	reads := store.reads
+
+	// Writes go through the cache
+	settings, err := cachedChatSettings(cfg, -100)
+	if err != nil {
+		t.Fatalf("cachedChatSettings() error = %v", err)
+	}
+	if settings.Persona != "a pirate" || !settings.PinnedContext || settings.ID == 0 {
+		t.Errorf("cachedChatSettings() = %+v, want the saved settings", settings)
+	}
+	if store.reads != reads {
+		t.Errorf("store was read %d times after the update, want %d", store.reads, reads)
+	}
+	if got := len(store.chats); got != 1 {
+		t.Errorf("store has %d chat records, want 1", got)
+	}
+}
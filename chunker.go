@@ -0,0 +1,196 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// codeFence starts and ends Markdown code blocks.
+const codeFence = "```"
+
+// textBlock is a run of plain text lines or a fenced code block. For code
+// blocks opener is the opening fence line, e.g. "```go", and body holds the
+// lines between the fences.
+type textBlock struct {
+	text   string
+	opener string
+	body   []string
+}
+
+// splitMessage splits text into pieces of at most limit characters. Code
+// blocks are kept whole when they fit in a piece, and a code block longer
+// than a piece is closed at the end of each piece and reopened in the next
+// one. Plain text prefers to break at paragraph ends, then line ends, then
+// spaces.
+func splitMessage(text string, limit int) []string {
+	var (
+		chunks  []string
+		current strings.Builder
+		length  int
+	)
+	flush := func() {
+		if chunk := strings.TrimRight(current.String(), " \n"); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+		length = 0
+	}
+	add := func(piece string) {
+		n := utf8.RuneCountInString(piece)
+		if length > 0 && length+1+n > limit {
+			flush()
+		}
+		if length == 0 {
+			// Blank lines between blocks are dropped at the start of a chunk
+			piece = strings.TrimLeft(piece, "\n")
+			n = utf8.RuneCountInString(piece)
+			if piece == "" {
+				return
+			}
+		} else {
+			current.WriteString("\n")
+			length++
+		}
+		current.WriteString(piece)
+		length += n
+	}
+
+	for _, block := range splitBlocks(text) {
+		for _, piece := range block.pieces(limit) {
+			add(piece)
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitBlocks separates text into plain text and code blocks. An unclosed
+// fence runs until the end of the text.
+func splitBlocks(text string) []textBlock {
+	var (
+		blocks []textBlock
+		plain  []string
+		code   *textBlock
+	)
+	flushPlain := func() {
+		if len(plain) > 0 {
+			blocks = append(blocks, textBlock{text: strings.Join(plain, "\n")})
+		}
+		plain = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		isFence := strings.HasPrefix(strings.TrimSpace(line), codeFence)
+		switch {
+		case code == nil && isFence:
+			flushPlain()
+			code = &textBlock{opener: line}
+		case code == nil:
+			plain = append(plain, line)
+		case isFence:
+			code.text = code.opener + "\n" + strings.Join(append(code.body, line), "\n")
+			blocks = append(blocks, *code)
+			code = nil
+		default:
+			code.body = append(code.body, line)
+		}
+	}
+
+	if code != nil {
+		code.text = strings.Join(append([]string{code.opener}, code.body...), "\n")
+		blocks = append(blocks, *code)
+	}
+	flushPlain()
+	return blocks
+}
+
+// pieces splits the block into parts of at most limit characters.
+func (b textBlock) pieces(limit int) []string {
+	if utf8.RuneCountInString(b.text) <= limit {
+		return []string{b.text}
+	}
+	if b.opener == "" {
+		return splitPlain(b.text, limit)
+	}
+
+	// Every piece of a long code block is wrapped in its own fences
+	room := limit - utf8.RuneCountInString(b.opener) - len(codeFence) - 2
+	if room <= 0 {
+		return splitPlain(b.text, limit)
+	}
+
+	var (
+		pieces []string
+		lines  []string
+		length int
+	)
+	flush := func() {
+		if len(lines) > 0 {
+			pieces = append(pieces, b.opener+"\n"+strings.Join(lines, "\n")+"\n"+codeFence)
+			lines = nil
+			length = 0
+		}
+	}
+	for _, line := range b.body {
+		// Lines that don't fit on their own are cut
+		for _, part := range splitRunes(line, room) {
+			n := utf8.RuneCountInString(part)
+			if len(lines) > 0 && length+1+n > room {
+				flush()
+			}
+			if len(lines) > 0 {
+				length++
+			}
+			lines = append(lines, part)
+			length += n
+		}
+	}
+	flush()
+	return pieces
+}
+
+// splitPlain splits text into pieces of at most limit characters, preferring
+// to break at paragraph ends, then line ends, then spaces.
+func splitPlain(text string, limit int) []string {
+	var chunks []string
+	for utf8.RuneCountInString(text) > limit {
+		cut := runeOffset(text, limit)
+		end := cut
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			if i := strings.LastIndex(text[:cut], sep); i > 0 {
+				end = i
+				break
+			}
+		}
+
+		chunks = append(chunks, strings.TrimRight(text[:end], " \n"))
+		text = strings.TrimLeft(text[end:], " \n")
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// splitRunes cuts text into parts of at most n characters. Empty text gives a
+// single empty part.
+func splitRunes(text string, n int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > n {
+		cut := runeOffset(text, n)
+		parts = append(parts, text[:cut])
+		text = text[cut:]
+	}
+	return append(parts, text)
+}
+
+// runeOffset returns the byte offset of the n-th rune of text.
+func runeOffset(text string, n int) int {
+	for i := range text {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(text)
+}
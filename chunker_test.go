@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "fits",
+			text:  "hello world",
+			limit: 20,
+			want:  []string{"hello world"},
+		},
+		{
+			name:  "empty",
+			text:  "",
+			limit: 20,
+			want:  nil,
+		},
+		{
+			name:  "breaks at paragraph end",
+			text:  "first line\nsecond\n\nthird para",
+			limit: 20,
+			want:  []string{"first line\nsecond", "third para"},
+		},
+		{
+			name:  "breaks at line end",
+			text:  "one two three\nfour five",
+			limit: 16,
+			want:  []string{"one two three", "four five"},
+		},
+		{
+			name:  "breaks at space",
+			text:  "one two three four",
+			limit: 10,
+			want:  []string{"one two", "three four"},
+		},
+		{
+			name:  "cuts a word longer than the limit",
+			text:  "abcdefghij",
+			limit: 4,
+			want:  []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name:  "counts characters, not bytes",
+			text:  "привет мир",
+			limit: 10,
+			want:  []string{"привет мир"},
+		},
+		{
+			name:  "keeps a code block whole",
+			text:  "intro\n```go\nx := 1\n```\noutro",
+			limit: 20,
+			want:  []string{"intro", "```go\nx := 1\n```", "outro"},
+		},
+		{
+			name:  "reopens a long code block",
+			text:  "```go\nline1\nline2\nline3\n```",
+			limit: 21,
+			want:  []string{"```go\nline1\nline2\n```", "```go\nline3\n```"},
+		},
+		{
+			name:  "unclosed code block",
+			text:  "```\ncode",
+			limit: 20,
+			want:  []string{"```\ncode"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessageLimits(t *testing.T) {
+	var text strings.Builder
+	for i := 0; i < 50; i++ {
+		text.WriteString("Some text before the code that goes on for a while.\n\n```python\n")
+		for j := 0; j < 20; j++ {
+			text.WriteString("print('a line of code that is fairly long') # comment\n")
+		}
+		text.WriteString("```\n")
+	}
+
+	const limit = 500
+	chunks := splitMessage(text.String(), limit)
+	for i, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk); n > limit {
+			t.Errorf("chunk %d has %d characters, want at most %d", i+1, n, limit)
+		}
+		if fences := strings.Count(chunk, codeFence); fences%2 != 0 {
+			t.Errorf("chunk %d has %d code fences, want them balanced:\n%s", i+1, fences, chunk)
+		}
+	}
+}
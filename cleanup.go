@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// defaultStripLabels are the role labels removed from the start of answers
+// unless STRIP_ROLE_LABELS overrides them.
+var defaultStripLabels = []string{"model", "assistant", "bot", "gemini", "ai", "user"}
+
+// cleanReply removes role labels such as "model:" the model sometimes puts in
+// front of its answer, and a leading line that only repeats the prompt.
+func cleanReply(cfg *Config, text, prompt string) string {
+	cleaned := text
+	for {
+		next := stripEcho(stripRoleLabel(cleaned, cfg.StripRoleLabels), prompt)
+		if next == cleaned {
+			break
+		}
+		cleaned = next
+	}
+	// Never throw away the whole answer
+	if strings.TrimSpace(cleaned) == "" {
+		return text
+	}
+	return cleaned
+}
+
+// stripRoleLabel removes one of labels followed by a colon from the start of
+// text, ignoring case.
+func stripRoleLabel(text string, labels []string) string {
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	for _, label := range labels {
+		if len(trimmed) < len(label) || !strings.EqualFold(trimmed[:len(label)], label) {
+			continue
+		}
+		rest := strings.TrimLeft(trimmed[len(label):], " \t")
+		if after, ok := strings.CutPrefix(rest, ":"); ok {
+			return strings.TrimLeftFunc(after, unicode.IsSpace)
+		}
+	}
+	return text
+}
+
+// stripEcho removes a first line of text that repeats prompt, ignoring case
+// and surrounding spaces.
+func stripEcho(text, prompt string) string {
+	prompt = strings.TrimSpace(prompt)
+	first, rest, ok := strings.Cut(strings.TrimLeftFunc(text, unicode.IsSpace), "\n")
+	if !ok || prompt == "" || !strings.EqualFold(strings.TrimSpace(first), prompt) {
+		return text
+	}
+	return strings.TrimLeftFunc(rest, unicode.IsSpace)
+}
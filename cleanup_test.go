@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestCleanReply(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		text   string
+		prompt string
+		want   string
+	}{
+		{"role label", defaultStripLabels, "Model: hi there", "hello", "hi there"},
+		{"space before the colon", defaultStripLabels, "assistant : hi there", "hello", "hi there"},
+		{"stacked labels", defaultStripLabels, "AI: model: hi there", "hello", "hi there"},
+		{"leading whitespace", defaultStripLabels, "\n  Gemini:\nhi there", "hello", "hi there"},
+		{"label without a colon", defaultStripLabels, "AI is great", "hello", "AI is great"},
+		{"echoed prompt", defaultStripLabels, "What is Go?\nGo is a language.", "what is go? ", "Go is a language."},
+		{"echo and label", defaultStripLabels, "model: What is Go?\nGo is a language.", "What is Go?", "Go is a language."},
+		{"echo without an answer", defaultStripLabels, "What is Go?", "What is Go?", "What is Go?"},
+		{"empty prompt", defaultStripLabels, "\nhi there", "", "\nhi there"},
+		{"only a label", defaultStripLabels, "model:", "hello", "model:"},
+		{"custom labels", []string{"pirate"}, "Pirate: ahoy", "hello", "ahoy"},
+		{"default label not configured", []string{"pirate"}, "model: ahoy", "hello", "model: ahoy"},
+		{"no labels", nil, "model: ahoy", "hello", "model: ahoy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{StripRoleLabels: tt.labels}
+			if got := cleanReply(cfg, tt.text, tt.prompt); got != tt.want {
+				t.Errorf("cleanReply(%q, %q) = %q, want %q", tt.text, tt.prompt, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// formatCodeBlocks turns the fenced code blocks of text into Telegram "pre"
+// entities, so clients render them as code with a copy button. The fences are
+// removed from the returned text and everything else is left as plain text.
+func formatCodeBlocks(text string) (string, tele.Entities) {
+	if !strings.Contains(text, codeFence) {
+		return text, nil
+	}
+
+	var (
+		out      strings.Builder
+		entities tele.Entities
+		offset   int
+	)
+	write := func(s string) {
+		out.WriteString(s)
+		offset += utf16Len(s)
+	}
+
+	for i, block := range splitBlocks(text) {
+		if i > 0 {
+			write("\n")
+		}
+		if block.opener == "" {
+			write(block.text)
+			continue
+		}
+
+		code := strings.Join(block.body, "\n")
+		if strings.TrimSpace(code) == "" {
+			write(code)
+			continue
+		}
+		entities = append(entities, tele.MessageEntity{
+			Type:     tele.EntityCodeBlock,
+			Offset:   offset,
+			Length:   utf16Len(code),
+			Language: fenceLanguage(block.opener),
+		})
+		write(code)
+	}
+	return out.String(), entities
+}
+
+// fenceLanguage returns the language named after an opening fence, e.g. "go"
+// for "```go".
+func fenceLanguage(opener string) string {
+	lang := strings.TrimPrefix(strings.TrimSpace(opener), codeFence)
+	if fields := strings.Fields(lang); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit Telegram
+// uses for entity offsets.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// isEntityParseError reports whether Telegram rejected a formatted message
+// because of its entities. Telegram has no separate error code for that, so
+// the description of the bad request is checked, e.g. "can't parse entities"
+// or "entity beginning is out of bounds". Other bad requests would fail the
+// same way without the formatting.
+func isEntityParseError(err error) bool {
+	var tgErr *tele.Error
+	if !errors.As(err, &tgErr) || tgErr.Code != http.StatusBadRequest {
+		return false
+	}
+	description := strings.ToLower(tgErr.Description)
+	return strings.Contains(description, "can't parse entities") || strings.Contains(description, "entity")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestFormatCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantText     string
+		wantEntities tele.Entities
+	}{
+		{
+			name:     "no code",
+			text:     "just text",
+			wantText: "just text",
+		},
+		{
+			name:     "code block with language",
+			text:     "Try this:\n```go\nfmt.Println(1)\n```\nDone",
+			wantText: "Try this:\nfmt.Println(1)\nDone",
+			wantEntities: tele.Entities{
+				{Type: tele.EntityCodeBlock, Offset: 10, Length: 14, Language: "go"},
+			},
+		},
+		{
+			name:     "code block without language",
+			text:     "```\na\nb\n```",
+			wantText: "a\nb",
+			wantEntities: tele.Entities{
+				{Type: tele.EntityCodeBlock, Offset: 0, Length: 3},
+			},
+		},
+		{
+			name:     "offsets count UTF-16 code units",
+			text:     "😀 é\n```py\nx\n```",
+			wantText: "😀 é\nx",
+			wantEntities: tele.Entities{
+				{Type: tele.EntityCodeBlock, Offset: 5, Length: 1, Language: "py"},
+			},
+		},
+		{
+			name:     "two code blocks",
+			text:     "```sh\nls\n```\nand\n```sh\npwd\n```",
+			wantText: "ls\nand\npwd",
+			wantEntities: tele.Entities{
+				{Type: tele.EntityCodeBlock, Offset: 0, Length: 2, Language: "sh"},
+				{Type: tele.EntityCodeBlock, Offset: 7, Length: 3, Language: "sh"},
+			},
+		},
+		{
+			name:     "unclosed code block",
+			text:     "See\n```js\nlet a",
+			wantText: "See\nlet a",
+			wantEntities: tele.Entities{
+				{Type: tele.EntityCodeBlock, Offset: 4, Length: 5, Language: "js"},
+			},
+		},
+		{
+			name:     "empty code block",
+			text:     "a\n```\n```",
+			wantText: "a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotText, gotEntities := formatCodeBlocks(tt.text)
+			if gotText != tt.wantText {
+				t.Errorf("formatCodeBlocks() text = %q, want %q", gotText, tt.wantText)
+			}
+			if !reflect.DeepEqual(gotEntities, tt.wantEntities) {
+				t.Errorf("formatCodeBlocks() entities = %+v, want %+v", gotEntities, tt.wantEntities)
+			}
+		})
+	}
+}
+
+func TestIsEntityParseError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unparsable entities", tele.NewError(400, "Bad Request: can't parse entities: unsupported start tag"), true},
+		{"wrapped entity out of bounds", fmt.Errorf("error sending: %w", tele.NewError(400, "Bad Request: entity beginning is out of bounds")), true},
+		{"other bad request", tele.NewError(400, "Bad Request: message text is empty"), false},
+		{"chat not found", tele.ErrChatNotFound, false},
+		{"blocked by user", tele.ErrBlockedByUser, false},
+		{"flood", tele.FloodError{RetryAfter: 3}, false},
+		{"network", errors.New("connection reset"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isEntityParseError(tt.err); got != tt.want {
+				t.Errorf("isEntityParseError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
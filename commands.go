@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// commandNamePattern matches the command names Telegram accepts.
+var commandNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// commandRegistry registers command handlers with the bot and remembers them,
+// so operators can add aliases for them with COMMAND_ALIASES.
+type commandRegistry struct {
+	bot      *tele.Bot
+	handlers map[string]tele.HandlerFunc
+	aliases  map[string]string
+}
+
+func newCommandRegistry(b *tele.Bot) *commandRegistry {
+	return &commandRegistry{bot: b, handlers: map[string]tele.HandlerFunc{}, aliases: map[string]string{}}
+}
+
+// Handle registers handler for a command such as "/help", wrapped in the
+// given middleware.
+func (r *commandRegistry) Handle(command string, handler tele.HandlerFunc, m ...tele.MiddlewareFunc) {
+	for i := len(m) - 1; i >= 0; i-- {
+		handler = m[i](handler)
+	}
+	r.handlers[strings.TrimPrefix(command, "/")] = handler
+	r.bot.Handle(command, handler)
+}
+
+// Alias registers each alias in aliases as another name for the command it
+// maps to. Aliases may not replace an existing command or point to an
+// unknown one.
+func (r *commandRegistry) Alias(aliases map[string]string) error {
+	names := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+
+	for _, alias := range names {
+		target := aliases[alias]
+		if _, ok := r.handlers[alias]; ok {
+			return fmt.Errorf("alias /%s conflicts with an existing command", alias)
+		}
+		handler, ok := r.handlers[target]
+		if !ok {
+			return fmt.Errorf("alias /%s points to unknown command /%s", alias, target)
+		}
+
+		r.aliases[alias] = target
+		r.bot.Handle("/"+alias, handler)
+		slog.Info("Registered command alias", "alias", alias, "command", target)
+	}
+	return nil
+}
+
+// Commands returns the command list shown by Telegram clients, built from
+// helpText plus the aliases of the listed commands.
+func (r *commandRegistry) Commands() []tele.Command {
+	var commands []tele.Command
+	descriptions := map[string]string{}
+	for _, line := range strings.Split(helpText, "\n") {
+		usage, description, ok := strings.Cut(line, " - ")
+		if !ok || !strings.HasPrefix(usage, "/") {
+			continue
+		}
+		name := strings.TrimPrefix(strings.Fields(usage)[0], "/")
+		if _, ok := r.handlers[name]; !ok {
+			continue
+		}
+		descriptions[name] = description
+		commands = append(commands, tele.Command{Text: name, Description: description})
+	}
+
+	aliases := make([]string, 0, len(r.aliases))
+	for alias := range r.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if description, ok := descriptions[r.aliases[alias]]; ok {
+			commands = append(commands, tele.Command{Text: alias, Description: description})
+		}
+	}
+	return commands
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Config holds all settings of the bot. It is loaded once at startup by
+// LoadConfig and passed explicitly to everything that needs it.
+type Config struct {
+	TelegramToken string
+	GeminiAPIKey  string
+	MokkyURL      string
+	// APIKeySecret encrypts the API keys users set with /mykey. The command
+	// is disabled when it is empty.
+	APIKeySecret string
+
+	// StoreTimeout bounds every request to Mokky and StoreRetries is how many
+	// times failed requests are retried
+	StoreTimeout time.Duration
+	StoreRetries int
+
+	// BotMode is either "polling" (default) or "webhook"
+	BotMode        string
+	WebhookURL     string
+	WebhookListen  string
+	WebhookSecret  string
+	WebhookTLSCert string
+	WebhookTLSKey  string
+	// HealthListen is the address of the health check server, e.g. ":8080".
+	// Empty disables it.
+	HealthListen string
+
+	// GeminiAPIHost and GeminiAPIVersion make up the base URL of the Gemini
+	// API, e.g. to test against a proxy or move to a newer version
+	GeminiAPIHost    string
+	GeminiAPIVersion string
+	// GeminiBaseURL replaces both when set, for gateways that serve the API
+	// under a different path. GeminiAuthMode is how the key is sent:
+	// "query-key" as the key parameter or "bearer" as an Authorization header.
+	GeminiBaseURL  string
+	GeminiAuthMode string
+
+	// MaxConcurrentGemini caps concurrent Gemini requests, 0 disables the
+	// cap. Up to GeminiQueueSize requests wait GeminiQueueTimeout for a slot
+	// before being rejected as busy. The timeout must be positive, as a zero
+	// wait would reject every request that finds all slots taken.
+	MaxConcurrentGemini int
+	GeminiQueueSize     int
+	GeminiQueueTimeout  time.Duration
+
+	// HTTPIdleConnsPerHost is how many idle connections are kept open to
+	// each host, e.g. Gemini, for reuse. HTTPIdleConnTimeout closes idle
+	// connections after a while and HTTPKeepAlive is the TCP keep-alive
+	// interval.
+	HTTPIdleConnsPerHost int
+	HTTPIdleConnTimeout  time.Duration
+	HTTPKeepAlive        time.Duration
+
+	// FallbackModel is tried once when the text model is overloaded or
+	// unavailable. Empty disables the fallback.
+	FallbackModel string
+	// Models are the text models users can choose from with /model
+	Models []string
+
+	// LogFormat is how logs are written, "text" or "json"
+	LogFormat string
+
+	// MockGemini returns canned responses instead of calling the Gemini API
+	MockGemini bool
+	// Stateless keeps no conversation history at all, for operators who
+	// don't want conversations stored. Every message is answered on its own,
+	// so follow-up questions lose their context and history features such as
+	// /undo, /summarize and ratings have nothing to work on. Answering a
+	// message doesn't use the store at all: settings, chat settings and daily
+	// quotas are only kept in memory and forgotten on restart, and image
+	// generations interrupted by a restart aren't reported. Commands that
+	// manage stored data, such as /undo or /topusers, and the polling
+	// checkpoint still use the store.
+	Stateless bool
+	// SeparateImageStorage keeps images in their own collection and only
+	// stores references in the user record
+	SeparateImageStorage bool
+
+	// OutboundRate and ChatRate limit messages sent per second overall and
+	// per chat, Telegram allows about 30 and 1
+	OutboundRate float64
+	ChatRate     float64
+
+	// Streaming makes answers appear as they are generated unless a user
+	// turns it off with /stream. StreamEditInterval throttles the edits.
+	Streaming          bool
+	StreamEditInterval time.Duration
+
+	// ThinkingPlaceholder is sent when an answer takes longer than
+	// PlaceholderDelay and then edited into the answer. "off" disables it.
+	ThinkingPlaceholder string
+	PlaceholderDelay    time.Duration
+
+	// LongReplyThreshold is the length above which answers are sent as a
+	// document to users who chose /longformat file
+	LongReplyThreshold int
+
+	// MaxImageBytes limits the decoded size of generated images
+	MaxImageBytes int
+	// MaxVideoBytes limits the size of video notes and animations sent to
+	// Gemini. Larger ones are answered from their thumbnail.
+	MaxVideoBytes int
+
+	PollTimeout  time.Duration
+	TextTimeout  time.Duration
+	ImageTimeout time.Duration
+	// PollRetryMax caps the backoff between failed polls
+	PollRetryMax time.Duration
+
+	// MaxHistoryMessages is the number of stored messages after which the
+	// history is trimmed down to the most recent KeepHistoryMessages
+	MaxHistoryMessages  int
+	KeepHistoryMessages int
+	// ContextSummaryTokens is the estimated context size above which the
+	// oldest half of the history is replaced by a summary. 0 disables it.
+	ContextSummaryTokens int
+	// MaxContextTurns caps how many of the latest history turns are sent
+	// with a prompt, however short they are. 0 sends them all.
+	MaxContextTurns int
+	// MaxContinuations bounds how many times an answer cut off by the output
+	// token limit is continued automatically. 0 disables it.
+	MaxContinuations int
+	// DailyMessageLimit is the number of messages a user may send per UTC
+	// day. Admins are exempt and 0 means unlimited.
+	DailyMessageLimit int
+	// UserCooldown is the minimum time between a user's prompts. Admins are
+	// exempt and 0 disables it.
+	UserCooldown time.Duration
+	// MaxEditAge limits how old an edited message can be to still get a new
+	// answer
+	MaxEditAge time.Duration
+
+	// BotEnabled is the initial state of the switch admins flip with
+	// /enable and /disable. A disabled bot only answers admins.
+	BotEnabled bool
+
+	// AdminIDs are the Telegram users that receive /feedback reports
+	AdminIDs []int64
+
+	// AllowedIDs restricts the bot to these users when not empty, BlockedIDs
+	// are always rejected. Admins are always allowed. AccessDeniedMessage is
+	// the answer to rejected users, "off" rejects them silently.
+	AllowedIDs          []int64
+	BlockedIDs          []int64
+	AccessDeniedMessage string
+	// CommandAliases maps extra command names to the built-in commands they
+	// run, without the leading slash
+	CommandAliases map[string]string
+	// RatingButtons attaches 👍/👎 buttons under text replies
+	RatingButtons bool
+	// ReplyFooter is appended to the end of every text answer when set.
+	// CaptionFooter adds it to generated image captions as well.
+	ReplyFooter   string
+	CaptionFooter bool
+	// ChunkIndicator starts each message of an answer split into several,
+	// with {n} and {total} replaced by the position and count. Empty
+	// disables it.
+	ChunkIndicator string
+	// Greeting is sent once to users on their first message. Greetings holds
+	// translations keyed by Telegram language code, set with GREETING_<CODE>.
+	// No greeting is sent when both are empty.
+	Greeting  string
+	Greetings map[string]string
+	// StripRoleLabels are the labels, e.g. "model", removed when an answer
+	// starts with one followed by a colon
+	StripRoleLabels []string
+	// TTSVoice is the prebuilt Gemini voice /say speaks with
+	TTSVoice string
+
+	// PromptCacheSize and PromptCacheTTL control the cache of recent answers
+	// used to skip repeated identical prompts. A size of 0 disables it.
+	PromptCacheSize int
+	PromptCacheTTL  time.Duration
+	// PhotoCacheSize and PhotoCacheTTL control the cache of downloaded
+	// photos. A size of 0 disables it.
+	PhotoCacheSize int
+	PhotoCacheTTL  time.Duration
+	// SettingsCacheSize and SettingsCacheTTL control the caches of user
+	// and chat settings read on every message. A size of 0 disables them.
+	SettingsCacheSize int
+	SettingsCacheTTL  time.Duration
+
+	// SaveQueueSize is how many exchanges can wait to be written to the
+	// store before handlers block
+	SaveQueueSize int
+
+	// WorkerCount is the number of workers answering prompts, fed by a queue
+	// of WorkQueueSize updates. 0 answers every update in its own goroutine.
+	WorkerCount   int
+	WorkQueueSize int
+
+	// UpdateDedupWindow is the number of recent update IDs remembered to skip
+	// updates Telegram delivers twice. 0 disables it.
+	UpdateDedupWindow int
+}
+
+// LoadConfig reads the configuration from the environment and validates it.
+// All problems are reported at once so they can be fixed in a single pass.
+func LoadConfig() (*Config, error) {
+	var problems []string
+
+	cfg := &Config{
+		TelegramToken:        os.Getenv("TELEGRAM_TOKEN"),
+		GeminiAPIKey:         os.Getenv("GEMINI_TOKEN"),
+		APIKeySecret:         os.Getenv("API_KEY_SECRET"),
+		MokkyURL:             os.Getenv("MOKKY_URL"),
+		StoreTimeout:         envDuration("MOKKY_TIMEOUT", 10*time.Second, &problems),
+		StoreRetries:         envInt("MOKKY_RETRIES", 3, &problems),
+		BotMode:              envString("BOT_MODE", "polling"),
+		WebhookURL:           os.Getenv("WEBHOOK_URL"),
+		WebhookListen:        envString("WEBHOOK_LISTEN", ":8443"),
+		WebhookSecret:        os.Getenv("WEBHOOK_SECRET"),
+		WebhookTLSCert:       os.Getenv("WEBHOOK_TLS_CERT"),
+		WebhookTLSKey:        os.Getenv("WEBHOOK_TLS_KEY"),
+		HealthListen:         os.Getenv("HEALTH_LISTEN"),
+		GeminiAPIHost:        strings.TrimSuffix(envString("GEMINI_API_HOST", "https://generativelanguage.googleapis.com"), "/"),
+		GeminiAPIVersion:     strings.Trim(envString("GEMINI_API_VERSION", "v1beta"), "/"),
+		GeminiBaseURL:        strings.TrimSuffix(envString("GEMINI_BASE_URL", ""), "/"),
+		GeminiAuthMode:       strings.ToLower(envString("GEMINI_AUTH_MODE", geminiAuthQueryKey)),
+		MaxConcurrentGemini:  envInt("MAX_CONCURRENT_GEMINI", 8, &problems),
+		GeminiQueueSize:      envInt("GEMINI_QUEUE_SIZE", 32, &problems),
+		HTTPIdleConnsPerHost: envInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 16, &problems),
+		HTTPIdleConnTimeout:  envDuration("HTTP_IDLE_CONN_TIMEOUT", 90*time.Second, &problems),
+		HTTPKeepAlive:        envDuration("HTTP_KEEP_ALIVE", 30*time.Second, &problems),
+		GeminiQueueTimeout:   envDuration("GEMINI_QUEUE_TIMEOUT", 10*time.Second, &problems),
+		FallbackModel:        os.Getenv("FALLBACK_MODEL"),
+		Models:               envStringList("TEXT_MODELS", defaultModels),
+		LogFormat:            strings.ToLower(envString("LOG_FORMAT", logFormatText)),
+		MockGemini:           envBool("MOCK_GEMINI", false, &problems),
+		Stateless:            envBool("STATELESS", false, &problems),
+		SeparateImageStorage: os.Getenv("IMAGE_STORAGE") == "separate",
+		OutboundRate:         envFloat("OUTBOUND_RATE", 30, &problems),
+		ChatRate:             envFloat("CHAT_RATE", 1, &problems),
+		Streaming:            envBool("STREAMING", false, &problems),
+		StreamEditInterval:   envDuration("STREAM_EDIT_INTERVAL", 1500*time.Millisecond, &problems),
+		ThinkingPlaceholder:  envString("THINKING_PLACEHOLDER", "🤔 Thinking..."),
+		PlaceholderDelay:     envDuration("PLACEHOLDER_DELAY", 1500*time.Millisecond, &problems),
+		LongReplyThreshold:   envInt("LONG_REPLY_THRESHOLD", telegramMessageLimit, &problems),
+		MaxImageBytes:        envInt("MAX_IMAGE_BYTES", 10<<20, &problems),
+		MaxVideoBytes:        envInt("MAX_VIDEO_BYTES", 15<<20, &problems),
+		PollTimeout:          envDuration("POLL_TIMEOUT", 10*time.Second, &problems),
+		PollRetryMax:         envDuration("POLL_RETRY_MAX", time.Minute, &problems),
+		TextTimeout:          envDuration("GEMINI_TIMEOUT", 0, &problems),
+		ImageTimeout:         envDuration("IMAGE_TIMEOUT", 60*time.Second, &problems),
+		MaxHistoryMessages:   envInt("MAX_HISTORY_MESSAGES", 100, &problems),
+		KeepHistoryMessages:  envInt("KEEP_HISTORY_MESSAGES", 50, &problems),
+		ContextSummaryTokens: envInt("CONTEXT_SUMMARY_TOKENS", 24000, &problems),
+		MaxContextTurns:      envInt("MAX_CONTEXT_TURNS", 40, &problems),
+		MaxContinuations:     envInt("MAX_CONTINUATIONS", 2, &problems),
+		DailyMessageLimit:    envInt("DAILY_MESSAGE_LIMIT", 0, &problems),
+		UserCooldown:         time.Duration(envInt("USER_COOLDOWN_MS", 0, &problems)) * time.Millisecond,
+		MaxEditAge:           envDuration("MAX_EDIT_AGE", 48*time.Hour, &problems),
+		BotEnabled:           envBool("BOT_ENABLED", true, &problems),
+		AdminIDs:             envInt64List("ADMIN_IDS", &problems),
+		CommandAliases:       envAliases("COMMAND_ALIASES", &problems),
+		AllowedIDs:           envInt64List("ALLOWED_IDS", &problems),
+		BlockedIDs:           envInt64List("BLOCKED_IDS", &problems),
+		AccessDeniedMessage:  envString("ACCESS_DENIED_MESSAGE", "Sorry, you are not allowed to use this bot"),
+		ReplyFooter:          strings.TrimSpace(envString("REPLY_FOOTER", "")),
+		CaptionFooter:        envBool("CAPTION_FOOTER", false, &problems),
+		ChunkIndicator:       envChunkIndicator(),
+		Greeting:             strings.TrimSpace(envString("GREETING", "")),
+		Greetings:            envLocalized("GREETING_"),
+		StripRoleLabels:      envStringList("STRIP_ROLE_LABELS", defaultStripLabels),
+		TTSVoice:             envString("TTS_VOICE", "Kore"),
+		RatingButtons:        envBool("RATING_BUTTONS", false, &problems),
+		PromptCacheSize:      envInt("PROMPT_CACHE_SIZE", 256, &problems),
+		PromptCacheTTL:       envDuration("PROMPT_CACHE_TTL", 30*time.Second, &problems),
+		PhotoCacheSize:       envInt("PHOTO_CACHE_SIZE", 64, &problems),
+		PhotoCacheTTL:        envDuration("PHOTO_CACHE_TTL", 10*time.Minute, &problems),
+		SettingsCacheSize:    envInt("SETTINGS_CACHE_SIZE", 1024, &problems),
+		SettingsCacheTTL:     envDuration("SETTINGS_CACHE_TTL", 5*time.Minute, &problems),
+		SaveQueueSize:        envInt("SAVE_QUEUE_SIZE", 100, &problems),
+		WorkerCount:          envInt("WORKER_COUNT", 0, &problems),
+		WorkQueueSize:        envInt("WORK_QUEUE_SIZE", 100, &problems),
+		UpdateDedupWindow:    envInt("UPDATE_DEDUP_WINDOW", 1000, &problems),
+	}
+
+	if cfg.TelegramToken == "" {
+		problems = append(problems, "TELEGRAM_TOKEN is required")
+	}
+	if cfg.GeminiAPIKey == "" && !cfg.MockGemini {
+		problems = append(problems, "GEMINI_TOKEN is required unless MOCK_GEMINI=true")
+	}
+
+	if cfg.MokkyURL == "" {
+		problems = append(problems, "MOKKY_URL is required")
+	} else if u, err := url.Parse(cfg.MokkyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("MOKKY_URL %q is not a valid http(s) URL", cfg.MokkyURL))
+	} else if !strings.HasSuffix(cfg.MokkyURL, "/") {
+		// Collection names are appended directly to the base URL
+		cfg.MokkyURL += "/"
+	}
+
+	if u, err := url.Parse(cfg.GeminiAPIHost); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("GEMINI_API_HOST %q is not a valid http(s) URL", cfg.GeminiAPIHost))
+	}
+	if cfg.GeminiAPIVersion == "" {
+		problems = append(problems, "GEMINI_API_VERSION must not be empty")
+	}
+	if cfg.GeminiBaseURL != "" {
+		if u, err := url.Parse(cfg.GeminiBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("GEMINI_BASE_URL %q is not a valid http(s) URL", cfg.GeminiBaseURL))
+		}
+	}
+	if cfg.GeminiAuthMode != geminiAuthQueryKey && cfg.GeminiAuthMode != geminiAuthBearer {
+		problems = append(problems, fmt.Sprintf("GEMINI_AUTH_MODE must be %s or %s, got %q", geminiAuthQueryKey, geminiAuthBearer, cfg.GeminiAuthMode))
+	}
+
+	if cfg.StoreTimeout <= 0 {
+		problems = append(problems, "MOKKY_TIMEOUT must be positive")
+	}
+	if cfg.StoreRetries < 0 {
+		problems = append(problems, "MOKKY_RETRIES must not be negative")
+	}
+
+	if cfg.ThinkingPlaceholder == "off" {
+		cfg.ThinkingPlaceholder = ""
+	}
+	if utf8.RuneCountInString(cfg.ReplyFooter) > telegramCaptionLimit/2 {
+		problems = append(problems, fmt.Sprintf("REPLY_FOOTER must be at most %d characters", telegramCaptionLimit/2))
+	}
+	if cfg.AccessDeniedMessage == "off" {
+		cfg.AccessDeniedMessage = ""
+	}
+
+	switch cfg.BotMode {
+	case "polling":
+	case "webhook":
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			problems = append(problems, "WEBHOOK_URL must be a public https URL in webhook mode")
+		}
+		if (cfg.WebhookTLSCert == "") != (cfg.WebhookTLSKey == "") {
+			problems = append(problems, "WEBHOOK_TLS_CERT and WEBHOOK_TLS_KEY must be set together")
+		}
+		if cfg.HealthListen != "" && cfg.HealthListen == cfg.WebhookListen {
+			problems = append(problems, "HEALTH_LISTEN must differ from WEBHOOK_LISTEN")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("BOT_MODE must be polling or webhook, got %q", cfg.BotMode))
+	}
+
+	if cfg.LogFormat != logFormatText && cfg.LogFormat != logFormatJSON {
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT must be text or json, got %q", cfg.LogFormat))
+	}
+	if storage := os.Getenv("IMAGE_STORAGE"); storage != "" && storage != "inline" && storage != "separate" {
+		problems = append(problems, fmt.Sprintf("IMAGE_STORAGE must be inline or separate, got %q", storage))
+	}
+	if cfg.OutboundRate <= 0 || cfg.ChatRate <= 0 {
+		problems = append(problems, "OUTBOUND_RATE and CHAT_RATE must be positive")
+	}
+	if cfg.MaxConcurrentGemini < 0 || cfg.GeminiQueueSize < 0 {
+		problems = append(problems, "MAX_CONCURRENT_GEMINI and GEMINI_QUEUE_SIZE must not be negative")
+	}
+	if cfg.GeminiQueueTimeout <= 0 {
+		problems = append(problems, "GEMINI_QUEUE_TIMEOUT must be positive")
+	}
+	if cfg.HTTPIdleConnsPerHost <= 0 || cfg.HTTPIdleConnTimeout <= 0 || cfg.HTTPKeepAlive <= 0 {
+		problems = append(problems, "HTTP_MAX_IDLE_CONNS_PER_HOST, HTTP_IDLE_CONN_TIMEOUT and HTTP_KEEP_ALIVE must be positive")
+	}
+	if cfg.StreamEditInterval <= 0 {
+		problems = append(problems, "STREAM_EDIT_INTERVAL must be positive")
+	}
+	if cfg.LongReplyThreshold <= 0 {
+		problems = append(problems, "LONG_REPLY_THRESHOLD must be positive")
+	}
+	if cfg.MaxImageBytes <= 0 || cfg.MaxVideoBytes <= 0 {
+		problems = append(problems, "MAX_IMAGE_BYTES and MAX_VIDEO_BYTES must be positive")
+	}
+	if cfg.PollTimeout <= 0 {
+		problems = append(problems, "POLL_TIMEOUT must be positive")
+	}
+	if cfg.PollRetryMax < pollRetryDelay {
+		problems = append(problems, "POLL_RETRY_MAX must be at least "+pollRetryDelay.String())
+	}
+	if cfg.TextTimeout < 0 || cfg.ImageTimeout < 0 {
+		problems = append(problems, "GEMINI_TIMEOUT and IMAGE_TIMEOUT must not be negative")
+	}
+	if cfg.MaxHistoryMessages <= 0 {
+		problems = append(problems, "MAX_HISTORY_MESSAGES must be positive")
+	}
+	if cfg.KeepHistoryMessages < 0 || cfg.KeepHistoryMessages > cfg.MaxHistoryMessages {
+		problems = append(problems, "KEEP_HISTORY_MESSAGES must be between 0 and MAX_HISTORY_MESSAGES")
+	}
+	if cfg.ContextSummaryTokens < 0 {
+		problems = append(problems, "CONTEXT_SUMMARY_TOKENS must not be negative")
+	}
+	if utf8.RuneCountInString(cfg.ChunkIndicator) > 100 {
+		problems = append(problems, "CHUNK_INDICATOR must be at most 100 characters")
+	}
+	for _, model := range cfg.Models {
+		if len(model) > maxModelNameLength {
+			problems = append(problems, fmt.Sprintf("TEXT_MODELS entry %q must be at most %d characters", model, maxModelNameLength))
+		}
+	}
+	if cfg.MaxContextTurns < 0 {
+		problems = append(problems, "MAX_CONTEXT_TURNS must not be negative")
+	}
+	if cfg.MaxContinuations < 0 {
+		problems = append(problems, "MAX_CONTINUATIONS must not be negative")
+	}
+	if cfg.UserCooldown < 0 {
+		problems = append(problems, "USER_COOLDOWN_MS must not be negative")
+	}
+	if cfg.DailyMessageLimit < 0 {
+		problems = append(problems, "DAILY_MESSAGE_LIMIT must not be negative")
+	}
+	if cfg.PromptCacheSize < 0 || cfg.PromptCacheTTL < 0 {
+		problems = append(problems, "PROMPT_CACHE_SIZE and PROMPT_CACHE_TTL must not be negative")
+	}
+	if cfg.SettingsCacheSize < 0 || cfg.SettingsCacheTTL < 0 {
+		problems = append(problems, "SETTINGS_CACHE_SIZE and SETTINGS_CACHE_TTL must not be negative")
+	}
+	if cfg.PhotoCacheSize < 0 || cfg.PhotoCacheTTL < 0 {
+		problems = append(problems, "PHOTO_CACHE_SIZE and PHOTO_CACHE_TTL must not be negative")
+	}
+	if cfg.SaveQueueSize < 0 {
+		problems = append(problems, "SAVE_QUEUE_SIZE must not be negative")
+	}
+	if cfg.WorkerCount < 0 || cfg.WorkQueueSize < 0 {
+		problems = append(problems, "WORKER_COUNT and WORK_QUEUE_SIZE must not be negative")
+	}
+	if cfg.UpdateDedupWindow < 0 {
+		problems = append(problems, "UPDATE_DEDUP_WINDOW must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	return cfg, nil
+}
+
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+func envBool(key string, def bool, problems *[]string) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be true or false, got %q", key, value))
+		return def
+	}
+	return b
+}
+
+func envInt(key string, def int, problems *[]string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be an integer, got %q", key, value))
+		return def
+	}
+	return n
+}
+
+func envFloat(key string, def float64, problems *[]string) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be a number, got %q", key, value))
+		return def
+	}
+	return f
+}
+
+func envDuration(key string, def time.Duration, problems *[]string) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be a duration like 30s, got %q", key, value))
+		return def
+	}
+	return d
+}
+
+// envInt64List parses a comma separated list of IDs.
+func envInt64List(key string, problems *[]string) []int64 {
+	var ids []int64
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s must be a comma separated list of IDs, got %q", key, field))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// envChunkIndicator returns the CHUNK_INDICATOR format, "({n}/{total})" by
+// default. "off" disables the indicator.
+func envChunkIndicator() string {
+	format := strings.TrimSpace(envString("CHUNK_INDICATOR", "({n}/{total})"))
+	if strings.EqualFold(format, "off") {
+		return ""
+	}
+	return format
+}
+
+// envStringList parses a comma separated list of strings, returning def when
+// the variable is unset. Setting it to "none" gives an empty list.
+func envStringList(key string, def []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	if strings.EqualFold(strings.TrimSpace(value), "none") {
+		return nil
+	}
+	var list []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// envLocalized collects the variables named prefix followed by a language
+// code, e.g. GREETING_RU or GREETING_PT_BR, keyed by the lowercase code with
+// dashes, e.g. "ru" or "pt-br".
+func envLocalized(prefix string) map[string]string {
+	texts := map[string]string{}
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		code, ok := strings.CutPrefix(key, prefix)
+		if !ok || code == "" || strings.TrimSpace(value) == "" {
+			continue
+		}
+		texts[strings.ReplaceAll(strings.ToLower(code), "_", "-")] = strings.TrimSpace(value)
+	}
+	return texts
+}
+
+// envAliases parses a comma separated list of alias=command pairs, e.g.
+// "img=generate,reset=history". Leading slashes are ignored.
+func envAliases(key string, problems *[]string) map[string]string {
+	aliases := map[string]string{}
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		alias, command, ok := strings.Cut(field, "=")
+		alias = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(alias)), "/")
+		command = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(command)), "/")
+		if !ok || !commandNamePattern.MatchString(alias) || !commandNamePattern.MatchString(command) {
+			*problems = append(*problems, fmt.Sprintf("%s must be a comma separated list of alias=command pairs, got %q", key, field))
+			continue
+		}
+		if _, exists := aliases[alias]; exists {
+			*problems = append(*problems, fmt.Sprintf("%s defines the alias %q more than once", key, alias))
+			continue
+		}
+		aliases[alias] = command
+	}
+	return aliases
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequiredEnv sets the settings LoadConfig can't do without.
+func setRequiredEnv(t *testing.T) {
+	t.Setenv("TELEGRAM_TOKEN", "123:abc")
+	t.Setenv("GEMINI_TOKEN", "key")
+	t.Setenv("MOKKY_URL", "https://mokky.example.com/api")
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.BotMode != "polling" {
+		t.Errorf("BotMode = %q, want polling", cfg.BotMode)
+	}
+	if cfg.PollTimeout != 10*time.Second {
+		t.Errorf("PollTimeout = %v, want 10s", cfg.PollTimeout)
+	}
+	if cfg.GeminiQueueTimeout != 10*time.Second {
+		t.Errorf("GeminiQueueTimeout = %v, want 10s", cfg.GeminiQueueTimeout)
+	}
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"missing token", map[string]string{"TELEGRAM_TOKEN": ""}, "TELEGRAM_TOKEN is required"},
+		{"invalid store URL", map[string]string{"MOKKY_URL": "mokky"}, "MOKKY_URL"},
+		{"invalid integer", map[string]string{"MOKKY_RETRIES": "three"}, "MOKKY_RETRIES must be an integer"},
+		{"invalid duration", map[string]string{"POLL_TIMEOUT": "10"}, "POLL_TIMEOUT must be a duration"},
+		{"invalid bool", map[string]string{"STATELESS": "maybe"}, "STATELESS must be true or false"},
+		{"unknown bot mode", map[string]string{"BOT_MODE": "push"}, "BOT_MODE must be polling or webhook"},
+		{"webhook without URL", map[string]string{"BOT_MODE": "webhook"}, "WEBHOOK_URL must be a public https URL"},
+		{"health on webhook port", map[string]string{"BOT_MODE": "webhook", "WEBHOOK_URL": "https://bot.example.com", "HEALTH_LISTEN": ":8443"}, "HEALTH_LISTEN must differ"},
+		{"zero queue timeout", map[string]string{"GEMINI_QUEUE_TIMEOUT": "0s"}, "GEMINI_QUEUE_TIMEOUT must be positive"},
+		{"negative context turns", map[string]string{"MAX_CONTEXT_TURNS": "-1"}, "MAX_CONTEXT_TURNS must not be negative"},
+		{"keep above max", map[string]string{"MAX_HISTORY_MESSAGES": "10", "KEEP_HISTORY_MESSAGES": "20"}, "KEEP_HISTORY_MESSAGES"},
+		{"unknown log format", map[string]string{"LOG_FORMAT": "xml"}, "LOG_FORMAT must be text or json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := LoadConfig()
+			if err == nil {
+				t.Fatalf("LoadConfig() error = nil, want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("TELEGRAM_TOKEN", "")
+	t.Setenv("MOKKY_RETRIES", "-1")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() error = nil")
+	}
+	for _, want := range []string{"TELEGRAM_TOKEN", "MOKKY_RETRIES"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadConfig() error = %v, want it to mention %s", err, want)
+		}
+	}
+}
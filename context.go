@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// contextSnippetLength is how much of every turn /context shows.
+const contextSnippetLength = 120
+
+// contextHandler shows with /context which turns of the history the next
+// request would send to Gemini, after trimming, with rough token estimates.
+// Image data is never shown, only noted.
+func contextHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		messages, err := getUserMessages(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting previous messages", "err", err)
+			return c.Send("Error loading your history")
+		}
+		if len(messages) == 0 {
+			return c.Send("Your history is empty, the next message is sent without context")
+		}
+
+		var sb strings.Builder
+		dropped := 0
+		if len(messages) > cfg.MaxHistoryMessages {
+			kept := trimHistory(messages, cfg.KeepHistoryMessages)
+			dropped = len(messages) - len(kept)
+			messages = kept
+		}
+		summarized := cfg.ContextSummaryTokens > 0 && estimateTokens(messages) > cfg.ContextSummaryTokens
+
+		// The same cut buildTextRequest makes before sending
+		sent := recentTurns(messages, cfg.MaxContextTurns)
+		limited := len(messages) - len(sent)
+
+		fmt.Fprintf(&sb, "The next request sends %d turns, about %d tokens:\n\n", len(sent), estimateTokens(sent))
+		for i, msg := range sent {
+			fmt.Fprintf(&sb, "%d. %s (~%d tokens)%s: %s\n", i+1, msg.Role, estimateTokens([]Message{msg}), contextMarkers(msg), contextSnippet(msg.Message))
+		}
+
+		if dropped > 0 {
+			fmt.Fprintf(&sb, "\nThe %d oldest turns are dropped because the history is longer than %d messages.", dropped, cfg.MaxHistoryMessages)
+		}
+		if limited > 0 {
+			fmt.Fprintf(&sb, "\nThe %d older turns are kept but not sent, only the last %d turns are.", limited, cfg.MaxContextTurns)
+		}
+		if summarized {
+			fmt.Fprintf(&sb, "\nThe context is over %d tokens, so the oldest half will be replaced by a summary.", cfg.ContextSummaryTokens)
+		}
+
+		for _, chunk := range splitMessage(sb.String(), telegramMessageLimit) {
+			if err := c.Send(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// contextMarkers notes what a turn carries besides its text.
+func contextMarkers(msg Message) string {
+	var markers []string
+	if msg.Image != nil || msg.ImageRef != 0 {
+		markers = append(markers, "image")
+	}
+	if msg.Summary {
+		markers = append(markers, "summary")
+	}
+	if len(markers) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(markers, ", ") + "]"
+}
+
+// contextSnippet shortens a turn to a single line for /context.
+func contextSnippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > contextSnippetLength {
+		text = string([]rune(text)[:contextSnippetLength]) + "..."
+	}
+	return text
+}
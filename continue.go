@@ -0,0 +1,31 @@
+package main
+
+// continuePrompt asks the model to go on with an answer that was cut off by
+// the output token limit.
+const continuePrompt = "Your previous answer was cut off. Continue exactly where it stopped, without repeating anything or adding an introduction."
+
+// truncated reports whether a candidate stopped because it ran out of output
+// tokens.
+func truncated(finishReason string) bool {
+	return finishReason == "MAX_TOKENS"
+}
+
+// canContinue reports whether an answer that stopped with finishReason should
+// be continued, given it was already continued continuations times. Answers
+// the user limited with /maxtokens and answers with several candidates are
+// left as they are.
+func canContinue(cfg *Config, opts ReplyOptions, finishReason string, continuations int) bool {
+	return truncated(finishReason) &&
+		continuations < cfg.MaxContinuations &&
+		opts.MaxOutputTokens == 0 &&
+		opts.CandidateCount <= 1
+}
+
+// continueContents returns contents extended with the truncated model turn
+// and a user turn asking for the rest of it.
+func continueContents(contents []Content, role string, parts []Part) []Content {
+	return append(contents,
+		Content{Role: responseRole(role), Parts: parts},
+		Content{Role: "user", Parts: []Part{{Text: continuePrompt}}},
+	)
+}
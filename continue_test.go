@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCanContinue(t *testing.T) {
+	cfg := &Config{MaxContinuations: 2}
+
+	tests := []struct {
+		name          string
+		finishReason  string
+		opts          ReplyOptions
+		continuations int
+		want          bool
+	}{
+		{"out of tokens", "MAX_TOKENS", ReplyOptions{}, 0, true},
+		{"second continuation", "MAX_TOKENS", ReplyOptions{}, 1, true},
+		{"continuations used up", "MAX_TOKENS", ReplyOptions{}, 2, false},
+		{"finished", "STOP", ReplyOptions{}, 0, false},
+		{"blocked", "SAFETY", ReplyOptions{}, 0, false},
+		{"no reason", "", ReplyOptions{}, 0, false},
+		{"length chosen by the user", "MAX_TOKENS", ReplyOptions{MaxOutputTokens: 100}, 0, false},
+		{"several candidates", "MAX_TOKENS", ReplyOptions{CandidateCount: 3}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canContinue(cfg, tt.opts, tt.finishReason, tt.continuations); got != tt.want {
+				t.Errorf("canContinue(%q, %d) = %v, want %v", tt.finishReason, tt.continuations, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyContinuesTruncatedAnswer(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxContinuations int
+		pieces           []string
+		reasons          []string
+		want             string
+	}{
+		{"continued once", 2, []string{"The quick brown ", "fox."}, []string{"MAX_TOKENS", "STOP"}, "The quick brown fox."},
+		{"continued twice", 2, []string{"The quick ", "brown ", "fox."}, []string{"MAX_TOKENS", "MAX_TOKENS", "STOP"}, "The quick brown fox."},
+		{"continuations used up", 1, []string{"The quick ", "brown "}, []string{"MAX_TOKENS", "MAX_TOKENS"}, "The quick brown "},
+		{"blocked answer isn't continued", 2, []string{"The quick"}, []string{"SAFETY"}, "The quick"},
+		{"disabled", 0, []string{"The quick"}, []string{"MAX_TOKENS"}, "The quick"},
+	}
+
+	replies := map[string]func(cfg *Config) (Message, error){
+		"generate": func(cfg *Config) (Message, error) {
+			return generateReply(cfg, nil, "Tell me about the fox", ReplyOptions{Model: textModel})
+		},
+		"stream": func(cfg *Config) (Message, error) {
+			return streamReply(cfg, nil, "Tell me about the fox", ReplyOptions{Model: textModel}, func(string) {})
+		},
+	}
+
+	for name, reply := range replies {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				var requests []GeminiRequest
+				cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+					var req GeminiRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					i := len(requests)
+					requests = append(requests, req)
+					if i >= len(tt.pieces) {
+						t.Errorf("unexpected request %d", i+1)
+						http.Error(w, "unexpected", http.StatusInternalServerError)
+						return
+					}
+
+					text, _ := json.Marshal(tt.pieces[i])
+					body := fmt.Sprintf(`{"candidates":[{"content":{"role":"model","parts":[{"text":%s}]},"finishReason":%q}]}`, text, tt.reasons[i])
+					if strings.Contains(r.URL.Path, "stream") {
+						body = "data: " + body + "\n\n"
+					}
+					fmt.Fprint(w, body)
+				})
+				cfg.MaxContinuations = tt.maxContinuations
+
+				got, err := reply(cfg)
+				if err != nil {
+					t.Fatalf("error = %v", err)
+				}
+				if got.Message != tt.want {
+					t.Errorf("reply = %q, want %q", got.Message, tt.want)
+				}
+				if len(requests) != len(tt.pieces) {
+					t.Fatalf("sent %d requests, want %d", len(requests), len(tt.pieces))
+				}
+				for i, req := range requests[1:] {
+					last := req.Contents[len(req.Contents)-1]
+					if last.Role != "user" || candidateText(last.Parts) != continuePrompt {
+						t.Errorf("request %d ends with %+v, want the continue prompt", i+2, last)
+					}
+				}
+			})
+		}
+	}
+}
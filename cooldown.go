@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// cooldownSweepSize is the number of tracked users above which entries whose
+// cooldown has passed are dropped.
+const cooldownSweepSize = 10000
+
+// userCooldown enforces USER_COOLDOWN_MS between the messages a user sends to
+// the handlers that call Gemini. The time of the last handled message is kept
+// in memory only.
+type userCooldown struct {
+	cfg      *Config
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+// newUserCooldown returns the cooldown for USER_COOLDOWN_MS, or nil, meaning
+// no cooldown, when it is 0.
+func newUserCooldown(cfg *Config) *userCooldown {
+	if cfg.UserCooldown <= 0 {
+		return nil
+	}
+	return &userCooldown{cfg: cfg, interval: cfg.UserCooldown, last: map[int64]time.Time{}}
+}
+
+// allow records a message of user at now and reports whether it came at
+// least the cooldown interval after the last allowed one.
+func (u *userCooldown) allow(user int64, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if last, ok := u.last[user]; ok && now.Sub(last) < u.interval {
+		return false
+	}
+	u.last[user] = now
+
+	if len(u.last) > cooldownSweepSize {
+		for id, t := range u.last {
+			if now.Sub(t) >= u.interval {
+				delete(u.last, id)
+			}
+		}
+	}
+	return true
+}
+
+// middleware asks users to slow down when they send messages faster than the
+// cooldown allows. Admins are exempt.
+func (u *userCooldown) middleware(next tele.HandlerFunc) tele.HandlerFunc {
+	if u == nil {
+		return next
+	}
+	return func(c tele.Context) error {
+		sender := c.Sender()
+		if sender == nil || isAdmin(u.cfg, sender) || u.allow(sender.ID, time.Now()) {
+			return next(c)
+		}
+		return c.Send("Please slow down and wait a moment before sending another message")
+	}
+}
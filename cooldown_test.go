@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserCooldownAllow(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  bool
+	}{
+		{"right away", 0, false},
+		{"just before the interval", time.Second - time.Millisecond, false},
+		{"at the interval", time.Second, true},
+		{"after the interval", 2 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUserCooldown(&Config{UserCooldown: time.Second})
+			if !u.allow(1, start) {
+				t.Fatal("first message was not allowed")
+			}
+			if got := u.allow(1, start.Add(tt.after)); got != tt.want {
+				t.Errorf("allow() after %v = %v, want %v", tt.after, got, tt.want)
+			}
+			// Other users have their own cooldown
+			if !u.allow(2, start.Add(tt.after)) {
+				t.Error("another user was not allowed")
+			}
+		})
+	}
+}
+
+func TestUserCooldownRejectedDoesNotExtend(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	u := newUserCooldown(&Config{UserCooldown: time.Second})
+
+	u.allow(1, start)
+	u.allow(1, start.Add(900*time.Millisecond))
+	if !u.allow(1, start.Add(time.Second)) {
+		t.Error("a rejected message extended the cooldown")
+	}
+}
+
+func TestUserCooldownSweep(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	u := newUserCooldown(&Config{UserCooldown: time.Second})
+
+	for id := int64(1); id <= cooldownSweepSize; id++ {
+		u.last[id] = start
+	}
+	// One user is still cooling down when the sweep runs
+	u.last[-1] = start.Add(time.Second)
+
+	now := start.Add(1500 * time.Millisecond)
+	if !u.allow(0, now) {
+		t.Fatal("new user was not allowed")
+	}
+	if got := len(u.last); got != 2 {
+		t.Errorf("%d users tracked after the sweep, want 2", got)
+	}
+	if u.allow(-1, now) {
+		t.Error("the sweep dropped a user still cooling down")
+	}
+	if u.allow(0, now) {
+		t.Error("the sweep dropped the user that triggered it")
+	}
+}
+
+func TestNewUserCooldownDisabled(t *testing.T) {
+	if u := newUserCooldown(&Config{}); u != nil {
+		t.Errorf("newUserCooldown() = %v, want nil without USER_COOLDOWN_MS", u)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// updateDedup remembers the IDs of the most recently handled updates so that
+// updates redelivered by Telegram, e.g. after a restart while polling, are not
+// answered and saved twice. Updates up to floor, the last one handled before
+// the restart, count as seen too.
+type updateDedup struct {
+	mu         sync.Mutex
+	window     int
+	floor      int
+	seen       map[int]struct{}
+	order      []int
+	next       int
+	checkpoint *updateCheckpoint
+}
+
+func newUpdateDedup(window int, checkpoint *updateCheckpoint) *updateDedup {
+	return &updateDedup{
+		window:     window,
+		floor:      checkpoint.LastID(),
+		seen:       make(map[int]struct{}, window),
+		order:      make([]int, 0, window),
+		checkpoint: checkpoint,
+	}
+}
+
+// firstSeen records id and reports whether it was not seen within the window.
+// A window of 0 or less disables deduplication.
+func (d *updateDedup) firstSeen(id int) bool {
+	if d.window <= 0 {
+		return true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.seen[id]; ok || id <= d.floor {
+		return false
+	}
+
+	if len(d.order) < d.window {
+		d.order = append(d.order, id)
+	} else {
+		delete(d.seen, d.order[d.next])
+		d.order[d.next] = id
+		d.next = (d.next + 1) % d.window
+	}
+	d.seen[id] = struct{}{}
+	return true
+}
+
+// updateDoneKey is the context key of the function that takes over marking
+// the update as handled, see takeUpdateDone.
+const updateDoneKey = "updateDone"
+
+// middleware skips updates that were already handled. An update is marked as
+// handled once next returns, or once the handler it was handed to finishes
+// when a middleware took that over with takeUpdateDone.
+func (d *updateDedup) middleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		id := c.Update().ID
+		if !d.firstSeen(id) {
+			updateLogger(c).Info("Skipping already handled update")
+			return nil
+		}
+
+		done := d.checkpoint.Start(id)
+		deferred := false
+		c.Set(updateDoneKey, func() func() {
+			deferred = true
+			return done
+		})
+		err := next(c)
+		if !deferred {
+			done()
+		}
+		return err
+	}
+}
+
+// takeUpdateDone is called by middleware that hands the update to another
+// goroutine. It returns the function marking the update as handled, which
+// the caller must call once the handler finished.
+func takeUpdateDone(c tele.Context) func() {
+	take, ok := c.Get(updateDoneKey).(func() func())
+	if !ok {
+		return func() {}
+	}
+	return take()
+}
+
+// checkpointInterval is how often the last handled update ID is written to
+// the store at most.
+const checkpointInterval = time.Second
+
+// BotState is the single record of the "state" collection, holding what the
+// bot needs to remember across restarts.
+type BotState struct {
+	ID           int64 `json:"id,omitempty"`
+	LastUpdateID int   `json:"lastUpdateId"`
+}
+
+// updateCheckpoint keeps the ID of the last handled update in the store, so
+// a restarted bot neither asks Telegram for nor handles the updates it already
+// handled. Updates are handled concurrently, so the stored ID is the highest
+// one below which every update is done: an update still queued or running
+// holds it back, and is handled again after a crash. Writes are coalesced to
+// one per checkpointInterval, and Close writes the last one.
+type updateCheckpoint struct {
+	cfg   *Config
+	mu    sync.Mutex
+	state BotState
+	// started is the highest update ID whose handling started, running
+	// holds the IDs not done yet
+	started int
+	running map[int]struct{}
+	saved   int
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// loadUpdateCheckpoint reads the last handled update ID from the store and
+// starts writing new ones. A store error starts from scratch.
+func loadUpdateCheckpoint(cfg *Config) *updateCheckpoint {
+	p := &updateCheckpoint{
+		cfg:     cfg,
+		running: make(map[int]struct{}),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	var states []BotState
+	if err := storeRequest(cfg, "GET", "state", nil, &states); err != nil {
+		slog.Error("Error loading the last handled update, redelivered updates may be handled again", "err", err)
+	} else if len(states) > 0 {
+		p.state = states[0]
+		p.saved = p.state.LastUpdateID
+		p.started = p.state.LastUpdateID
+		slog.Info("Resuming after the last handled update", "update_id", p.state.LastUpdateID)
+	}
+
+	go p.run()
+	return p
+}
+
+// LastID returns the ID of the last handled update, 0 if none is known.
+func (p *updateCheckpoint) LastID() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state.LastUpdateID
+}
+
+// Start records that handling the update id began and returns the function
+// marking it done.
+func (p *updateCheckpoint) Start(id int) func() {
+	if p == nil {
+		return func() {}
+	}
+	p.mu.Lock()
+	p.running[id] = struct{}{}
+	p.started = max(p.started, id)
+	p.mu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(func() { p.finish(id) }) }
+}
+
+// finish marks the update id as done and moves the checkpoint up to the
+// oldest update still running.
+func (p *updateCheckpoint) finish(id int) {
+	p.mu.Lock()
+	delete(p.running, id)
+	last := p.started
+	for running := range p.running {
+		last = min(last, running-1)
+	}
+	if last > p.state.LastUpdateID {
+		p.state.LastUpdateID = last
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *updateCheckpoint) run() {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.stop:
+			p.save()
+			return
+		case <-p.wake:
+			p.save()
+		}
+
+		select {
+		case <-p.stop:
+			p.save()
+			return
+		case <-time.After(checkpointInterval):
+		}
+	}
+}
+
+// save writes the last handled update ID if it changed since the last write.
+func (p *updateCheckpoint) save() {
+	p.mu.Lock()
+	state := p.state
+	p.mu.Unlock()
+	if state.LastUpdateID == p.saved {
+		return
+	}
+
+	method, path := "POST", "state"
+	if state.ID != 0 {
+		method, path = "PATCH", fmt.Sprintf("state/%d", state.ID)
+	}
+	var stored BotState
+	if err := storeRequest(p.cfg, method, path, state, &stored); err != nil {
+		slog.Error("Error saving the last handled update", "err", err)
+		return
+	}
+	p.saved = state.LastUpdateID
+
+	p.mu.Lock()
+	if p.state.ID == 0 {
+		p.state.ID = stored.ID
+	}
+	p.mu.Unlock()
+}
+
+// Close writes the last handled update ID and stops writing.
+func (p *updateCheckpoint) Close() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+}
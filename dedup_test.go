@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestUpdateDedupFirstSeen(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		floor  int
+		ids    []int
+		want   []bool
+	}{
+		{
+			name:   "new updates",
+			window: 3,
+			ids:    []int{1, 2, 3},
+			want:   []bool{true, true, true},
+		},
+		{
+			name:   "redelivered update",
+			window: 3,
+			ids:    []int{1, 2, 1, 2},
+			want:   []bool{true, true, false, false},
+		},
+		{
+			name:   "oldest update is evicted",
+			window: 2,
+			ids:    []int{1, 2, 3, 1, 3},
+			want:   []bool{true, true, true, true, false},
+		},
+		{
+			name:   "eviction wraps around",
+			window: 2,
+			ids:    []int{1, 2, 3, 4, 5, 4, 3},
+			want:   []bool{true, true, true, true, true, false, true},
+		},
+		{
+			name:   "updates up to the checkpoint were handled",
+			window: 3,
+			floor:  10,
+			ids:    []int{9, 10, 11, 11},
+			want:   []bool{false, false, true, false},
+		},
+		{
+			name:   "disabled",
+			window: 0,
+			ids:    []int{1, 1},
+			want:   []bool{true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newUpdateDedup(tt.window, nil)
+			d.floor = tt.floor
+			for i, id := range tt.ids {
+				if got := d.firstSeen(id); got != tt.want[i] {
+					t.Errorf("firstSeen(%d) at step %d = %v, want %v", id, i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// fakeStateStore serves the state collection of Mokky for one record.
+type fakeStateStore struct {
+	mu    sync.Mutex
+	state *BotState
+	// writes are the lastUpdateId values written, in order
+	writes []int
+}
+
+func (s *fakeStateStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		states := []BotState{}
+		if s.state != nil {
+			states = append(states, *s.state)
+		}
+		json.NewEncoder(w).Encode(states)
+	case http.MethodPost, http.MethodPatch:
+		body, _ := io.ReadAll(r.Body)
+		var state BotState
+		json.Unmarshal(body, &state)
+		if state.ID == 0 {
+			state.ID = 1
+		}
+		s.state = &state
+		s.writes = append(s.writes, state.LastUpdateID)
+		json.NewEncoder(w).Encode(state)
+	}
+}
+
+func TestUpdateCheckpoint(t *testing.T) {
+	store := &fakeStateStore{state: &BotState{ID: 1, LastUpdateID: 41}}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	cfg := &Config{MokkyURL: server.URL + "/", StoreTimeout: time.Second}
+
+	checkpoint := loadUpdateCheckpoint(cfg)
+	if got := checkpoint.LastID(); got != 41 {
+		t.Fatalf("LastID() = %d, want 41", got)
+	}
+
+	done42, done43, done44 := checkpoint.Start(42), checkpoint.Start(43), checkpoint.Start(44)
+	done42()
+	done44()
+	// 43 is still running, so a crash now has to handle it again
+	if got := checkpoint.LastID(); got != 42 {
+		t.Errorf("LastID() with 43 running = %d, want 42", got)
+	}
+	done43()
+	if got := checkpoint.LastID(); got != 44 {
+		t.Errorf("LastID() with all done = %d, want 44", got)
+	}
+	checkpoint.Close()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.writes) == 0 || store.writes[len(store.writes)-1] != 44 {
+		t.Errorf("written update IDs = %v, want the last to be 44", store.writes)
+	}
+}
+
+func TestUpdateCheckpointNil(t *testing.T) {
+	var checkpoint *updateCheckpoint
+	checkpoint.Start(1)()
+	checkpoint.Close()
+	if got := checkpoint.LastID(); got != 0 {
+		t.Errorf("LastID() = %d, want 0", got)
+	}
+}
+
+func TestUpdateDedupWithWorkerPool(t *testing.T) {
+	store := &fakeStateStore{}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	cfg := &Config{MokkyURL: server.URL + "/", StoreTimeout: time.Second, WorkerCount: 2, WorkQueueSize: 4}
+
+	b, _ := newTestBot(t)
+	checkpoint := loadUpdateCheckpoint(cfg)
+	pool := newWorkerPool(cfg)
+	release := make(chan struct{})
+	handler := newUpdateDedup(10, checkpoint).middleware(pool.middleware(func(c tele.Context) error {
+		if c.Update().ID == 1 {
+			<-release
+		}
+		return nil
+	}))
+
+	for _, id := range []int{1, 2} {
+		if err := handler(b.NewContext(tele.Update{ID: id})); err != nil {
+			t.Fatalf("handling update %d: %v", id, err)
+		}
+	}
+	// Both updates are only queued or running, nothing is handled yet
+	if got := checkpoint.LastID(); got != 0 {
+		t.Errorf("LastID() while update 1 runs = %d, want 0", got)
+	}
+
+	close(release)
+	pool.Close()
+	if got := checkpoint.LastID(); got != 2 {
+		t.Errorf("LastID() after the workers finished = %d, want 2", got)
+	}
+	checkpoint.Close()
+}
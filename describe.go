@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// ImageDescription is the structured description of a photo returned by
+// /describe.
+type ImageDescription struct {
+	Summary string   `json:"summary"`
+	Objects []string `json:"objects"`
+	Colors  []string `json:"colors"`
+	Text    []string `json:"text"`
+}
+
+// describeSchema is the response schema Gemini has to follow for /describe.
+var describeSchema = &Schema{
+	Type: "OBJECT",
+	Properties: map[string]*Schema{
+		"summary": {Type: "STRING", Description: "One or two sentences describing the image"},
+		"objects": {Type: "ARRAY", Items: &Schema{Type: "STRING"}, Description: "Notable objects in the image"},
+		"colors":  {Type: "ARRAY", Items: &Schema{Type: "STRING"}, Description: "Dominant colors"},
+		"text":    {Type: "ARRAY", Items: &Schema{Type: "STRING"}, Description: "Text visible in the image, if any"},
+	},
+	Required: []string{"summary", "objects", "colors", "text"},
+}
+
+// describeHandler answers /describe sent as a reply to a photo with a
+// structured description of it.
+func describeHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		cfg := userConfig(cfg, c.Sender())
+		replyTo := c.Message().ReplyTo
+		if replyTo == nil || replyTo.Photo == nil {
+			return c.Send("Please reply to a photo with /describe")
+		}
+
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+
+		stopTyping := keepTyping(c)
+		defer stopTyping()
+
+		imageData, err := downloadPhoto(b, replyTo.Photo)
+		if err != nil {
+			updateLogger(c).Error("Error downloading photo to describe", "err", err)
+			return c.Send("Couldn't fetch the image, please send it again")
+		}
+
+		desc, err := describeImage(cfg, userModel(cfg, settings), imageData)
+		stopTyping()
+		if err != nil {
+			updateLogger(c).Error("Error describing image", "err", err)
+			return c.Send(replyErrorMessage(err))
+		}
+
+		return c.Send(formatDescription(desc))
+	}
+}
+
+// describeImage asks model for a JSON description of an image and validates
+// the result.
+func describeImage(cfg *Config, model string, imageData *FileData) (*ImageDescription, error) {
+	desc, err := generateStructured[ImageDescription](cfg, model,
+		"Describe the image. List the notable objects, the dominant colors and any text you can read in it.",
+		[]Part{{InlineData: imageData}},
+		describeSchema,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(desc.Summary) == "" {
+		return nil, fmt.Errorf("%w: description has no summary", errDecodeResponse)
+	}
+	return &desc, nil
+}
+
+// formatDescription renders a description as a readable message.
+func formatDescription(desc *ImageDescription) string {
+	var sb strings.Builder
+	sb.WriteString(strings.TrimSpace(desc.Summary))
+
+	for _, field := range []struct {
+		name   string
+		values []string
+	}{
+		{"Objects", desc.Objects},
+		{"Colors", desc.Colors},
+		{"Text", desc.Text},
+	} {
+		if len(field.values) > 0 {
+			fmt.Fprintf(&sb, "\n\n%s: %s", field.name, strings.Join(field.values, ", "))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// offlineHistoryLimit is how many turns per user are kept in memory while the
+// store is unreachable.
+const offlineHistoryLimit = 50
+
+// offlineNotice tells users once per outage that their history isn't saved.
+const offlineNotice = "I can't reach my storage right now, so this conversation is only kept in memory for now and will be saved once it's back"
+
+// memoryHistory keeps the exchanges that couldn't be saved while the store was
+// unreachable, so conversations keep their context. They are written to the
+// store once it can be reached again.
+type memoryHistory struct {
+	mu       sync.Mutex
+	turns    *lruCache[int64, []Message]
+	notified map[int64]bool
+}
+
+// offlineHistory is the history kept while the store is down.
+var offlineHistory = newMemoryHistory()
+
+func newMemoryHistory() *memoryHistory {
+	return &memoryHistory{
+		turns:    newLRUCache[int64, []Message](1000, 24*time.Hour),
+		notified: make(map[int64]bool),
+	}
+}
+
+// Append keeps an exchange of the user that couldn't be saved.
+func (h *memoryHistory) Append(telegramID int64, userTurn, modelTurn Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	turns, _ := h.turns.Get(telegramID)
+	turns = append(turns, userTurn, modelTurn)
+	if len(turns) > offlineHistoryLimit {
+		turns = turns[len(turns)-offlineHistoryLimit:]
+	}
+	h.turns.Set(telegramID, turns)
+}
+
+// Messages returns the unsaved exchanges of the user.
+func (h *memoryHistory) Messages(telegramID int64) []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	turns, _ := h.turns.Get(telegramID)
+	return append([]Message(nil), turns...)
+}
+
+// Take returns the unsaved exchanges of the user and forgets them, so they
+// can be written to the store. The user will be notified again on the next
+// outage.
+func (h *memoryHistory) Take(telegramID int64) []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	turns, _ := h.turns.Get(telegramID)
+	h.turns.Delete(telegramID)
+	delete(h.notified, telegramID)
+	return turns
+}
+
+// ShouldNotify reports whether the user still has to be told that the history
+// isn't being saved, and marks them as told.
+func (h *memoryHistory) ShouldNotify(telegramID int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.notified[telegramID] {
+		return false
+	}
+	h.notified[telegramID] = true
+	return true
+}
+
+// restoreOfflineHistory queues the exchanges kept in memory during an outage
+// for saving, now that the store answered again, and returns messages with
+// them appended.
+func restoreOfflineHistory(cfg *Config, sender *tele.User, messages []Message) []Message {
+	turns := offlineHistory.Take(sender.ID)
+	for i := 0; i+1 < len(turns); i += 2 {
+		historySaves.Save(cfg, sender.ID, sender, turns[i], turns[i+1])
+	}
+	return append(messages, turns...)
+}
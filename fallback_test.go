@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestMemoryHistoryAppend(t *testing.T) {
+	h := newMemoryHistory()
+	for i := 0; i < offlineHistoryLimit; i++ {
+		userTurn, modelTurn := exchange(i)
+		h.Append(1, userTurn, modelTurn)
+	}
+
+	got := h.Messages(1)
+	if len(got) != offlineHistoryLimit {
+		t.Fatalf("kept %d turns, want %d", len(got), offlineHistoryLimit)
+	}
+	// The oldest exchanges make room for new ones
+	if want := "question 25"; got[0].Message != want {
+		t.Errorf("oldest kept turn = %q, want %q", got[0].Message, want)
+	}
+	if want := "answer 49"; got[len(got)-1].Message != want {
+		t.Errorf("newest kept turn = %q, want %q", got[len(got)-1].Message, want)
+	}
+
+	// Messages returns a copy
+	got[0].Message = "changed"
+	if h.Messages(1)[0].Message == "changed" {
+		t.Error("changing the result of Messages changed the kept history")
+	}
+
+	if got := h.Messages(2); len(got) != 0 {
+		t.Errorf("Messages() of another user = %v, want none", got)
+	}
+}
+
+func TestMemoryHistoryTake(t *testing.T) {
+	h := newMemoryHistory()
+	userTurn, modelTurn := exchange(0)
+	h.Append(1, userTurn, modelTurn)
+	if !h.ShouldNotify(1) {
+		t.Error("ShouldNotify() = false on the first unsaved exchange, want true")
+	}
+	if h.ShouldNotify(1) {
+		t.Error("ShouldNotify() = true a second time, want false")
+	}
+
+	if got := h.Take(1); len(got) != 2 {
+		t.Errorf("Take() = %v, want the exchange", got)
+	}
+	if got := h.Messages(1); len(got) != 0 {
+		t.Errorf("Messages() after Take = %v, want none", got)
+	}
+	// The next outage notifies again
+	if !h.ShouldNotify(1) {
+		t.Error("ShouldNotify() = false after Take, want true")
+	}
+}
+
+func TestRestoreOfflineHistory(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	sender := &tele.User{ID: 1, Username: "alice"}
+	for i := 1; i <= 2; i++ {
+		userTurn, modelTurn := exchange(i)
+		offlineHistory.Append(sender.ID, userTurn, modelTurn)
+	}
+	t.Cleanup(func() { offlineHistory.Take(sender.ID) })
+
+	stored, _ := exchange(0)
+	got := restoreOfflineHistory(cfg, sender, []Message{stored})
+	if len(got) != 5 || got[4].Message != "answer 2" {
+		t.Errorf("restoreOfflineHistory() = %v, want the stored turn and both exchanges", got)
+	}
+
+	// Without a queue the exchanges are written right away
+	if saved := store.messages(sender.ID); len(saved) != 4 {
+		t.Errorf("saved %d turns, want 4", len(saved))
+	}
+	if left := offlineHistory.Messages(sender.ID); len(left) != 0 {
+		t.Errorf("turns left in memory = %v, want none", left)
+	}
+}
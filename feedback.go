@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+var (
+	ratingMenu     = &tele.ReplyMarkup{}
+	btnRateUp      = ratingMenu.Data("👍", "rate", "up")
+	btnRateDown    = ratingMenu.Data("👎", "rate", "down")
+	feedbackUsage  = "Please describe the problem or idea. Example: /feedback the answer about Go generics was wrong"
+	feedbackThanks = "Thanks, your feedback was sent to the bot admins!"
+)
+
+func init() {
+	ratingMenu.Inline(ratingMenu.Row(btnRateUp, btnRateDown))
+}
+
+// ratingMarkup returns the rating keyboard attached under bot replies, or nil
+// when rating buttons are disabled.
+func ratingMarkup(cfg *Config) *tele.ReplyMarkup {
+	if !cfg.RatingButtons {
+		return nil
+	}
+	return ratingMenu
+}
+
+// senderName returns a readable name for a Telegram user.
+func senderName(sender *tele.User) string {
+	if sender.Username != "" {
+		return "@" + sender.Username
+	}
+	if sender.FirstName != "" {
+		return sender.FirstName
+	}
+	return fmt.Sprint(sender.ID)
+}
+
+// lastExchange returns the last user message and the answer following it.
+func lastExchange(messages []Message) (userMsg, modelMsg string) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			userMsg = messages[i].Message
+			if i+1 < len(messages) {
+				modelMsg = messages[i+1].Message
+			}
+			return userMsg, modelMsg
+		}
+	}
+	return "", ""
+}
+
+// feedbackHandler forwards /feedback messages together with the user's last
+// exchange to every admin.
+func feedbackHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		text := strings.TrimSpace(c.Message().Payload)
+		if text == "" {
+			return c.Send(feedbackUsage)
+		}
+
+		if len(cfg.AdminIDs) == 0 {
+			updateLogger(c).Warn("Feedback dropped, no ADMIN_IDS configured", "feedback", text)
+			return c.Send("Feedback isn't enabled for this bot")
+		}
+
+		var report strings.Builder
+		fmt.Fprintf(&report, "Feedback from %s (ID %d):\n%s", senderName(c.Sender()), c.Sender().ID, text)
+
+		messages, err := getUserMessages(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting messages for feedback", "err", err)
+		}
+		if userMsg, modelMsg := lastExchange(messages); userMsg != "" {
+			fmt.Fprintf(&report, "\n\nLast exchange:\nUser: %s\nBot: %s", userMsg, modelMsg)
+		}
+
+		sent := 0
+		for _, adminID := range cfg.AdminIDs {
+			if _, err := b.Send(tele.ChatID(adminID), report.String()); err != nil {
+				updateLogger(c).Error("Error forwarding feedback", "admin_id", adminID, "err", err)
+				continue
+			}
+			sent++
+		}
+
+		if sent == 0 {
+			return c.Send("Sorry, your feedback couldn't be delivered. Please try again later")
+		}
+		return c.Send(feedbackThanks)
+	}
+}
+
+// rateHandler stores the rating chosen with the inline buttons on the model
+// turn the buttons were attached to.
+func rateHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		rating := c.Data()
+		if rating != "up" && rating != "down" {
+			return c.Respond()
+		}
+
+		if err := rateMessage(cfg, c.Sender().ID, c.Chat().ID, c.Message().ID, rating); err != nil {
+			updateLogger(c).Error("Error saving rating", "err", err)
+			return c.Respond(&tele.CallbackResponse{Text: "Couldn't save your rating"})
+		}
+
+		if _, err := b.EditReplyMarkup(c.Message(), nil); err != nil {
+			updateLogger(c).Error("Error removing rating buttons", "err", err)
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "Thanks for the rating!"})
+	}
+}
+
+// errTurnNotFound is returned when no model turn was sent as a given message.
+var errTurnNotFound = errors.New("model turn not found in history")
+
+// rateMessage sets the rating of the model turn that was sent as the given
+// Telegram message of chatID.
+func rateMessage(cfg *Config, telegramID, chatID int64, replyMsgID int, rating string) error {
+	historySaves.Wait(telegramID)
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("no history found for this user")
+	}
+
+	messages := user.SessionMessages()
+	for i := range messages {
+		if messages[i].sentAs(telegramID, chatID, replyMsgID) {
+			messages[i].Rating = rating
+			return patchHistory(cfg, user)
+		}
+	}
+	return fmt.Errorf("%w: message %d", errTurnNotFound, replyMsgID)
+}
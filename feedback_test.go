@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestRateHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		msgID       int
+		rating      string
+		wantAnswer  string
+		wantRatings []string
+	}{
+		{"thumbs up", 5, "up", "Thanks for the rating!", []string{"", "", "", "up"}},
+		{"thumbs down on an older answer", 3, "down", "Thanks for the rating!", []string{"", "down", "", ""}},
+		{"unknown message", 42, "up", "Couldn't save your rating", []string{"", "", "", ""}},
+		{"unknown rating", 5, "meh", "", []string{"", "", "", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, cfg := newFakeUserStore(t, 0)
+			store.users[1] = &UserMessages{ID: 1, TelegramID: 7, Messages: []Message{
+				{Role: "user", Message: "first question", MessageID: 2},
+				{Role: "model", Message: "first answer", MessageID: 3, ChatID: 7},
+				{Role: "user", Message: "second question", MessageID: 4},
+				{Role: "model", Message: "second answer", MessageID: 5, ChatID: 7},
+			}}
+			b, api := newTestBot(t)
+
+			c := b.NewContext(tele.Update{Callback: &tele.Callback{
+				ID:      "1",
+				Sender:  &tele.User{ID: 7},
+				Message: &tele.Message{ID: tt.msgID, Chat: &tele.Chat{ID: 7, Type: tele.ChatPrivate}},
+				Data:    tt.rating,
+			}})
+			if err := rateHandler(b, cfg)(c); err != nil {
+				t.Fatalf("rateHandler() error = %v", err)
+			}
+
+			answers := api.Calls("answerCallbackQuery")
+			if len(answers) != 1 || answers[0].params["text"] != tt.wantAnswer {
+				t.Errorf("callback answers = %v, want %q", answers, tt.wantAnswer)
+			}
+			var ratings []string
+			for _, msg := range store.messages(7) {
+				ratings = append(ratings, msg.Rating)
+			}
+			for i, want := range tt.wantRatings {
+				if ratings[i] != want {
+					t.Errorf("turn %d rating = %q, want %q", i+1, ratings[i], want)
+				}
+			}
+			rated := tt.wantAnswer == "Thanks for the rating!"
+			if removed := len(api.Calls("editMessageReplyMarkup")) > 0; removed != rated {
+				t.Errorf("rating buttons removed = %v, want %v", removed, rated)
+			}
+		})
+	}
+}
+
+func TestLastExchange(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  []Message
+		wantUser  string
+		wantModel string
+	}{
+		{"no messages", nil, "", ""},
+		{"answered", []Message{{Role: "user", Message: "a"}, {Role: "model", Message: "b"}, {Role: "user", Message: "c"}, {Role: "model", Message: "d"}}, "c", "d"},
+		{"unanswered", []Message{{Role: "user", Message: "a"}, {Role: "model", Message: "b"}, {Role: "user", Message: "c"}}, "c", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userMsg, modelMsg := lastExchange(tt.messages)
+			if userMsg != tt.wantUser || modelMsg != tt.wantModel {
+				t.Errorf("lastExchange() = %q, %q, want %q, %q", userMsg, modelMsg, tt.wantUser, tt.wantModel)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// isForwarded reports whether m was forwarded from another chat or user,
+// including users who hide their account in forwards.
+func isForwarded(m *tele.Message) bool {
+	return m.Origin != nil || m.IsForwarded() || m.OriginalSenderName != ""
+}
+
+// forwardSource returns the name of the user or chat a forwarded message
+// came from, or an empty string if it is unknown.
+func forwardSource(m *tele.Message) string {
+	if o := m.Origin; o != nil {
+		switch {
+		case o.Sender != nil:
+			return displayName(o.Sender)
+		case o.SenderUsername != "":
+			return o.SenderUsername
+		case o.SenderChat != nil:
+			return o.SenderChat.Title
+		case o.Chat != nil:
+			return o.Chat.Title
+		}
+	}
+	switch {
+	case m.OriginalSender != nil:
+		return displayName(m.OriginalSender)
+	case m.OriginalChat != nil:
+		return m.OriginalChat.Title
+	default:
+		return m.OriginalSenderName
+	}
+}
+
+// displayName returns the full name of a user, or the username if the name
+// is empty.
+func displayName(u *tele.User) string {
+	if name := strings.TrimSpace(u.FirstName + " " + u.LastName); name != "" {
+		return name
+	}
+	return u.Username
+}
+
+// forwardedContent wraps the content of a forwarded message in a note naming
+// where it came from, so the model knows the user didn't write it.
+func forwardedContent(m *tele.Message, content string) string {
+	header := "Forwarded message"
+	if source := forwardSource(m); source != "" {
+		header += " from " + source
+	}
+	return header + ":\n" + content
+}
+
+// withForwardedContext returns the prompt for message m with text prompt.
+// Forwarded messages are marked as such, and a question sent as a reply to a
+// forwarded message gets the forwarded text as context.
+func withForwardedContext(m *tele.Message, prompt string) string {
+	if isForwarded(m) {
+		return forwardedContent(m, prompt)
+	}
+
+	replyTo := m.ReplyTo
+	if replyTo == nil || !isForwarded(replyTo) {
+		return prompt
+	}
+	content := replyTo.Text
+	if content == "" {
+		content = replyTo.Caption
+	}
+	if content == "" && replyTo.Photo == nil {
+		return prompt
+	}
+	return forwardedContent(replyTo, content) + "\n\n" + prompt
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestWithForwardedContext(t *testing.T) {
+	forwarded := func(text string) *tele.Message {
+		return &tele.Message{
+			Text:   text,
+			Origin: &tele.MessageOrigin{Type: "user", Sender: &tele.User{FirstName: "Bob", LastName: "Smith"}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		message *tele.Message
+		prompt  string
+		want    string
+	}{
+		{
+			name:    "plain message",
+			message: &tele.Message{Text: "hello"},
+			prompt:  "hello",
+			want:    "hello",
+		},
+		{
+			name:    "forwarded from a user",
+			message: forwarded("meet at 5"),
+			prompt:  "meet at 5",
+			want:    "Forwarded message from Bob Smith:\nmeet at 5",
+		},
+		{
+			name: "forwarded from a hidden user",
+			message: &tele.Message{
+				Text:   "meet at 5",
+				Origin: &tele.MessageOrigin{Type: "hidden_user", SenderUsername: "Anonymous"},
+			},
+			prompt: "meet at 5",
+			want:   "Forwarded message from Anonymous:\nmeet at 5",
+		},
+		{
+			name: "forwarded from a channel",
+			message: &tele.Message{
+				Text:   "news",
+				Origin: &tele.MessageOrigin{Type: "channel", Chat: &tele.Chat{Title: "Daily News"}},
+			},
+			prompt: "news",
+			want:   "Forwarded message from Daily News:\nnews",
+		},
+		{
+			name:    "legacy forward fields",
+			message: &tele.Message{Text: "news", OriginalChat: &tele.Chat{Title: "Daily News"}},
+			prompt:  "news",
+			want:    "Forwarded message from Daily News:\nnews",
+		},
+		{
+			name:    "legacy hidden user",
+			message: &tele.Message{Text: "hi", OriginalSenderName: "Anonymous"},
+			prompt:  "hi",
+			want:    "Forwarded message from Anonymous:\nhi",
+		},
+		{
+			name:    "unknown source",
+			message: &tele.Message{Text: "hi", Origin: &tele.MessageOrigin{Type: "user"}},
+			prompt:  "hi",
+			want:    "Forwarded message:\nhi",
+		},
+		{
+			name:    "reply to a forwarded message",
+			message: &tele.Message{Text: "is this true?", ReplyTo: forwarded("the sky is green")},
+			prompt:  "is this true?",
+			want:    "Forwarded message from Bob Smith:\nthe sky is green\n\nis this true?",
+		},
+		{
+			name: "reply to a forwarded caption",
+			message: &tele.Message{Text: "what is this?", ReplyTo: &tele.Message{
+				Caption: "my cat",
+				Photo:   &tele.Photo{},
+				Origin:  &tele.MessageOrigin{Type: "user", Sender: &tele.User{Username: "bob"}},
+			}},
+			prompt: "what is this?",
+			want:   "Forwarded message from bob:\nmy cat\n\nwhat is this?",
+		},
+		{
+			name:    "reply to an empty forwarded message",
+			message: &tele.Message{Text: "what is this?", ReplyTo: forwarded("")},
+			prompt:  "what is this?",
+			want:    "what is this?",
+		},
+		{
+			name:    "reply to a message that wasn't forwarded",
+			message: &tele.Message{Text: "is this true?", ReplyTo: &tele.Message{Text: "the sky is green"}},
+			prompt:  "is this true?",
+			want:    "is this true?",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withForwardedContext(tt.message, tt.prompt); got != tt.want {
+				t.Errorf("withForwardedContext() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
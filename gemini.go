@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	textModel  = "gemini-2.0-flash"
+	imageModel = "gemini-2.0-flash-exp-image-generation"
+)
+
+// APIStatusError is returned when Gemini answers with a non-200 status code.
+// Cause is one of the Err* causes when the status is a known one.
+type APIStatusError struct {
+	StatusCode int
+	Body       string
+	Cause      error
+}
+
+func (e *APIStatusError) Error() string {
+	if message := apiErrorMessage(e.Body); message != "" {
+		return fmt.Sprintf("API returned status code %d: %s", e.StatusCode, message)
+	}
+	if e.Cause != nil {
+		return fmt.Sprintf("API returned status code %d: %v", e.StatusCode, e.Cause)
+	}
+	return fmt.Sprintf("API returned status code %d", e.StatusCode)
+}
+
+func (e *APIStatusError) Unwrap() error {
+	return e.Cause
+}
+
+// newAPIStatusError builds the error for a non-200 response.
+func newAPIStatusError(statusCode int, body string) *APIStatusError {
+	return &APIStatusError{StatusCode: statusCode, Body: body, Cause: classifyStatus(statusCode, body)}
+}
+
+// generateContent sends reqBody to the generateContent endpoint of the given
+// model and returns the raw response body. In mock mode no request is sent and
+// a canned response is returned instead.
+func generateContent(cfg *Config, model string, reqBody interface{}, timeout time.Duration) ([]byte, error) {
+	jsonData, err := json.Marshal(requestForModel(model, reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %v", err)
+	}
+
+	release, err := geminiLimiter.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if cfg.MockGemini {
+		return mockGenerateContent(jsonData)
+	}
+
+	client := &http.Client{Timeout: timeout, Transport: httpTransport}
+	req, err := newGeminiRequest(cfg, model, "generateContent", nil, jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w: %v", ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("error making request to Gemini API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w: %v", ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Gemini returned an error", "model", model, "status", resp.StatusCode, "body", string(body))
+		return nil, newAPIStatusError(resp.StatusCode, string(body))
+	}
+
+	if reason := blockReason(body); reason != "" {
+		return nil, fmt.Errorf("%w: %s", ErrSafetyBlocked, reason)
+	}
+
+	return body, nil
+}
+
+// Ways of sending the API key, chosen with GEMINI_AUTH_MODE.
+const (
+	geminiAuthQueryKey = "query-key"
+	geminiAuthBearer   = "bearer"
+)
+
+// modelURL returns the URL of a Gemini API method of the given model, built
+// from GEMINI_BASE_URL or else GEMINI_API_HOST and GEMINI_API_VERSION.
+func modelURL(cfg *Config, model, method string) string {
+	base := cfg.GeminiBaseURL
+	if base == "" {
+		base = cfg.GeminiAPIHost + "/" + cfg.GeminiAPIVersion
+	}
+	return fmt.Sprintf("%s/models/%s:%s", base, model, method)
+}
+
+// newGeminiRequest builds a request to a Gemini API method of model with the
+// API key attached as GEMINI_AUTH_MODE says. query holds extra URL
+// parameters.
+func newGeminiRequest(cfg *Config, model, method string, query url.Values, body []byte) (*http.Request, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if cfg.GeminiAuthMode == geminiAuthQueryKey {
+		query.Set("key", cfg.GeminiAPIKey)
+	}
+
+	target := modelURL(cfg, model, method)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.GeminiAuthMode == geminiAuthBearer {
+		req.Header.Set("Authorization", "Bearer "+cfg.GeminiAPIKey)
+	}
+	return req, nil
+}
+
+// forModel returns the request as it should be sent to model, with the
+// model's safety settings and without the thinking settings if the model
+// doesn't support them.
+func (r GeminiRequest) forModel(model string) GeminiRequest {
+	r.SafetySettings = safetySettings(model)
+	if r.GenerationConfig == nil || r.GenerationConfig.ThinkingConfig == nil || supportsThinking(model) {
+		return r
+	}
+	config := *r.GenerationConfig
+	config.ThinkingConfig = nil
+	r.GenerationConfig = &config
+	return r
+}
+
+// requestForModel adapts a request body to the model it is sent to.
+func requestForModel(model string, reqBody interface{}) interface{} {
+	switch req := reqBody.(type) {
+	case GeminiRequest:
+		return req.forModel(model)
+	case ImageGenerationRequest:
+		req.SafetySettings = safetySettings(model)
+		return req
+	}
+	return reqBody
+}
+
+// generateWithFallback calls generateContent and, if the model is overloaded
+// (503) or not found (404), retries exactly once with the configured fallback
+// model. It returns the model that produced the response.
+func generateWithFallback(cfg *Config, model string, reqBody interface{}, timeout time.Duration) ([]byte, string, error) {
+	body, err := generateContent(cfg, model, reqBody, timeout)
+	if !shouldFallback(cfg, model, err) {
+		return body, model, err
+	}
+
+	slog.Warn("Model failed, retrying with the fallback model", "model", model, "fallback", cfg.FallbackModel, "err", err)
+	body, err = generateContent(cfg, cfg.FallbackModel, reqBody, timeout)
+	return body, cfg.FallbackModel, err
+}
+
+// shouldFallback reports whether a request to model that failed with err
+// should be retried with the fallback model.
+func shouldFallback(cfg *Config, model string, err error) bool {
+	if err == nil || cfg.FallbackModel == "" || cfg.FallbackModel == model {
+		return false
+	}
+	var statusErr *APIStatusError
+	return errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusServiceUnavailable || statusErr.StatusCode == http.StatusNotFound)
+}
+
+// fallbackNote returns a note to show under replies produced by a model other
+// than the requested one.
+func fallbackNote(requested, used string) string {
+	if used == "" || used == requested {
+		return ""
+	}
+	return fmt.Sprintf("\n\n(%s was unavailable, this answer is from %s)", requested, used)
+}
+
+// mockGenerateContent builds a canned Gemini response that echoes the last
+// user prompt. Image requests also get a small placeholder PNG.
+func mockGenerateContent(jsonData []byte) ([]byte, error) {
+	var req struct {
+		Contents         []Content         `json:"contents"`
+		GenerationConfig *GenerationConfig `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return nil, fmt.Errorf("error decoding mock request: %v", err)
+	}
+
+	prompt := ""
+	if len(req.Contents) > 0 {
+		for _, part := range req.Contents[len(req.Contents)-1].Parts {
+			if part.Text != "" {
+				prompt = part.Text
+				break
+			}
+		}
+	}
+
+	parts := []map[string]interface{}{
+		{"text": "[mock] You said: " + prompt},
+	}
+	if req.GenerationConfig != nil && req.GenerationConfig.ResponseSchema != nil {
+		structured, err := json.Marshal(mockSchemaValue(req.GenerationConfig.ResponseSchema, prompt))
+		if err != nil {
+			return nil, fmt.Errorf("error encoding mock structured response: %v", err)
+		}
+		parts[0]["text"] = string(structured)
+	}
+
+	wantsImage := false
+	if req.GenerationConfig != nil {
+		for _, modality := range req.GenerationConfig.ResponseModalities {
+			if modality == "Image" {
+				wantsImage = true
+			}
+		}
+	}
+	if wantsImage {
+		img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 64; y++ {
+				img.Set(x, y, color.RGBA{R: 66, G: 133, B: 244, A: 255})
+			}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("error encoding mock image: %v", err)
+		}
+		parts = append(parts, map[string]interface{}{
+			"inlineData": map[string]string{
+				"mimeType": "image/png",
+				"data":     base64.StdEncoding.EncodeToString(buf.Bytes()),
+			},
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"candidates": []map[string]interface{}{
+			{"content": map[string]interface{}{"role": "model", "parts": parts}},
+		},
+	})
+}
+
+// mockSchemaValue builds a value matching schema, filling strings with text.
+func mockSchemaValue(schema *Schema, text string) interface{} {
+	switch schema.Type {
+	case "OBJECT":
+		obj := map[string]interface{}{}
+		for name, prop := range schema.Properties {
+			obj[name] = mockSchemaValue(prop, text)
+		}
+		return obj
+	case "ARRAY":
+		return []interface{}{}
+	case "NUMBER", "INTEGER":
+		return 0
+	case "BOOLEAN":
+		return false
+	default:
+		return "[mock] " + text
+	}
+}
+
+const textSystemInstruction = "You are a helpful assistant. When responding, act as if you are continuing a conversation. " +
+	"Keep your responses under 4096 characters. Respond with the actual content only, no need to add role prefixes."
+
+// plainOutputInstruction restricts answers to plain text. Users can lift it
+// with /rawoutput on.
+const plainOutputInstruction = "Use only these punctuation marks: , . ? ! - \n" +
+	"Do not use any other special characters or formatting."
+
+var (
+	errDecodeResponse = errors.New("error decoding AI response")
+	errEmptyResponse  = errors.New("no candidates in AI response")
+)
+
+// ReplyOptions customizes how a text reply is generated.
+type ReplyOptions struct {
+	// Persona is extra system instruction text, e.g. a chat-wide persona
+	Persona string
+	// Language is the language the answer must be written in, if known
+	Language string
+	// Style is the instruction of the user's /preset
+	Style string
+	// MaxOutputTokens caps the answer length, 0 uses the model default
+	MaxOutputTokens int
+	// ThinkingBudget overrides the model's thinking budget when set, and
+	// ShowThoughts asks for the model's thought summaries
+	ThinkingBudget *int
+	ShowThoughts   bool
+	// CandidateCount asks for several answers when above 1
+	CandidateCount int
+	// RawOutput lets the model use any formatting and emoji
+	RawOutput bool
+	// StopSequences end the answer where one of them appears
+	StopSequences []string
+	// Logprobs asks for the log probabilities of the chosen tokens
+	Logprobs bool
+	// MaxContextTurns caps how many history turns are replayed, 0 means all
+	MaxContextTurns int
+	// Model is the text model that answers
+	Model string
+	// PinnedMessage is the chat's pinned message, given as background
+	PinnedMessage string
+}
+
+// systemInstruction combines the base instruction with the persona, the
+// pinned message, the answer style and the response language.
+func (o ReplyOptions) systemInstruction() string {
+	instruction := textSystemInstruction
+	if !o.RawOutput {
+		instruction += " " + plainOutputInstruction
+	}
+	if o.Persona != "" {
+		instruction += "\n\nFollow this persona when answering: " + o.Persona
+	}
+	if o.PinnedMessage != "" {
+		instruction += "\n\nThe pinned message of this chat, for background when questions refer to it:\n" + o.PinnedMessage
+	}
+	if o.Style != "" {
+		instruction += "\n\n" + o.Style
+	}
+	if o.Language != "" {
+		instruction += "\n\nAlways respond in " + o.Language + ", regardless of the language of earlier messages."
+	}
+	return instruction
+}
+
+// buildTextRequest replays the stored history as context and appends the new
+// user message. Only the most recent MAX_CONTEXT_TURNS turns are replayed.
+// Images still loaded in the history are sent along with their turn.
+func buildTextRequest(history []Message, userMsg string, opts ReplyOptions) GeminiRequest {
+	history = recentTurns(history, opts.MaxContextTurns)
+
+	var contextMessages []Content
+	for _, msg := range history {
+		parts := []Part{{Text: msg.Message}}
+		if msg.Image != nil && msg.Image.Data != "" {
+			parts = append(parts, Part{InlineData: msg.Image})
+		}
+		contextMessages = append(contextMessages, Content{
+			Role:  msg.Role,
+			Parts: parts,
+		})
+	}
+	contextMessages = append(contextMessages, Content{
+		Role:  "user",
+		Parts: []Part{{Text: userMsg}},
+	})
+
+	req := GeminiRequest{
+		SystemInstruction: Content{
+			Parts: []Part{{Text: opts.systemInstruction()}},
+		},
+		Contents: contextMessages,
+	}
+	if thinking := opts.thinkingConfig(); opts.MaxOutputTokens > 0 || thinking != nil || opts.CandidateCount > 1 || len(opts.StopSequences) > 0 || opts.Logprobs {
+		req.GenerationConfig = &GenerationConfig{
+			MaxOutputTokens:  opts.MaxOutputTokens,
+			ThinkingConfig:   thinking,
+			StopSequences:    opts.StopSequences,
+			ResponseLogprobs: opts.Logprobs,
+		}
+		if opts.CandidateCount > 1 {
+			req.GenerationConfig.CandidateCount = opts.CandidateCount
+		}
+	}
+	return req
+}
+
+// recentTurns returns the last max turns of history, or all of them when max
+// is 0. The result never starts with a model turn, which would answer a
+// question that is no longer there.
+func recentTurns(history []Message, max int) []Message {
+	if max <= 0 || len(history) <= max {
+		return history
+	}
+	history = history[len(history)-max:]
+	for len(history) > 0 && history[0].Role != "user" {
+		history = history[1:]
+	}
+	return history
+}
+
+// responseRole returns the role reported for a candidate, defaulting to
+// "model" when the API omits it.
+func responseRole(role string) string {
+	if role == "" {
+		return "model"
+	}
+	return role
+}
+
+// candidateText joins the text parts of a candidate, leaving out thoughts.
+func candidateText(parts []Part) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" && !part.Thought {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "")
+}
+
+// generateReply asks the text model to continue the conversation and returns
+// the final candidate as a history message labeled with the role the API
+// reported. Function calls requested by the model are resolved through the
+// registered tools and fed back until it answers with text.
+func generateReply(cfg *Config, history []Message, userMsg string, opts ReplyOptions) (Message, error) {
+	reqBody := buildTextRequest(history, userMsg, opts)
+	reqBody.Tools = toolDeclarations()
+
+	var (
+		usage         TokenUsage
+		answer        string
+		continuations int
+		tokens        []LogprobCandidate
+	)
+	for round := 0; ; round++ {
+		body, model, err := generateWithFallback(cfg, opts.Model, reqBody, cfg.TextTimeout)
+		if err != nil {
+			return Message{}, err
+		}
+
+		var geminiResp GeminiResponse
+		if err := json.Unmarshal(body, &geminiResp); err != nil {
+			return Message{}, fmt.Errorf("%w: %v", errDecodeResponse, err)
+		}
+		usage.AddMetadata(geminiResp.UsageMetadata)
+
+		if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
+			return Message{}, errEmptyResponse
+		}
+		candidate := geminiResp.Candidates[0]
+		if candidate.LogprobsResult != nil {
+			tokens = append(tokens, candidate.LogprobsResult.ChosenCandidates...)
+		}
+
+		var responses []Part
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				responses = append(responses, Part{FunctionResponse: callTool(part.FunctionCall)})
+			}
+		}
+
+		if len(responses) == 0 || round >= maxToolRounds {
+			text := candidateText(candidate.Content.Parts)
+			if text != "" && canContinue(cfg, opts, candidate.FinishReason, continuations) {
+				// Fetch the rest of an answer cut off by the token limit
+				continuations++
+				answer += text
+				reqBody.Contents = continueContents(reqBody.Contents, candidate.Content.Role, candidate.Content.Parts)
+				continue
+			}
+			text = answer + text
+			if text == "" {
+				return Message{}, errEmptyResponse
+			}
+			return Message{
+				Role:         responseRole(candidate.Content.Role),
+				Message:      cleanReply(cfg, text, userMsg),
+				Model:        model,
+				Usage:        usage,
+				Thoughts:     thoughtText(candidate.Content.Parts),
+				Alternatives: alternativeTexts(geminiResp.Candidates[1:], text),
+				Debug:        logprobsSummary(tokens),
+			}, nil
+		}
+
+		reqBody.Contents = append(reqBody.Contents,
+			Content{Role: responseRole(candidate.Content.Role), Parts: candidate.Content.Parts},
+			Content{Role: "user", Parts: responses},
+		)
+	}
+}
+
+// replyErrorMessage maps an error from generateReply to a user-facing message.
+func replyErrorMessage(err error) string {
+	var statusErr *APIStatusError
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return "Too many requests right now, please wait a minute and try again"
+	case errors.Is(err, ErrQuotaExceeded):
+		return "The AI quota for today is used up, please try again tomorrow"
+	case errors.Is(err, ErrSafetyBlocked):
+		return "Sorry, I can't answer that, the request was blocked by safety filters"
+	case errors.Is(err, ErrTimeout):
+		return "The AI service took too long to answer, please try again"
+	case errors.Is(err, ErrBadRequest) && errors.As(err, &statusErr):
+		return badRequestMessage(statusErr.Body)
+	case errors.As(err, &statusErr):
+		return "Error: API returned non-200 status code"
+	case errors.Is(err, errDecodeResponse):
+		return "Error decoding AI response"
+	case errors.Is(err, errEmptyResponse):
+		return "Sorry, I couldn't generate a response"
+	case errors.Is(err, errGeminiBusy):
+		return "I'm busy with other requests right now, please try again in a moment"
+	default:
+		return "Error connecting to AI service"
+	}
+}
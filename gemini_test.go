@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// useGeminiTransport sends the Gemini requests of the test through rt.
+func useGeminiTransport(t *testing.T, rt http.RoundTripper) {
+	old := httpTransport
+	httpTransport = rt
+	t.Cleanup(func() { httpTransport = old })
+}
+
+// newGeminiServer serves the Gemini API with handler and returns a
+// configuration pointing at it.
+func newGeminiServer(t *testing.T, handler http.HandlerFunc) *Config {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &Config{
+		GeminiBaseURL:  server.URL + "/v1beta",
+		GeminiAPIKey:   "key",
+		GeminiAuthMode: geminiAuthQueryKey,
+		TextTimeout:    5 * time.Second,
+		ImageTimeout:   5 * time.Second,
+	}
+}
+
+// geminiText returns a Gemini response answering with text.
+func geminiText(text string) string {
+	quoted, _ := json.Marshal(text)
+	return fmt.Sprintf(`{"candidates":[{"content":{"role":"model","parts":[{"text":%s}]},"finishReason":"STOP"}]}`, quoted)
+}
+
+func TestMockGeminiSendsNoRequests(t *testing.T) {
+	useGeminiTransport(t, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("request to %s with MOCK_GEMINI", req.URL)
+		return nil, errors.New("no requests expected")
+	}))
+	cfg := &Config{
+		MockGemini:    true,
+		Stateless:     true,
+		GeminiBaseURL: "https://gemini.invalid/v1beta",
+		TextTimeout:   time.Second,
+		ImageTimeout:  time.Second,
+		MaxImageBytes: 1 << 20,
+	}
+	opts := ReplyOptions{Model: textModel}
+
+	reply, err := generateReply(cfg, nil, "hello", opts)
+	if err != nil {
+		t.Fatalf("generateReply() error = %v", err)
+	}
+	if !strings.Contains(reply.Message, "hello") {
+		t.Errorf("generateReply() = %q, want the canned answer", reply.Message)
+	}
+
+	var streamed string
+	reply, err = streamReply(cfg, nil, "hello again", opts, func(text string) { streamed = text })
+	if err != nil {
+		t.Fatalf("streamReply() error = %v", err)
+	}
+	if !strings.Contains(reply.Message, "hello again") || streamed == "" {
+		t.Errorf("streamReply() = %q, streamed %q, want the canned answer", reply.Message, streamed)
+	}
+
+	b, api := newTestBot(t)
+	api.answers["sendPhoto"] = `{"message_id":1,"date":0,"chat":{"id":1},"photo":[{"file_id":"photo","width":1,"height":1}]}`
+	c := b.NewContext(tele.Update{Message: &tele.Message{
+		Sender: &tele.User{ID: 1},
+		Chat:   &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+		Text:   "/imagine a cat",
+	}})
+	if err := generateImage(c, cfg, "a cat", nil, imageOptions{}); err != nil {
+		t.Fatalf("generateImage() error = %v", err)
+	}
+	if len(api.Calls("sendPhoto")) != 1 {
+		t.Error("the mock image wasn't sent")
+	}
+}
+
+// modelServer answers each model with the status in statuses, or with a text
+// naming the model. It records the models that were called.
+func modelServer(t *testing.T, statuses map[string]int) (*Config, *[]string) {
+	var (
+		mu     sync.Mutex
+		called []string
+	)
+	cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		model, method, _ := strings.Cut(path.Base(r.URL.Path), ":")
+		mu.Lock()
+		called = append(called, model)
+		mu.Unlock()
+
+		if status, ok := statuses[model]; ok {
+			http.Error(w, `{"error":{"message":"unavailable"}}`, status)
+			return
+		}
+		if method == "streamGenerateContent" {
+			fmt.Fprintf(w, "data: %s\n\n", geminiText("answer from "+model))
+			return
+		}
+		fmt.Fprint(w, geminiText("answer from "+model))
+	})
+	return cfg, &called
+}
+
+func TestReplyFallback(t *testing.T) {
+	const fallback = "gemini-fallback"
+
+	tests := []struct {
+		name       string
+		statuses   map[string]int
+		fallback   string
+		wantModel  string
+		wantStatus int
+		wantCalled []string
+	}{
+		{"overloaded", map[string]int{textModel: http.StatusServiceUnavailable}, fallback, fallback, 0, []string{textModel, fallback}},
+		{"model not found", map[string]int{textModel: http.StatusNotFound}, fallback, fallback, 0, []string{textModel, fallback}},
+		{"fallback fails too", map[string]int{textModel: http.StatusServiceUnavailable, fallback: http.StatusServiceUnavailable}, fallback, "", http.StatusServiceUnavailable, []string{textModel, fallback}},
+		{"no fallback configured", map[string]int{textModel: http.StatusServiceUnavailable}, "", "", http.StatusServiceUnavailable, []string{textModel}},
+		{"other errors aren't retried", map[string]int{textModel: http.StatusInternalServerError}, fallback, "", http.StatusInternalServerError, []string{textModel}},
+		{"no error", nil, fallback, textModel, 0, []string{textModel}},
+	}
+
+	replies := map[string]func(cfg *Config, opts ReplyOptions) (Message, error){
+		"generate": func(cfg *Config, opts ReplyOptions) (Message, error) {
+			return generateReply(cfg, nil, "hello", opts)
+		},
+		"stream": func(cfg *Config, opts ReplyOptions) (Message, error) {
+			return streamReply(cfg, nil, "hello", opts, func(string) {})
+		},
+	}
+
+	for name, reply := range replies {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				cfg, called := modelServer(t, tt.statuses)
+				cfg.FallbackModel = tt.fallback
+
+				got, err := reply(cfg, ReplyOptions{Model: textModel})
+				var statusErr *APIStatusError
+				switch {
+				case tt.wantStatus != 0:
+					if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantStatus {
+						t.Fatalf("error = %v, want status %d", err, tt.wantStatus)
+					}
+				case err != nil:
+					t.Fatalf("error = %v", err)
+				case got.Model != tt.wantModel || got.Message != "answer from "+tt.wantModel:
+					t.Errorf("reply = %q from %q, want the answer from %q", got.Message, got.Model, tt.wantModel)
+				}
+				if !slices.Equal(*called, tt.wantCalled) {
+					t.Errorf("called models %q, want %q", *called, tt.wantCalled)
+				}
+			})
+		}
+	}
+}
+
+func TestModelURL(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "host and version",
+			cfg:  Config{GeminiAPIHost: "https://generativelanguage.googleapis.com", GeminiAPIVersion: "v1beta"},
+			want: "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent",
+		},
+		{
+			name: "other version",
+			cfg:  Config{GeminiAPIHost: "https://generativelanguage.googleapis.com", GeminiAPIVersion: "v1"},
+			want: "https://generativelanguage.googleapis.com/v1/models/gemini-2.0-flash:generateContent",
+		},
+		{
+			name: "base URL wins",
+			cfg:  Config{GeminiBaseURL: "https://proxy.example.com/gemini", GeminiAPIHost: "https://generativelanguage.googleapis.com", GeminiAPIVersion: "v1beta"},
+			want: "https://proxy.example.com/gemini/models/gemini-2.0-flash:generateContent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modelURL(&tt.cfg, textModel, "generateContent"); got != tt.want {
+				t.Errorf("modelURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewGeminiRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		query      url.Values
+		wantURL    string
+		wantHeader string
+	}{
+		{"query key", geminiAuthQueryKey, nil, "https://gemini.test/v1beta/models/gemini-2.0-flash:generateContent?key=secret", ""},
+		{"query key with parameters", geminiAuthQueryKey, url.Values{"alt": {"sse"}}, "https://gemini.test/v1beta/models/gemini-2.0-flash:generateContent?alt=sse&key=secret", ""},
+		{"bearer", geminiAuthBearer, nil, "https://gemini.test/v1beta/models/gemini-2.0-flash:generateContent", "Bearer secret"},
+		{"bearer with parameters", geminiAuthBearer, url.Values{"alt": {"sse"}}, "https://gemini.test/v1beta/models/gemini-2.0-flash:generateContent?alt=sse", "Bearer secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{GeminiBaseURL: "https://gemini.test/v1beta", GeminiAPIKey: "secret", GeminiAuthMode: tt.mode}
+			req, err := newGeminiRequest(cfg, textModel, "generateContent", tt.query, []byte("{}"))
+			if err != nil {
+				t.Fatalf("newGeminiRequest() error = %v", err)
+			}
+
+			if got := req.URL.String(); got != tt.wantURL {
+				t.Errorf("URL = %q, want %q", got, tt.wantURL)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.wantHeader {
+				t.Errorf("Authorization = %q, want %q", got, tt.wantHeader)
+			}
+			if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("request is %s %q, want a JSON POST", req.Method, req.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestRecentTurns(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []Message
+		max     int
+		want    []string
+	}{
+		{"no cap", turns(6), 0, []string{"0", "1", "2", "3", "4", "5"}},
+		{"at the cap", turns(6), 6, []string{"0", "1", "2", "3", "4", "5"}},
+		{"under the cap", turns(6), 7, []string{"0", "1", "2", "3", "4", "5"}},
+		{"one over the cap", turns(6), 5, []string{"2", "3", "4", "5"}},
+		{"keeps the latest", turns(6), 4, []string{"2", "3", "4", "5"}},
+		{"one turn", turns(6), 1, []string{}},
+		{"ends with a question", turns(5), 4, []string{"2", "3", "4"}},
+		{"empty history", nil, 4, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, msg := range recentTurns(tt.history, tt.max) {
+				got = append(got, msg.Message)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("recentTurns(%d turns, %d) = %q, want %q", len(tt.history), tt.max, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Causes of failed Gemini requests. Errors returned by generateContent and
+// streamContent wrap one of them when the cause is known, so callers can
+// branch with errors.Is.
+var (
+	ErrRateLimited   = errors.New("rate limited by AI service")
+	ErrQuotaExceeded = errors.New("AI service quota exceeded")
+	ErrSafetyBlocked = errors.New("blocked by safety filters")
+	ErrTimeout       = errors.New("AI service timed out")
+	ErrBadRequest    = errors.New("request rejected by AI service")
+)
+
+// classifyStatus returns the cause of an error response, or nil if it isn't
+// one of the known causes.
+func classifyStatus(statusCode int, body string) error {
+	if statusCode == http.StatusBadRequest {
+		return ErrBadRequest
+	}
+	if statusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	// Daily and billing quotas are reported as 429 just like per-minute rate
+	// limits, only the details tell them apart
+	if strings.Contains(body, "PerDay") || strings.Contains(strings.ToLower(body), "billing") {
+		return ErrQuotaExceeded
+	}
+	return ErrRateLimited
+}
+
+// apiError is the error object of a Gemini error body.
+type apiError struct {
+	Message string `json:"message"`
+	// Status is the canonical code, e.g. INVALID_ARGUMENT
+	Status  string `json:"status"`
+	Details []struct {
+		// Reason is set by google.rpc.ErrorInfo details, e.g. API_KEY_INVALID
+		Reason string `json:"reason"`
+		// FieldViolations are set by google.rpc.BadRequest details
+		FieldViolations []struct {
+			Field string `json:"field"`
+		} `json:"fieldViolations"`
+	} `json:"details"`
+}
+
+// parseAPIError returns the error object of a Gemini error body, or nil if
+// there is none.
+func parseAPIError(body string) *apiError {
+	var resp struct {
+		Error *apiError `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		return nil
+	}
+	return resp.Error
+}
+
+// apiErrorMessage returns the error.message field of a Gemini error body, or
+// an empty string if there is none.
+func apiErrorMessage(body string) string {
+	apiErr := parseAPIError(body)
+	if apiErr == nil {
+		return ""
+	}
+	return strings.TrimSpace(apiErr.Message)
+}
+
+// badRequestReasons maps the error.details reasons of Gemini 400 errors that
+// the user can act on to an explanation.
+var badRequestReasons = map[string]string{
+	"API_KEY_INVALID": "The Gemini API key isn't valid, please check the key you set with /mykey",
+	"API_KEY_EXPIRED": "The Gemini API key has expired, please set a new one with /mykey",
+}
+
+// badRequestStatuses maps the error.status of Gemini 400 errors that the user
+// can act on to an explanation.
+var badRequestStatuses = map[string]string{
+	"FAILED_PRECONDITION": "The AI service isn't available for this request in the bot's region",
+}
+
+// badRequestMessage explains a rejected request to the user from the Gemini
+// error body. Only the reason and status codes are matched, the raw messages
+// are only logged since they can mention internal details such as model
+// names.
+func badRequestMessage(body string) string {
+	if apiErr := parseAPIError(body); apiErr != nil {
+		for _, detail := range apiErr.Details {
+			if reply, ok := badRequestReasons[detail.Reason]; ok {
+				return reply
+			}
+			for _, violation := range detail.FieldViolations {
+				if strings.Contains(violation.Field, "inline_data") || strings.Contains(violation.Field, "file_data") {
+					return "This file couldn't be processed, please send a JPEG or PNG image instead"
+				}
+			}
+		}
+		if reply, ok := badRequestStatuses[apiErr.Status]; ok {
+			return reply
+		}
+	}
+	return "The AI service couldn't process this request, please rephrase it and try again"
+}
+
+// isTimeout reports whether a request error was caused by a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// blockedResponse is the part of a response that tells whether the prompt or
+// the answer was blocked.
+type blockedResponse struct {
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	Candidates []struct {
+		FinishReason string `json:"finishReason"`
+		Content      *struct {
+			Parts []json.RawMessage `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+}
+
+// blockReason returns why a response was blocked, or an empty string if it
+// wasn't.
+func blockReason(body []byte) string {
+	var resp blockedResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ""
+	}
+
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		return resp.PromptFeedback.BlockReason
+	}
+	if len(resp.Candidates) == 0 {
+		return ""
+	}
+
+	candidate := resp.Candidates[0]
+	switch candidate.FinishReason {
+	case "SAFETY", "PROHIBITED_CONTENT", "BLOCKLIST", "SPII", "IMAGE_SAFETY":
+		if candidate.Content == nil || len(candidate.Content.Parts) == 0 {
+			return candidate.FinishReason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"rate limit", http.StatusTooManyRequests, `{"error":{"status":"RESOURCE_EXHAUSTED"}}`, ErrRateLimited},
+		{"daily quota", http.StatusTooManyRequests, `{"error":{"details":[{"violations":[{"quotaId":"GenerateRequestsPerDayPerProjectPerModel"}]}]}}`, ErrQuotaExceeded},
+		{"billing", http.StatusTooManyRequests, `{"error":{"message":"Check your plan and Billing details"}}`, ErrQuotaExceeded},
+		{"bad request", http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT"}}`, ErrBadRequest},
+		{"server error", http.StatusInternalServerError, "", nil},
+		{"forbidden", http.StatusForbidden, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyStatus(tt.status, tt.body); got != tt.want {
+				t.Errorf("classifyStatus(%d) = %v, want %v", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline", context.DeadlineExceeded, true},
+		{"wrapped deadline", fmt.Errorf("error sending request: %w", context.DeadlineExceeded), true},
+		{"network timeout", os.ErrDeadlineExceeded, true},
+		{"canceled", context.Canceled, false},
+		{"other", errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTimeout(tt.err); got != tt.want {
+				t.Errorf("isTimeout(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockReason(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"blocked prompt", `{"promptFeedback":{"blockReason":"SAFETY"}}`, "SAFETY"},
+		{"blocked answer", `{"candidates":[{"finishReason":"PROHIBITED_CONTENT"}]}`, "PROHIBITED_CONTENT"},
+		{"partial answer", `{"candidates":[{"finishReason":"SAFETY","content":{"parts":[{"text":"Hi"}]}}]}`, ""},
+		{"finished", `{"candidates":[{"finishReason":"STOP","content":{"parts":[{"text":"Hi"}]}}]}`, ""},
+		{"no candidates", `{}`, ""},
+		{"invalid json", `{`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockReason([]byte(tt.body)); got != tt.want {
+				t.Errorf("blockReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBadRequestMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "invalid key",
+			body: `{"error":{"code":400,"message":"API key not valid.","status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"API_KEY_INVALID"}]}}`,
+			want: badRequestReasons["API_KEY_INVALID"],
+		},
+		{
+			name: "expired key",
+			body: `{"error":{"status":"INVALID_ARGUMENT","details":[{"reason":"API_KEY_EXPIRED"}]}}`,
+			want: badRequestReasons["API_KEY_EXPIRED"],
+		},
+		{
+			name: "unsupported file",
+			body: `{"error":{"status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"contents[0].parts[1].inline_data"}]}]}}`,
+			want: "This file couldn't be processed, please send a JPEG or PNG image instead",
+		},
+		{
+			name: "unsupported region",
+			body: `{"error":{"message":"User location is not supported for the API use.","status":"FAILED_PRECONDITION"}}`,
+			want: badRequestStatuses["FAILED_PRECONDITION"],
+		},
+		{
+			name: "raw message is not shown",
+			body: `{"error":{"message":"models/gemini-internal is not found","status":"INVALID_ARGUMENT"}}`,
+			want: "The AI service couldn't process this request, please rephrase it and try again",
+		},
+		{
+			name: "not json",
+			body: "Bad Request",
+			want: "The AI service couldn't process this request, please rephrase it and try again",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := badRequestMessage(tt.body); got != tt.want {
+				t.Errorf("badRequestMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyErrorsFromStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr error
+		want    string
+	}{
+		{"rate limit", http.StatusTooManyRequests, `{"error":{"status":"RESOURCE_EXHAUSTED"}}`, ErrRateLimited, "Too many requests right now, please wait a minute and try again"},
+		{"daily quota", http.StatusTooManyRequests, `{"error":{"details":[{"violations":[{"quotaId":"GenerateRequestsPerDayPerProjectPerModel"}]}]}}`, ErrQuotaExceeded, "The AI quota for today is used up, please try again tomorrow"},
+		{"server error", http.StatusInternalServerError, `{"error":{"status":"INTERNAL"}}`, nil, "Error: API returned non-200 status code"},
+		{"overloaded", http.StatusServiceUnavailable, `{"error":{"status":"UNAVAILABLE"}}`, nil, "Error: API returned non-200 status code"},
+		{"bad request", http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT","details":[{"reason":"API_KEY_INVALID"}]}}`, ErrBadRequest, badRequestReasons["API_KEY_INVALID"]},
+		{"blocked prompt", http.StatusOK, `{"promptFeedback":{"blockReason":"SAFETY"}}`, ErrSafetyBlocked, "Sorry, I can't answer that, the request was blocked by safety filters"},
+	}
+
+	replies := map[string]func(cfg *Config) (Message, error){
+		"generate": func(cfg *Config) (Message, error) {
+			return generateReply(cfg, nil, "hello", ReplyOptions{Model: textModel})
+		},
+		"stream": func(cfg *Config) (Message, error) {
+			return streamReply(cfg, nil, "hello", ReplyOptions{Model: textModel}, func(string) {})
+		},
+	}
+
+	for name, reply := range replies {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					if tt.status == http.StatusOK && strings.Contains(r.URL.Path, "stream") {
+						fmt.Fprintf(w, "data: %s\n\n", tt.body)
+						return
+					}
+					fmt.Fprint(w, tt.body)
+				})
+
+				_, err := reply(cfg)
+				if err == nil {
+					t.Fatal("no error")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				var statusErr *APIStatusError
+				if tt.status != http.StatusOK && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.status) {
+					t.Errorf("error = %v, want status %d", err, tt.status)
+				}
+				if got := replyErrorMessage(err); got != tt.want {
+					t.Errorf("replyErrorMessage() = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
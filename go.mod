@@ -3,3 +3,5 @@ module gogemini
 go 1.23.5
 
 require gopkg.in/telebot.v3 v3.3.8
+
+require golang.org/x/sync v0.10.0
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// greetingFor returns the greeting for a Telegram language code such as
+// "pt-br", trying the full code, then its base language, then the default.
+func greetingFor(cfg *Config, languageCode string) string {
+	code := strings.ToLower(languageCode)
+	if text, ok := cfg.Greetings[code]; ok {
+		return text
+	}
+	if base, _, ok := strings.Cut(code, "-"); ok {
+		if text, ok := cfg.Greetings[base]; ok {
+			return text
+		}
+	}
+	return cfg.Greeting
+}
+
+// isFirstContact reports whether user has never talked to the bot: there is
+// no record yet, or the record holds no history and no greeting was sent.
+// Records created by settings or the daily quota don't count as contact.
+func isFirstContact(user *UserMessages) bool {
+	if user == nil {
+		return true
+	}
+	if user.Greeted {
+		return false
+	}
+	if len(user.Messages) > 0 {
+		return false
+	}
+	for _, messages := range user.Sessions {
+		if len(messages) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// greetNewUser sends the configured greeting before the first answer to a
+// user and remembers it was sent, so it isn't repeated after /clear.
+// Nothing is sent in STATELESS mode, where every message looks like the
+// first one. The user's lock is held from the lookup to the write, so
+// messages arriving at once neither greet twice nor create two records.
+func greetNewUser(c tele.Context, cfg *Config) {
+	sender := c.Sender()
+	greeting := greetingFor(cfg, sender.LanguageCode)
+	if greeting == "" || cfg.Stateless {
+		return
+	}
+
+	defer userLocks.Lock(sender.ID)()
+	user, err := findUser(cfg, sender.ID)
+	if err != nil {
+		updateLogger(c).Error("Error checking for first contact", "err", err)
+		return
+	}
+	if !isFirstContact(user) {
+		return
+	}
+
+	if err := c.Send(greeting); err != nil {
+		updateLogger(c).Error("Error sending greeting", "err", err)
+		return
+	}
+
+	if user == nil {
+		user = &UserMessages{
+			TelegramID: sender.ID,
+			Username:   recordUsername(sender),
+			Messages:   []Message{},
+			Greeted:    true,
+		}
+		err = createUser(cfg, user)
+	} else {
+		err = patchUserFields(cfg, user, map[string]interface{}{"greeted": true})
+	}
+	if err != nil {
+		updateLogger(c).Error("Error saving greeting", "err", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestIsFirstContact(t *testing.T) {
+	turn := []Message{{Role: "user", Message: "hi"}}
+
+	tests := []struct {
+		name string
+		user *UserMessages
+		want bool
+	}{
+		{"no record", nil, true},
+		{"record from settings", &UserMessages{Settings: UserSettings{Model: "gemini-2.0-flash"}}, true},
+		{"record from the daily quota", &UserMessages{Quota: DailyQuota{Day: "2024-03-10", Count: 1}}, true},
+		{"greeted after /clear", &UserMessages{Greeted: true}, false},
+		{"has history", &UserMessages{Messages: turn}, false},
+		{"has a session", &UserMessages{Sessions: map[string][]Message{"work": turn}}, false},
+		{"empty sessions", &UserMessages{Sessions: map[string][]Message{"work": {}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFirstContact(tt.user); got != tt.want {
+				t.Errorf("isFirstContact() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGreetingFor(t *testing.T) {
+	cfg := &Config{Greeting: "Hello", Greetings: map[string]string{"de": "Hallo", "pt-br": "Olá"}}
+
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"de", "Hallo"},
+		{"de-AT", "Hallo"},
+		{"pt-BR", "Olá"},
+		{"pt", "Hello"},
+		{"", "Hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := greetingFor(cfg, tt.code); got != tt.want {
+				t.Errorf("greetingFor(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGreetNewUserOnce(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	cfg.Greeting = "Welcome!"
+	b, api := newTestBot(t)
+	sender := &tele.User{ID: 1, Username: "alice"}
+	update := tele.Update{Message: &tele.Message{Sender: sender, Chat: &tele.Chat{ID: 1}, Text: "hi"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			greetNewUser(b.NewContext(update), cfg)
+		}()
+	}
+	wg.Wait()
+
+	if got := api.Texts(); len(got) != 1 || got[0] != "Welcome!" {
+		t.Errorf("sent %q, want the greeting once", got)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.users) != 1 {
+		t.Errorf("store has %d user records, want 1", len(store.users))
+	}
+}
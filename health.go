@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthShutdownTimeout bounds how long the health server waits for open
+// requests when the bot stops.
+const healthShutdownTimeout = 5 * time.Second
+
+// healthServer answers liveness and readiness probes on HEALTH_LISTEN,
+// separately from the webhook listener. /healthz answers 200 while the
+// process runs and /readyz only while the bot is handling updates. A nil
+// server means the probes are disabled.
+type healthServer struct {
+	srv   *http.Server
+	ready atomic.Bool
+}
+
+func newHealthServer(cfg *Config) *healthServer {
+	if cfg.HealthListen == "" {
+		return nil
+	}
+
+	h := &healthServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	h.srv = &http.Server{Addr: cfg.HealthListen, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return h
+}
+
+// Start serves the probes in the background.
+func (h *healthServer) Start() {
+	if h == nil {
+		return
+	}
+	go func() {
+		slog.Info("Serving health checks", "listen", h.srv.Addr)
+		if err := h.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error serving health checks", "err", err)
+		}
+	}()
+}
+
+// SetReady sets whether /readyz reports the bot as ready.
+func (h *healthServer) SetReady(ready bool) {
+	if h != nil {
+		h.ready.Store(ready)
+	}
+}
+
+// Close stops the server, letting open probes finish.
+func (h *healthServer) Close() {
+	if h == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+	defer cancel()
+	if err := h.srv.Shutdown(ctx); err != nil {
+		slog.Error("Error stopping the health server", "err", err)
+	}
+}
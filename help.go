@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// helpText lists the commands of the bot.
+var helpText = `Send me a message or a photo and I'll answer it.
+
+/generate <prompt> - generate an image, or reply to a message to draw its text
+/imagine [ratio] [xN] <prompt> - generate images with an aspect ratio, e.g. /imagine 16:9 x2 a sunset
+/edit <change> - edit a photo you reply to
+/regenerate [change] - make another version of your last generated image
+/getimage - get your last generated image as an uncompressed file
+/describe - describe a photo you reply to
+/ocr - extract the text of a photo you reply to
+/say <question> - hear the answer, or reply to a message to hear it read aloud
+/translate <language> [--save] - translate a message you reply to, or your last one
+/summarize - recap the conversation, --compact also shortens the history
+/history - clear the history of the current session
+/undo - forget your last message and its answer
+/showhistory - page through the history of the current session
+/context - preview the history sent with your next message
+/session <name> - switch to another conversation
+/sessions - list your conversations
+/model [name] - choose the model that answers you
+/preset <name> - choose an answer style
+/maxtokens <n> - limit the length of answers
+/thinking <n>|default|show|hide - tune how much the model thinks
+/candidates <n> - get several answers to choose from
+/lang <language> - always answer in a language, auto to match yours
+/longformat chunk|file - how long answers are delivered
+/stream on|off - show answers as they are written
+/quote on|off - reply to your messages
+/rawoutput on|off - allow formatting and emoji in answers
+/stop <sequence>|clear - end answers at a sequence
+/mykey <key>|clear - use your own Gemini API key, in a private chat
+/setpersona <text> - set a persona for this chat
+/pinned on|off - use the pinned message as background in this group
+/feedback <text> - send feedback to the bot admins
+/ping - check the bot is alive
+/about - show the bot version and your model`
+
+// helpHandler answers /help with the list of commands and presets.
+func helpHandler(c tele.Context) error {
+	return c.Send(helpText + "\n\nPresets: " + strings.Join(presetNames(), ", "))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// historyPageSize is how many turns /showhistory shows per page.
+	historyPageSize = 5
+	// historyTurnLength is how much of each turn is shown, so a full page
+	// fits in one message.
+	historyTurnLength = 700
+)
+
+var (
+	historyMenu    = &tele.ReplyMarkup{}
+	btnHistoryPage = historyMenu.Data("", "historypage")
+)
+
+// historyPageStart returns the index of the first turn of the page starting
+// at start, clamped to the turns there are. A negative start shows the last
+// page.
+func historyPageStart(start, total int) int {
+	last := 0
+	if total > historyPageSize {
+		last = total - historyPageSize
+	}
+	if start < 0 || start > last {
+		return last
+	}
+	return start
+}
+
+// historyPage renders the page of messages starting at start, with buttons to
+// the older and newer pages when there are any.
+func historyPage(messages []Message, start int) (string, *tele.ReplyMarkup) {
+	start = historyPageStart(start, len(messages))
+	end := min(start+historyPageSize, len(messages))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Turns %d-%d of %d\n", start+1, end, len(messages))
+	for i, msg := range messages[start:end] {
+		text := strings.TrimSpace(msg.Message)
+		if utf8.RuneCountInString(text) > historyTurnLength {
+			text = string([]rune(text)[:historyTurnLength]) + "..."
+		}
+		fmt.Fprintf(&sb, "\n%d. %s%s:\n%s\n", start+i+1, msg.Role, contextMarkers(msg), text)
+	}
+
+	var row []tele.Btn
+	if start > 0 {
+		older := btnHistoryPage
+		older.Text = "◀ Older"
+		older.Data = strconv.Itoa(max(start-historyPageSize, 0))
+		row = append(row, older)
+	}
+	if end < len(messages) {
+		newer := btnHistoryPage
+		newer.Text = "Newer ▶"
+		newer.Data = strconv.Itoa(end)
+		row = append(row, newer)
+	}
+	if len(row) == 0 {
+		return sb.String(), nil
+	}
+
+	markup := &tele.ReplyMarkup{}
+	markup.Inline(row)
+	return sb.String(), markup
+}
+
+// showHistoryHandler shows the history of the current session with
+// /showhistory, starting with the most recent turns.
+func showHistoryHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		messages, err := getUserMessages(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting previous messages", "err", err)
+			return c.Send("Error loading your history")
+		}
+		if len(messages) == 0 {
+			return c.Send("Your history is empty")
+		}
+
+		text, markup := historyPage(messages, -1)
+		return c.Send(text, markup)
+	}
+}
+
+// historyPageHandler turns the page of a /showhistory message. The history
+// may have changed since, so the page is clamped to the turns stored now.
+func historyPageHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		start, err := strconv.Atoi(c.Data())
+		if err != nil {
+			return c.Respond()
+		}
+
+		messages, err := getUserMessages(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting previous messages", "err", err)
+			return c.Respond(&tele.CallbackResponse{Text: "Error loading your history"})
+		}
+		if len(messages) == 0 {
+			return c.Respond(&tele.CallbackResponse{Text: "Your history is empty"})
+		}
+
+		text, markup := historyPage(messages, start)
+		if err := c.Edit(text, markup); err != nil {
+			updateLogger(c).Error("Error showing history page", "err", err)
+		}
+		return c.Respond()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistoryPageStart(t *testing.T) {
+	tests := []struct {
+		name  string
+		start int
+		total int
+		want  int
+	}{
+		{"last page", -1, 12, 7},
+		{"last page of a short history", -1, 3, 0},
+		{"first page", 0, 12, 0},
+		{"middle page", 4, 12, 4},
+		{"past the end", 20, 12, 7},
+		{"start of the last page", 7, 12, 7},
+		{"empty history", -1, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historyPageStart(tt.start, tt.total); got != tt.want {
+				t.Errorf("historyPageStart(%d, %d) = %d, want %d", tt.start, tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryPageButtons(t *testing.T) {
+	messages := make([]Message, 12)
+	for i := range messages {
+		messages[i] = Message{Role: "user", Message: fmt.Sprintf("turn %d", i+1)}
+	}
+
+	tests := []struct {
+		name  string
+		start int
+		// want are the texts and data of the buttons
+		want      []string
+		wantTitle string
+	}{
+		{"last page", -1, []string{"◀ Older:2"}, "Turns 8-12 of 12"},
+		{"middle page", 3, []string{"◀ Older:0", "Newer ▶:8"}, "Turns 4-8 of 12"},
+		{"first page", 0, []string{"Newer ▶:5"}, "Turns 1-5 of 12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, markup := historyPage(messages, tt.start)
+			if !strings.HasPrefix(text, tt.wantTitle) {
+				t.Errorf("historyPage() text starts with %q, want %q", strings.SplitN(text, "\n", 2)[0], tt.wantTitle)
+			}
+
+			var got []string
+			for _, row := range markup.InlineKeyboard {
+				for _, btn := range row {
+					got = append(got, btn.Text+":"+btn.Data)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("historyPage() buttons = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryPageSinglePage(t *testing.T) {
+	messages := []Message{{Role: "user", Message: "hi"}, {Role: "model", Message: strings.Repeat("a", historyTurnLength+10)}}
+	text, markup := historyPage(messages, -1)
+	if markup != nil {
+		t.Errorf("historyPage() markup = %+v, want no buttons", markup)
+	}
+	if !strings.Contains(text, strings.Repeat("a", historyTurnLength)+"...") {
+		t.Error("historyPage() didn't shorten a long turn")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Errors of an image generation that aren't errors of the request itself.
+var (
+	errNoImage      = errors.New("no image data in the response")
+	errInvalidImage = errors.New("generated image is invalid")
+	errSendImage    = errors.New("generated image couldn't be sent")
+)
+
+// runImageGeneration asks the image model to create count images from prompt,
+// or to edit source when it is set, saving and sending each result. All
+// images are counted against the daily quota up front. Generation stops at the
+// first failure, which is reported once along with how many images were sent.
+func runImageGeneration(c tele.Context, cfg *Config, prompt string, source *FileData, opts imageOptions, count int) error {
+	cfg = userConfig(cfg, c.Sender())
+	if ok, err := reserveDailyQuota(c, cfg, count); !ok {
+		return err
+	}
+
+	stopTyping := keepTyping(c)
+	defer stopTyping()
+	updateLogger(c).Info("Generating images", "prompt", prompt, "count", count)
+
+	// The job stays stored if the bot stops before the request finishes
+	finishJob := trackPendingJob(c, cfg, prompt)
+	defer finishJob()
+
+	for i := 0; i < count; i++ {
+		err := generateImage(c, cfg, prompt, source, opts)
+		if err == nil {
+			continue
+		}
+
+		stopTyping()
+		updateLogger(c).Error("Error generating image", "image", i+1, "count", count, "err", err)
+		message := imageErrorMessage(err)
+		if count > 1 {
+			message = fmt.Sprintf("Sent %d of %d images. %s", i, count, message)
+		}
+		return c.Send(message)
+	}
+
+	updateLogger(c).Info("Sent generated images", "count", count)
+	return nil
+}
+
+// imageErrorMessage explains a failed image generation to the user.
+func imageErrorMessage(err error) string {
+	var statusErr *APIStatusError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.Cause == nil:
+		return fmt.Sprintf("Error: API returned status code %d", statusErr.StatusCode)
+	case errors.Is(err, errNoImage):
+		return "Sorry, couldn't generate an image. Please try with a different prompt."
+	case errors.Is(err, errInvalidImage):
+		return "Sorry, the generated image was invalid. Please try again."
+	case errors.Is(err, errSendImage):
+		return "Generated an image but couldn't send it. Please try again."
+	default:
+		return replyErrorMessage(err)
+	}
+}
+
+// generateImage generates one image, saves the turn and sends the result.
+func generateImage(c tele.Context, cfg *Config, prompt string, source *FileData, opts imageOptions) error {
+	// Create request body for image generation
+	parts := []Part{{Text: prompt}}
+	if source != nil {
+		parts = append(parts, Part{InlineData: source})
+	}
+
+	reqBody := ImageGenerationRequest{
+		Contents: []Content{
+			{
+				Parts: parts,
+			},
+		},
+		GenerationConfig: GenerationConfig{
+			ResponseModalities: []string{"Text", "Image"},
+		},
+	}
+
+	if opts.AspectRatio != "" {
+		reqBody.GenerationConfig.ImageConfig = &ImageConfig{AspectRatio: opts.AspectRatio}
+	}
+
+	responseBody, err := generateContent(cfg, imageModel, reqBody, cfg.ImageTimeout)
+	if err != nil {
+		return err
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(responseBody, &geminiResp); err != nil {
+		return fmt.Errorf("%w: %v", errDecodeResponse, err)
+	}
+
+	var respParts []Part
+	if len(geminiResp.Candidates) > 0 {
+		respParts = geminiResp.Candidates[0].Content.Parts
+	}
+
+	var generated *FileData
+	for _, part := range respParts {
+		if part.InlineData != nil && part.InlineData.Data != "" {
+			generated = part.InlineData
+			break
+		}
+	}
+
+	if generated == nil {
+		return errNoImage
+	}
+
+	updateLogger(c).Debug("Found image data", "base64_bytes", len(generated.Data))
+
+	decodedImageData, mimeType, err := decodeGeneratedImage(generated.Data, cfg.MaxImageBytes)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+
+	updateLogger(c).Debug("Decoded image data", "bytes", len(decodedImageData))
+
+	// Create FileData structure to save in database
+	imageData := &FileData{
+		MimeType: mimeType,
+		Data:     generated.Data,
+	}
+
+	// Use any text from the response as the caption
+	responseText := candidateText(respParts)
+	if responseText != "" {
+		updateLogger(c).Debug("Found text to use as caption", "caption", responseText)
+	} else {
+		responseText = "Generated image based on your prompt."
+	}
+
+	// Save the message and image to the database
+	telegramID := c.Sender().ID
+	userTurn := Message{Role: "user", Message: prompt, Image: source, MessageID: c.Message().ID}
+	modelTurn := Message{Role: "model", Message: responseText, Image: imageData}
+	modelTurn.Usage.AddMetadata(geminiResp.UsageMetadata)
+	historySaves.Save(cfg, telegramID, c.Sender(), userTurn, modelTurn)
+
+	// Upload the image straight from memory, so no temporary file can be
+	// left behind when sending fails
+	photo := &tele.Photo{File: tele.FromReader(bytes.NewReader(decodedImageData))}
+
+	// Add caption if there's text
+	if responseText != "" {
+		photo.Caption = responseText
+		if cfg.CaptionFooter && utf8.RuneCountInString(withFooter(responseText, cfg.ReplyFooter)) <= telegramCaptionLimit {
+			photo.Caption = withFooter(responseText, cfg.ReplyFooter)
+		}
+	}
+
+	if err := c.Send(photo); err != nil {
+		return fmt.Errorf("%w: %v", errSendImage, err)
+	}
+	return nil
+}
+
+// decodeGeneratedImage decodes base64 image data returned by Gemini, checks it
+// against the size limit and verifies it is a PNG or JPEG image. It returns
+// the raw bytes and the detected mime type.
+func decodeGeneratedImage(data string, maxBytes int) ([]byte, string, error) {
+	if base64.StdEncoding.DecodedLen(len(data)) > maxBytes {
+		return nil, "", fmt.Errorf("image data of %d base64 bytes exceeds the %d byte limit", len(data), maxBytes)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid base64 image data: %v", err)
+	}
+	if len(decoded) == 0 {
+		return nil, "", fmt.Errorf("image data is empty")
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, "", fmt.Errorf("image data is not a valid image: %v", err)
+	}
+
+	switch format {
+	case "png":
+		return decoded, "image/png", nil
+	case "jpeg":
+		return decoded, "image/jpeg", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported image format %q", format)
+	}
+}
+
+// lastGeneration returns the prompt and source image of the user's last turn
+// if it was an image generation. ok is false when the last answer wasn't a
+// generated image.
+func lastGeneration(cfg *Config, messages []Message) (prompt string, source *FileData, ok bool, err error) {
+	if len(messages) < 2 {
+		return "", nil, false, nil
+	}
+
+	userTurn, modelTurn := messages[len(messages)-2], messages[len(messages)-1]
+	if userTurn.Role != "user" || modelTurn.Role == "user" || (modelTurn.Image == nil && modelTurn.ImageRef == 0) {
+		return "", nil, false, nil
+	}
+
+	// Edits also need the photo that was edited
+	source, err = loadMessageImage(cfg, userTurn)
+	if err != nil {
+		return "", nil, false, err
+	}
+	return userTurn.Message, source, true, nil
+}
+
+// regenerateImageHandler runs the last image generation again with
+// /regenerate, optionally adding a modifier to the prompt.
+func regenerateImageHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		user, err := findUser(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting previous messages", "err", err)
+			return c.Send("Error loading your history")
+		}
+
+		var messages []Message
+		if user != nil {
+			messages = user.SessionMessages()
+		}
+
+		prompt, source, ok, err := lastGeneration(cfg, messages)
+		if err != nil {
+			updateLogger(c).Error("Error loading the source image", "err", err)
+			return c.Send("Error loading the image to regenerate")
+		}
+		if !ok {
+			return c.Send("Your last message wasn't an image generation. Use /generate <prompt> to create one")
+		}
+
+		if modifier := strings.TrimSpace(c.Message().Payload); modifier != "" {
+			prompt = fmt.Sprintf("%s, %s", prompt, modifier)
+		}
+
+		return runImageGeneration(c, cfg, prompt, source, imageOptions{}, 1)
+	}
+}
+
+// lastGeneratedImage returns the most recent image generated for the user in
+// messages, or nil if there is none.
+func lastGeneratedImage(cfg *Config, messages []Message) (*FileData, error) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Role != "user" && (msg.Image != nil || msg.ImageRef != 0) {
+			return loadMessageImage(cfg, msg)
+		}
+	}
+	return nil, nil
+}
+
+// getImageHandler answers /getimage with the last generated image sent as a
+// document, so it arrives as the original file instead of a compressed photo.
+func getImageHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		user, err := findUser(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting previous messages", "err", err)
+			return c.Send("Error loading your history")
+		}
+
+		var messages []Message
+		if user != nil {
+			messages = user.SessionMessages()
+		}
+
+		image, err := lastGeneratedImage(cfg, messages)
+		if err != nil {
+			updateLogger(c).Error("Error loading the last generated image", "err", err)
+			return c.Send("Error loading your last image")
+		}
+		if image == nil {
+			return c.Send("There's no generated image in this conversation yet. Use /generate <prompt> to create one")
+		}
+
+		data, err := base64.StdEncoding.DecodeString(image.Data)
+		if err != nil {
+			updateLogger(c).Error("Error decoding stored image", "err", err)
+			return c.Send("Error loading your last image")
+		}
+
+		fileName := "image.png"
+		if image.MimeType == "image/jpeg" {
+			fileName = "image.jpg"
+		}
+
+		c.Notify(tele.UploadingDocument)
+		doc := &tele.Document{
+			File:     tele.FromReader(bytes.NewReader(data)),
+			MIME:     image.MimeType,
+			FileName: fileName,
+		}
+		if _, err := sendReply(c, doc); err != nil {
+			updateLogger(c).Error("Error sending image as a document", "err", err)
+			return c.Send("Couldn't send the image, please try again")
+		}
+		return nil
+	}
+}
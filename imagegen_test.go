@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// encodedImage returns a small image in the given format, base64 encoded.
+func encodedImage(t *testing.T, format string) string {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecodeGeneratedImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		maxBytes int
+		wantMIME string
+		wantErr  string
+	}{
+		{"png", encodedImage(t, "png"), 1 << 20, "image/png", ""},
+		{"jpeg", encodedImage(t, "jpeg"), 1 << 20, "image/jpeg", ""},
+		{"unsupported format", encodedImage(t, "gif"), 1 << 20, "", "unsupported image format"},
+		{"invalid base64", "not base64!", 1 << 20, "", "invalid base64"},
+		{"not an image", base64.StdEncoding.EncodeToString([]byte("hello")), 1 << 20, "", "not a valid image"},
+		{"empty", "", 1 << 20, "", "empty"},
+		{"oversized", encodedImage(t, "png"), 10, "", "exceeds the 10 byte limit"},
+		{"oversized invalid data", strings.Repeat("!", 100), 10, "", "exceeds the 10 byte limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, mimeType, err := decodeGeneratedImage(tt.data, tt.maxBytes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeGeneratedImage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeGeneratedImage() error = %v", err)
+			}
+			if mimeType != tt.wantMIME {
+				t.Errorf("decodeGeneratedImage() mime type = %q, want %q", mimeType, tt.wantMIME)
+			}
+			if want, _ := base64.StdEncoding.DecodeString(tt.data); !bytes.Equal(decoded, want) {
+				t.Error("decodeGeneratedImage() didn't return the decoded data")
+			}
+		})
+	}
+}
+
+func TestGenerateImageCreatesNoTempFiles(t *testing.T) {
+	// Creating a temporary file fails in a directory that doesn't exist, even
+	// for a file that would be removed right away
+	tmp := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("TMPDIR", tmp)
+	cfg := &Config{MockGemini: true, Stateless: true, MaxImageBytes: 1 << 20}
+	b, api := newTestBot(t)
+	api.answers["sendPhoto"] = `{"message_id":1,"date":0,"chat":{"id":1},"photo":[{"file_id":"photo","width":1,"height":1}]}`
+	c := b.NewContext(tele.Update{Message: &tele.Message{
+		Sender: &tele.User{ID: 1},
+		Chat:   &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+		Text:   "/imagine a cat",
+	}})
+
+	if err := generateImage(c, cfg, "a cat", nil, imageOptions{}); err != nil {
+		t.Fatalf("generateImage() error = %v", err)
+	}
+	if len(api.Calls("sendPhoto")) != 1 {
+		t.Fatal("the image wasn't sent")
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("the temporary directory was created: %v", err)
+	}
+}
+
+func TestLastGeneratedImage(t *testing.T) {
+	inline := &FileData{MimeType: "image/png", Data: encodedImage(t, "png")}
+	stored := StoredImage{ID: 4, MimeType: "image/jpeg", Data: encodedImage(t, "jpeg")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/images/4" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(stored)
+	}))
+	defer server.Close()
+	cfg := &Config{MokkyURL: server.URL + "/", StoreTimeout: 5 * time.Second}
+
+	tests := []struct {
+		name     string
+		messages []Message
+		want     *FileData
+		wantErr  bool
+	}{
+		{"no history", nil, nil, false},
+		{"no generated image", []Message{
+			{Role: "user", Message: "what is this?", Image: &FileData{MimeType: "image/jpeg", Data: "photo"}},
+			{Role: "model", Message: "A cat."},
+		}, nil, false},
+		{"inline image", []Message{
+			{Role: "user", Message: "/generate a cat"},
+			{Role: "model", Message: "a cat", Image: inline},
+			{Role: "user", Message: "nice"},
+			{Role: "model", Message: "Thanks!"},
+		}, inline, false},
+		{"stored image", []Message{
+			{Role: "model", Message: "a cat", Image: inline},
+			{Role: "model", Message: "a dog", ImageRef: 4},
+		}, &FileData{MimeType: stored.MimeType, Data: stored.Data}, false},
+		{"latest image only", []Message{
+			{Role: "model", Message: "a dog", ImageRef: 4},
+			{Role: "model", Message: "a cat", Image: inline},
+		}, inline, false},
+		{"missing stored image", []Message{
+			{Role: "model", Message: "a dog", ImageRef: 5},
+		}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lastGeneratedImage(cfg, tt.messages)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lastGeneratedImage() error = %v, want error %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("lastGeneratedImage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
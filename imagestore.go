@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// StoredImage is an image kept in the separate "images" collection so the
+// user record only carries a reference to it.
+type StoredImage struct {
+	ID         int64  `json:"id,omitempty"`
+	TelegramID int64  `json:"telegramId"`
+	MimeType   string `json:"mime_type"`
+	Data       string `json:"data"`
+}
+
+func storeImage(cfg *Config, telegramID int64, image *FileData) (int64, error) {
+	stored := StoredImage{
+		TelegramID: telegramID,
+		MimeType:   image.MimeType,
+		Data:       image.Data,
+	}
+
+	var created StoredImage
+	if err := storeRequest(cfg, "POST", "images", stored, &created); err != nil {
+		return 0, fmt.Errorf("error storing image: %w", err)
+	}
+
+	return created.ID, nil
+}
+
+// loadImage fetches an image stored separately by storeImage.
+func loadImage(cfg *Config, ref int64) (*FileData, error) {
+	var stored StoredImage
+	if err := storeRequest(cfg, "GET", fmt.Sprintf("images/%d", ref), nil, &stored); err != nil {
+		return nil, fmt.Errorf("error getting image from API: %w", err)
+	}
+
+	return &FileData{MimeType: stored.MimeType, Data: stored.Data}, nil
+}
+
+func deleteImage(cfg *Config, ref int64) error {
+	err := storeRequest(cfg, "DELETE", fmt.Sprintf("images/%d", ref), nil, nil)
+	if err != nil && !errors.Is(err, errStoreNotFound) {
+		return fmt.Errorf("error deleting image: %w", err)
+	}
+	return nil
+}
+
+// deleteStoredImages removes the separately stored images referenced by the
+// given messages. Failures are only logged.
+func deleteStoredImages(cfg *Config, messages []Message) {
+	for _, msg := range messages {
+		if msg.ImageRef != 0 {
+			if err := deleteImage(cfg, msg.ImageRef); err != nil {
+				slog.Error("Error deleting stored image", "image_id", msg.ImageRef, "err", err)
+			}
+		}
+	}
+}
+
+// loadMessageImage returns the full image attached to a history message,
+// fetching it from the image store when only a reference was kept. It returns
+// nil when the message has no image.
+func loadMessageImage(cfg *Config, msg Message) (*FileData, error) {
+	if msg.ImageRef != 0 {
+		return loadImage(cfg, msg.ImageRef)
+	}
+	if msg.Image != nil && msg.Image.Data != "" {
+		return msg.Image, nil
+	}
+	return nil, nil
+}
+
+// externalizeImage moves the inline image of a turn into the image store and
+// keeps only a reference. On failure the image stays inline.
+func externalizeImage(cfg *Config, telegramID int64, turn *Message) {
+	if turn.Image == nil {
+		return
+	}
+
+	ref, err := storeImage(cfg, telegramID, turn.Image)
+	if err != nil {
+		slog.Error("Error storing image separately, keeping it inline", "user_id", telegramID, "err", err)
+		return
+	}
+
+	slog.Info("Stored image separately", "user_id", telegramID, "image_id", ref, "bytes", len(turn.Image.Data))
+	turn.Image = nil
+	turn.ImageRef = ref
+}
+
+// stripImageData drops base64 image payloads from messages that are only
+// needed as text context. The mime type is kept so callers can still tell a
+// message had an image and load it with loadMessageImage if needed.
+func stripImageData(telegramID int64, messages []Message) []Message {
+	stripped := make([]Message, len(messages))
+	removed := 0
+	for i, msg := range messages {
+		stripped[i] = msg
+		if msg.Image != nil && msg.Image.Data != "" {
+			removed += len(msg.Image.Data)
+			stripped[i].Image = &FileData{MimeType: msg.Image.MimeType}
+		}
+	}
+
+	if removed > 0 {
+		slog.Debug("Stripped image data from history", "user_id", telegramID, "bytes", removed)
+	}
+	return stripped
+}
+
+// withLatestModelImage loads the image of the most recent model turn that has
+// one back into messages, so follow-up questions about a generated image can
+// see it. Inline images are taken from stored, the session turns messages were
+// stripped from. Older images stay stripped to bound the request size.
+// messages itself isn't modified.
+func withLatestModelImage(cfg *Config, telegramID int64, messages, stored []Message) []Message {
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Role != "model" || (msg.Image == nil && msg.ImageRef == 0) {
+			continue
+		}
+
+		image, err := loadMessageImage(cfg, msg)
+		if err == nil && image == nil {
+			image = inlineImage(stored, msg)
+		}
+		if err != nil {
+			slog.Error("Error loading the last generated image", "user_id", telegramID, "err", err)
+			return messages
+		}
+		if image == nil {
+			return messages
+		}
+
+		loaded := slices.Clone(messages)
+		loaded[i].Image = image
+		return loaded
+	}
+	return messages
+}
+
+// inlineImage returns the image data that stripImageData removed from msg,
+// looked up in the stored turns it came from, or nil if it isn't there.
+func inlineImage(stored []Message, msg Message) *FileData {
+	for i := len(stored) - 1; i >= 0; i-- {
+		turn := stored[i]
+		if turn.Role == msg.Role && turn.Message == msg.Message && turn.Image != nil && turn.Image.Data != "" {
+			return turn.Image
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithLatestModelImage(t *testing.T) {
+	image := &FileData{MimeType: "image/png", Data: "aW1hZ2U="}
+	older := &FileData{MimeType: "image/png", Data: "b2xkZXI="}
+	stored := []Message{
+		{Role: "user", Message: "/generate a dog"},
+		{Role: "model", Message: "a dog", Image: older},
+		{Role: "user", Message: "/generate a cat"},
+		{Role: "model", Message: "a cat", Image: image},
+		{Role: "user", Message: "what is this?", Image: &FileData{MimeType: "image/jpeg", Data: "cGhvdG8="}},
+		{Role: "model", Message: "A photo."},
+	}
+
+	tests := []struct {
+		name      string
+		messages  []Message
+		stored    []Message
+		wantIndex int
+		want      *FileData
+	}{
+		{"latest generated image", stripImageData(1, stored), stored, 3, image},
+		{"before an edited message", stripImageData(1, stored)[:3], stored, 1, older},
+		{"not in the stored turns", stripImageData(1, stored), nil, -1, nil},
+		{"no generated image", stripImageData(1, stored)[4:], stored, -1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Inline images come from the stored turns, never from the store
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("store request %s %s", r.Method, r.URL)
+				http.Error(w, "unavailable", http.StatusInternalServerError)
+			}))
+			defer server.Close()
+			cfg := &Config{MokkyURL: server.URL + "/", StoreTimeout: time.Second}
+
+			got := withLatestModelImage(cfg, 1, tt.messages, tt.stored)
+			for i, msg := range got {
+				want := tt.messages[i].Image
+				if i == tt.wantIndex {
+					want = tt.want
+				}
+				if (msg.Image == nil) != (want == nil) || (msg.Image != nil && *msg.Image != *want) {
+					t.Errorf("turn %d image = %+v, want %+v", i+1, msg.Image, want)
+				}
+			}
+			if tt.wantIndex >= 0 && tt.messages[tt.wantIndex].Image.Data != "" {
+				t.Error("the messages passed in were modified")
+			}
+		})
+	}
+}
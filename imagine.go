@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxImagineCount is the most images a single /imagine generates.
+const maxImagineCount = 4
+
+// supportedAspectRatios are the aspect ratios the image model accepts.
+var supportedAspectRatios = []string{"1:1", "2:3", "3:2", "3:4", "4:3", "4:5", "5:4", "9:16", "16:9", "21:9"}
+
+// ImageConfig controls the shape of generated images.
+type ImageConfig struct {
+	AspectRatio string `json:"aspectRatio,omitempty"`
+}
+
+// imageOptions are the options of an image generation. The zero value uses
+// the model defaults.
+type imageOptions struct {
+	AspectRatio string
+}
+
+// parseImagineFlags splits the leading flags off an /imagine payload. An
+// aspect ratio such as "16:9" and a count such as "x2" may come in any order
+// before the prompt.
+func parseImagineFlags(payload string) (opts imageOptions, count int, prompt string, err error) {
+	count = 1
+	fields := strings.Fields(payload)
+	for len(fields) > 0 {
+		flag := strings.ToLower(fields[0])
+		switch {
+		case strings.Contains(flag, ":"):
+			if !slices.Contains(supportedAspectRatios, flag) {
+				return opts, 0, "", fmt.Errorf("unsupported aspect ratio %s, use one of %s", flag, strings.Join(supportedAspectRatios, ", "))
+			}
+			opts.AspectRatio = flag
+		case strings.HasPrefix(flag, "x") && len(flag) > 1:
+			n, convErr := strconv.Atoi(flag[1:])
+			if convErr != nil {
+				// Not a count, so the prompt starts here, e.g. "xylophone"
+				return opts, count, strings.Join(fields, " "), nil
+			}
+			if n < 1 || n > maxImagineCount {
+				return opts, 0, "", fmt.Errorf("the count must be between x1 and x%d", maxImagineCount)
+			}
+			count = n
+		default:
+			return opts, count, strings.Join(fields, " "), nil
+		}
+		fields = fields[1:]
+	}
+	return opts, count, "", nil
+}
+
+// imagineHandler generates images with an aspect ratio and count given as
+// leading flags, e.g. /imagine 16:9 x2 a sunset over the sea.
+func imagineHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		opts, count, prompt, err := parseImagineFlags(c.Message().Payload)
+		if err != nil {
+			return c.Send(fmt.Sprintf("Sorry, %v", err))
+		}
+		if prompt == "" {
+			return c.Send(fmt.Sprintf("Usage: /imagine [ratio] [xN] <prompt>, for example /imagine 16:9 x2 a sunset over the sea. Ratios: %s", strings.Join(supportedAspectRatios, ", ")))
+		}
+
+		return runImageGeneration(c, cfg, prompt, nil, opts, count)
+	}
+}
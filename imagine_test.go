@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseImagineFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		wantRatio  string
+		wantCount  int
+		wantPrompt string
+		wantErr    bool
+	}{
+		{"prompt only", "a sunset over the sea", "", 1, "a sunset over the sea", false},
+		{"ratio and count", "16:9 x2 a sunset", "16:9", 2, "a sunset", false},
+		{"count before ratio", "x3 9:16 a tower", "9:16", 3, "a tower", false},
+		{"upper case count", "X2 a cat", "", 2, "a cat", false},
+		{"word starting with x", "xylophone on a stage", "", 1, "xylophone on a stage", false},
+		{"flags after the prompt are kept", "a cat 16:9", "", 1, "a cat 16:9", false},
+		{"flags without prompt", "1:1 x2", "1:1", 2, "", false},
+		{"empty", "", "", 1, "", false},
+		{"unsupported ratio", "7:3 a cat", "", 0, "", true},
+		{"count too high", "x5 a cat", "", 0, "", true},
+		{"count too low", "x0 a cat", "", 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, count, prompt, err := parseImagineFlags(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseImagineFlags(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if opts.AspectRatio != tt.wantRatio || count != tt.wantCount || prompt != tt.wantPrompt {
+				t.Errorf("parseImagineFlags(%q) = %q, %d, %q, want %q, %d, %q",
+					tt.payload, opts.AspectRatio, count, prompt, tt.wantRatio, tt.wantCount, tt.wantPrompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// inspectTurns is how many of the latest turns /inspect shows.
+	inspectTurns = 10
+	// inspectInterval is the minimum time between two /inspect uses of an
+	// admin.
+	inspectInterval = 10 * time.Second
+)
+
+// inspectHandler shows admins the latest turns of a user's active session
+// with /inspect <telegram_id>. Images are only noted, never sent, and every
+// use is logged.
+func inspectHandler(cfg *Config) tele.HandlerFunc {
+	limiter := &userCooldown{interval: inspectInterval, last: map[int64]time.Time{}}
+
+	return func(c tele.Context) error {
+		if !isAdmin(cfg, c.Sender()) {
+			return c.Send("Only bot admins can use this command")
+		}
+
+		telegramID, err := strconv.ParseInt(strings.TrimSpace(c.Message().Payload), 10, 64)
+		if err != nil {
+			return c.Send("Usage: /inspect <telegram id>")
+		}
+		if !limiter.allow(c.Sender().ID, time.Now()) {
+			return c.Send("Please wait a few seconds before inspecting another history")
+		}
+
+		updateLogger(c).Info("Admin inspected the history of a user", "inspected_user_id", telegramID)
+
+		user, err := findUser(cfg, telegramID)
+		if err != nil {
+			updateLogger(c).Error("Error loading user to inspect", "err", err)
+			return c.Send("Error loading the user")
+		}
+		if user == nil {
+			return c.Send(fmt.Sprintf("No user with Telegram ID %d", telegramID))
+		}
+
+		return c.Send(inspectText(user), tele.NoPreview)
+	}
+}
+
+// inspectText renders the latest turns of user's active session. Turns are
+// shortened and images are replaced by a marker.
+func inspectText(user *UserMessages) string {
+	messages := user.SessionMessages()
+	start := max(len(messages)-inspectTurns, 0)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (%d)", user.Username, user.TelegramID)
+	if user.ActiveSession != "" {
+		fmt.Fprintf(&sb, ", session %s", user.ActiveSession)
+	}
+	fmt.Fprintf(&sb, ", %d turns", len(messages))
+	if len(messages) == 0 {
+		sb.WriteString("\n\nThe history is empty")
+		return sb.String()
+	}
+
+	// The turns are shortened so the latest ones fit in one message
+	turnLength := (telegramMessageLimit - 200) / inspectTurns
+	for i, msg := range messages[start:] {
+		text := strings.TrimSpace(msg.Message)
+		if utf8.RuneCountInString(text) > turnLength {
+			text = string([]rune(text)[:turnLength]) + "..."
+		}
+		fmt.Fprintf(&sb, "\n\n%d. %s%s:\n%s", start+i+1, msg.Role, contextMarkers(msg), text)
+	}
+	return sb.String()
+}
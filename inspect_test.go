@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestInspectHandler(t *testing.T) {
+	const imageData = "aW1hZ2UgYnl0ZXM="
+
+	tests := []struct {
+		name      string
+		sender    int64
+		payloads  []string
+		wantTexts []string
+		wantReads int
+	}{
+		{"not an admin", 2, []string{"5"}, []string{"Only bot admins can use this command"}, 0},
+		{"invalid id", 1, []string{"alice"}, []string{"Usage: /inspect <telegram id>"}, 0},
+		{"unknown user", 1, []string{"6"}, []string{"No user with Telegram ID 6"}, 1},
+		{"rate limited", 1, []string{"6", "6"}, []string{"No user with Telegram ID 6", "Please wait a few seconds before inspecting another history"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, cfg := newFakeUserStore(t, 0)
+			cfg.AdminIDs = []int64{1}
+			b, api := newTestBot(t)
+			handler := inspectHandler(cfg)
+
+			for _, payload := range tt.payloads {
+				c := b.NewContext(tele.Update{Message: &tele.Message{
+					Sender:  &tele.User{ID: tt.sender},
+					Chat:    &tele.Chat{ID: tt.sender, Type: tele.ChatPrivate},
+					Text:    "/inspect " + payload,
+					Payload: payload,
+				}})
+				if err := handler(c); err != nil {
+					t.Fatalf("inspectHandler() error = %v", err)
+				}
+			}
+
+			if texts := api.Texts(); strings.Join(texts, "\n") != strings.Join(tt.wantTexts, "\n") {
+				t.Errorf("replies = %q, want %q", texts, tt.wantTexts)
+			}
+			if store.reads != tt.wantReads {
+				t.Errorf("store was read %d times, want %d", store.reads, tt.wantReads)
+			}
+		})
+	}
+
+	t.Run("redacts images", func(t *testing.T) {
+		store, cfg := newFakeUserStore(t, 0)
+		cfg.AdminIDs = []int64{1}
+		store.users[1] = &UserMessages{ID: 1, TelegramID: 5, Username: "bob", Messages: []Message{
+			{Role: "user", Message: "what is this?", Image: &FileData{MimeType: "image/jpeg", Data: imageData}},
+			{Role: "model", Message: "A cat."},
+			{Role: "user", Message: "draw it", ImageRef: 3},
+		}}
+		b, api := newTestBot(t)
+
+		c := b.NewContext(tele.Update{Message: &tele.Message{
+			Sender:  &tele.User{ID: 1},
+			Chat:    &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+			Text:    "/inspect 5",
+			Payload: "5",
+		}})
+		if err := inspectHandler(cfg)(c); err != nil {
+			t.Fatalf("inspectHandler() error = %v", err)
+		}
+
+		texts := api.Texts()
+		if len(texts) != 1 {
+			t.Fatalf("replies = %q, want one", texts)
+		}
+		want := "bob (5), 3 turns\n\n1. user [image]:\nwhat is this?\n\n2. model:\nA cat.\n\n3. user [image]:\ndraw it"
+		if texts[0] != want {
+			t.Errorf("reply = %q, want %q", texts[0], want)
+		}
+		if strings.Contains(texts[0], imageData) {
+			t.Error("the reply contains the image data")
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// PendingJob is an image generation that was started but hasn't finished.
+// Jobs are kept in the "jobs" collection so users can be told about requests
+// lost to a restart.
+type PendingJob struct {
+	ID         int64  `json:"id,omitempty"`
+	TelegramID int64  `json:"telegramId"`
+	ChatID     int64  `json:"chatId"`
+	ThreadID   int    `json:"threadId,omitempty"`
+	Prompt     string `json:"prompt"`
+	CreatedAt  int64  `json:"createdAt"`
+}
+
+// trackPendingJob stores a pending job for an image generation and returns a
+// function that removes it once the request is finished. Failing to store the
+// job doesn't stop the generation. Jobs hold the prompt, so none are stored in
+// STATELESS mode and generations interrupted by a restart aren't reported.
+func trackPendingJob(c tele.Context, cfg *Config, prompt string) func() {
+	if cfg.Stateless {
+		return func() {}
+	}
+
+	job := PendingJob{
+		TelegramID: c.Sender().ID,
+		ChatID:     c.Chat().ID,
+		ThreadID:   threadID(c),
+		Prompt:     prompt,
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	var created PendingJob
+	if err := storeRequest(cfg, "POST", "jobs", job, &created); err != nil {
+		updateLogger(c).Error("Error storing pending job", "err", err)
+		return func() {}
+	}
+
+	return func() {
+		if err := deletePendingJob(cfg, created.ID); err != nil {
+			slog.Error("Error deleting pending job", "job_id", created.ID, "user_id", job.TelegramID, "err", err)
+		}
+	}
+}
+
+func deletePendingJob(cfg *Config, id int64) error {
+	if err := storeRequest(cfg, "DELETE", fmt.Sprintf("jobs/%d", id), nil, nil); err != nil {
+		return fmt.Errorf("error deleting pending job: %w", err)
+	}
+	return nil
+}
+
+// notifyInterruptedJobs tells the users of jobs left over from a previous run
+// that their image wasn't generated, then removes the jobs.
+func notifyInterruptedJobs(b *tele.Bot, cfg *Config) {
+	if cfg.Stateless {
+		return
+	}
+
+	var jobs []PendingJob
+	if err := storeRequest(cfg, "GET", "jobs", nil, &jobs); err != nil {
+		slog.Error("Error loading pending jobs", "err", err)
+		return
+	}
+
+	for _, job := range jobs {
+		slog.Info("Notifying user about interrupted image generation", "user_id", job.TelegramID, "chat_id", job.ChatID, "job_id", job.ID)
+		text := fmt.Sprintf("Sorry, your image request %q was interrupted by a restart. Please send it again.", job.Prompt)
+		if _, err := b.Send(&tele.Chat{ID: job.ChatID}, text, &tele.SendOptions{ThreadID: job.ThreadID}); err != nil {
+			slog.Error("Error notifying user about interrupted job", "user_id", job.TelegramID, "chat_id", job.ChatID, "err", err)
+		}
+		if err := deletePendingJob(cfg, job.ID); err != nil {
+			slog.Error("Error deleting pending job", "job_id", job.ID, "user_id", job.TelegramID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// detectLanguage guesses the language of a prompt from the script its letters
+// are written in. It only looks at the first few hundred letters and returns
+// an empty string when the script doesn't identify a language, e.g. for Latin
+// text, which could be one of many languages.
+func detectLanguage(text string) string {
+	counts := map[string]int{}
+	ukrainian, kana := false, false
+	letters := 0
+
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if letters > 300 {
+			break
+		}
+
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			counts["cyrillic"]++
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				ukrainian = true
+			}
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			counts["han"]++
+			kana = true
+		case unicode.Is(unicode.Han, r):
+			counts["han"]++
+		case unicode.Is(unicode.Hangul, r):
+			counts["hangul"]++
+		case unicode.Is(unicode.Arabic, r):
+			counts["arabic"]++
+		case unicode.Is(unicode.Hebrew, r):
+			counts["hebrew"]++
+		case unicode.Is(unicode.Greek, r):
+			counts["greek"]++
+		case unicode.Is(unicode.Devanagari, r):
+			counts["devanagari"]++
+		case unicode.Is(unicode.Thai, r):
+			counts["thai"]++
+		case unicode.Is(unicode.Latin, r):
+			counts["latin"]++
+		}
+	}
+
+	script, best := "", 0
+	for s, n := range counts {
+		if n > best {
+			script, best = s, n
+		}
+	}
+
+	switch script {
+	case "cyrillic":
+		if ukrainian {
+			return "Ukrainian"
+		}
+		return "Russian"
+	case "han":
+		if kana {
+			return "Japanese"
+		}
+		return "Chinese"
+	case "hangul":
+		return "Korean"
+	case "arabic":
+		return "Arabic"
+	case "hebrew":
+		return "Hebrew"
+	case "greek":
+		return "Greek"
+	case "devanagari":
+		return "Hindi"
+	case "thai":
+		return "Thai"
+	default:
+		return ""
+	}
+}
+
+// responseLanguage returns the language to answer in: the pinned one if the
+// user set it with /lang, otherwise the one detected from the prompt.
+func responseLanguage(settings UserSettings, prompt string) string {
+	if settings.Language != "" {
+		return settings.Language
+	}
+	return detectLanguage(prompt)
+}
+
+// langHandler pins the response language with /lang <language>. "/lang auto"
+// goes back to detecting it from each message.
+func langHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		lang := strings.TrimSpace(c.Message().Payload)
+		if lang == "" {
+			settings, err := getUserSettings(cfg, c.Sender().ID)
+			if err != nil {
+				updateLogger(c).Error("Error getting user settings", "err", err)
+			}
+			current := "auto"
+			if settings.Language != "" {
+				current = settings.Language
+			}
+			return c.Send("Response language: " + current + "\n\nUsage: /lang English to always answer in English, /lang auto to match the language of your messages")
+		}
+
+		if len(lang) > 40 {
+			return c.Send("That language name is too long")
+		}
+		if strings.EqualFold(lang, "auto") {
+			lang = ""
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.Language = lang }); err != nil {
+			updateLogger(c).Error("Error saving language", "err", err)
+			return c.Send("Error saving your language")
+		}
+
+		if lang == "" {
+			return c.Send("I'll answer in the language of your messages")
+		}
+		return c.Send("I'll answer in " + lang + " from now on")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// errGeminiBusy is returned when too many Gemini requests are already running
+// or waiting.
+var errGeminiBusy = errors.New("too many concurrent requests to AI service")
+
+// requestLimiter caps the number of concurrent Gemini requests. Requests over
+// the cap wait in a bounded queue for a short while and are shed when the
+// queue is full or the wait times out.
+type requestLimiter struct {
+	sem      *semaphore.Weighted
+	waiting  atomic.Int64
+	maxQueue int64
+	timeout  time.Duration
+}
+
+// geminiLimiter limits all calls to the Gemini API. It is nil, meaning no
+// limit, until main sets it up from the configuration.
+var geminiLimiter *requestLimiter
+
+// newRequestLimiter returns a limiter for MAX_CONCURRENT_GEMINI requests, or
+// nil if the limit is disabled.
+func newRequestLimiter(cfg *Config) *requestLimiter {
+	if cfg.MaxConcurrentGemini <= 0 {
+		return nil
+	}
+	return &requestLimiter{
+		sem:      semaphore.NewWeighted(int64(cfg.MaxConcurrentGemini)),
+		maxQueue: int64(cfg.GeminiQueueSize),
+		timeout:  cfg.GeminiQueueTimeout,
+	}
+}
+
+// acquire waits for a free slot and returns the function releasing it.
+func (l *requestLimiter) acquire() (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if l.sem.TryAcquire(1) {
+		return func() { l.sem.Release(1) }, nil
+	}
+
+	if l.waiting.Add(1) > l.maxQueue {
+		l.waiting.Add(-1)
+		return nil, errGeminiBusy
+	}
+	defer l.waiting.Add(-1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	if err := l.sem.Acquire(ctx, 1); err != nil {
+		return nil, errGeminiBusy
+	}
+	return func() { l.sem.Release(1) }, nil
+}
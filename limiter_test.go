@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiterCap(t *testing.T) {
+	const limit = 3
+	l := newRequestLimiter(&Config{MaxConcurrentGemini: limit, GeminiQueueSize: 100, GeminiQueueTimeout: 5 * time.Second})
+
+	var (
+		wg      sync.WaitGroup
+		running atomic.Int64
+		peak    atomic.Int64
+		failed  atomic.Int64
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.acquire()
+			if err != nil {
+				failed.Add(1)
+				return
+			}
+			defer release()
+
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got > limit {
+		t.Errorf("%d requests ran at once, want at most %d", got, limit)
+	}
+	if got := failed.Load(); got != 0 {
+		t.Errorf("%d queued requests were shed, want none", got)
+	}
+}
+
+func TestRequestLimiterSheds(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueSize int
+		timeout   time.Duration
+	}{
+		{"queue full", 0, 5 * time.Second},
+		{"wait times out", 1, 10 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRequestLimiter(&Config{MaxConcurrentGemini: 1, GeminiQueueSize: tt.queueSize, GeminiQueueTimeout: tt.timeout})
+			release, err := l.acquire()
+			if err != nil {
+				t.Fatalf("acquire() error = %v", err)
+			}
+			defer release()
+
+			start := time.Now()
+			if _, err := l.acquire(); !errors.Is(err, errGeminiBusy) {
+				t.Fatalf("acquire() error = %v, want %v", err, errGeminiBusy)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("acquire() took %v to shed the request", elapsed)
+			}
+		})
+	}
+}
+
+func TestRequestLimiterWaitsForSlot(t *testing.T) {
+	l := newRequestLimiter(&Config{MaxConcurrentGemini: 1, GeminiQueueSize: 1, GeminiQueueTimeout: 5 * time.Second})
+	release, err := l.acquire()
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	time.AfterFunc(10*time.Millisecond, release)
+
+	next, err := l.acquire()
+	if err != nil {
+		t.Fatalf("queued acquire() error = %v", err)
+	}
+	next()
+}
+
+func TestRequestLimiterDisabled(t *testing.T) {
+	l := newRequestLimiter(&Config{MaxConcurrentGemini: 0})
+	if l != nil {
+		t.Fatal("newRequestLimiter() with no limit != nil")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := l.acquire(); err != nil {
+			t.Errorf("acquire() error = %v", err)
+		}
+	}
+}
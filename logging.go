@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Log formats, chosen with LOG_FORMAT.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogHandler returns the slog handler for a LOG_FORMAT, writing to w.
+func newLogHandler(format string, w io.Writer) slog.Handler {
+	if format == logFormatJSON {
+		return slog.NewJSONHandler(w, nil)
+	}
+	return slog.NewTextHandler(w, nil)
+}
+
+// setupLogging routes all logging, including the log package, through slog in
+// the LOG_FORMAT format.
+func setupLogging(cfg *Config) {
+	slog.SetDefault(slog.New(newLogHandler(cfg.LogFormat, os.Stderr)))
+}
+
+// updateLogger returns a logger that tags records with the ID of the update
+// being handled, of its sender and of its chat, so records of one update can
+// be correlated.
+func updateLogger(c tele.Context) *slog.Logger {
+	var userID, chatID int64
+	if c.Sender() != nil {
+		userID = c.Sender().ID
+	}
+	if c.Chat() != nil {
+		chatID = c.Chat().ID
+	}
+	return slog.With("update_id", c.Update().ID, "user_id", userID, "chat_id", chatID)
+}
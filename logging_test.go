@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		wantJSON bool
+	}{
+		{"text", logFormatText, false},
+		{"json", logFormatJSON, true},
+		{"default", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := newLogHandler(tt.format, &buf)
+
+			// Records below info are left out
+			if handler.Enabled(context.Background(), slog.LevelDebug) {
+				t.Error("debug records are enabled")
+			}
+			if !handler.Enabled(context.Background(), slog.LevelInfo) {
+				t.Error("info records are disabled")
+			}
+
+			b, _ := newTestBot(t)
+			c := b.NewContext(tele.Update{ID: 3, Message: &tele.Message{
+				Sender: &tele.User{ID: 1},
+				Chat:   &tele.Chat{ID: 2},
+			}})
+			old := slog.Default()
+			slog.SetDefault(slog.New(handler))
+			updateLogger(c).Info("Handled message", "model", textModel)
+			slog.SetDefault(old)
+
+			line := strings.TrimSpace(buf.String())
+			var record map[string]any
+			if err := json.Unmarshal([]byte(line), &record); (err == nil) != tt.wantJSON {
+				t.Fatalf("log line %q, want JSON = %v", line, tt.wantJSON)
+			}
+			if !tt.wantJSON {
+				for _, want := range []string{"level=INFO", `msg="Handled message"`, "update_id=3", "user_id=1", "chat_id=2", "model=" + textModel} {
+					if !strings.Contains(line, want) {
+						t.Errorf("log line %q doesn't contain %q", line, want)
+					}
+				}
+				return
+			}
+			want := map[string]any{"level": "INFO", "msg": "Handled message", "update_id": 3.0, "user_id": 1.0, "chat_id": 2.0, "model": textModel}
+			for key, value := range want {
+				if record[key] != value {
+					t.Errorf("%s = %v, want %v", key, record[key], value)
+				}
+			}
+		})
+	}
+}
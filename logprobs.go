@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// debugLowTokens is how many of the least confident tokens the /debug summary
+// lists.
+const debugLowTokens = 3
+
+// LogprobCandidate is a token and its log probability.
+type LogprobCandidate struct {
+	Token          string  `json:"token"`
+	LogProbability float64 `json:"logProbability"`
+}
+
+// LogprobsResult holds the log probabilities of the tokens the model chose.
+type LogprobsResult struct {
+	ChosenCandidates []LogprobCandidate `json:"chosenCandidates"`
+}
+
+// logprobsSummary describes how confident the model was in the tokens it
+// chose: the average token probability and the least likely tokens. It
+// returns an empty string when there are no tokens.
+func logprobsSummary(tokens []LogprobCandidate) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	sum := 0.0
+	for _, t := range tokens {
+		sum += math.Exp(t.LogProbability)
+	}
+
+	lowest := make([]LogprobCandidate, len(tokens))
+	copy(lowest, tokens)
+	sort.SliceStable(lowest, func(i, j int) bool {
+		return lowest[i].LogProbability < lowest[j].LogProbability
+	})
+	lowest = lowest[:min(debugLowTokens, len(lowest))]
+
+	var low []string
+	for _, t := range lowest {
+		low = append(low, fmt.Sprintf("%q %.0f%%", t.Token, 100*math.Exp(t.LogProbability)))
+	}
+	return fmt.Sprintf("Debug: %d tokens, average probability %.0f%%, least confident %s",
+		len(tokens), 100*sum/float64(len(tokens)), strings.Join(low, ", "))
+}
+
+// withDebug adds the /debug summary, if any, below the answer.
+func withDebug(answer, summary string) string {
+	if summary == "" {
+		return answer
+	}
+	return answer + "\n\n" + summary
+}
+
+// debugHandler lets admins turn the token confidence summary under answers on
+// or off with /debug on|off.
+func debugHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !isAdmin(cfg, c.Sender()) {
+			return c.Send("Only bot admins can use this command")
+		}
+
+		var enabled bool
+		switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+		case "on":
+			enabled = true
+		case "off":
+			enabled = false
+		default:
+			return c.Send("Usage: /debug on to show token confidence under answers, /debug off to hide it")
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.Debug = enabled }); err != nil {
+			updateLogger(c).Error("Error saving debug setting", "err", err)
+			return c.Send("Error saving your preference")
+		}
+
+		if enabled {
+			return c.Send("Answers will show how confident the model was in its tokens")
+		}
+		return c.Send("Token confidence is hidden again")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLogprobsSummary(t *testing.T) {
+	token := func(text string, probability float64) LogprobCandidate {
+		return LogprobCandidate{Token: text, LogProbability: math.Log(probability)}
+	}
+
+	tests := []struct {
+		name   string
+		tokens []LogprobCandidate
+		want   string
+	}{
+		{"no tokens", nil, ""},
+		{
+			name:   "one token",
+			tokens: []LogprobCandidate{token("Hi", 0.5)},
+			want:   `Debug: 1 tokens, average probability 50%, least confident "Hi" 50%`,
+		},
+		{
+			name:   "fewer tokens than listed",
+			tokens: []LogprobCandidate{token("Hi", 0.9), token("!", 0.3)},
+			want:   `Debug: 2 tokens, average probability 60%, least confident "!" 30%, "Hi" 90%`,
+		},
+		{
+			name: "least confident first",
+			tokens: []LogprobCandidate{
+				token("The", 1), token(" sky", 0.2), token(" is", 0.9), token(" blue", 0.1), token(".", 0.3),
+			},
+			want: `Debug: 5 tokens, average probability 50%, least confident " blue" 10%, " sky" 20%, "." 30%`,
+		},
+		{
+			name:   "ties keep their order",
+			tokens: []LogprobCandidate{token("a", 0.5), token("b", 0.5), token("c", 0.5), token("d", 0.5)},
+			want:   `Debug: 4 tokens, average probability 50%, least confident "a" 50%, "b" 50%, "c" 50%`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logprobsSummary(tt.tokens); got != tt.want {
+				t.Errorf("logprobsSummary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogprobsSummaryKeepsTokenOrder(t *testing.T) {
+	tokens := []LogprobCandidate{{"a", -0.1}, {"b", -2}, {"c", -1}}
+	logprobsSummary(tokens)
+	if tokens[0].Token != "a" || tokens[1].Token != "b" || tokens[2].Token != "c" {
+		t.Errorf("logprobsSummary() reordered the tokens to %+v", tokens)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// telegramMessageLimit is the maximum length of a Telegram text message.
+const telegramMessageLimit = 4096
+
+// telegramCaptionLimit is the maximum length of a media caption.
+const telegramCaptionLimit = 1024
+
+const (
+	longFormatChunk = "chunk"
+	longFormatFile  = "file"
+)
+
+// deliverReply sends a text answer through the placeholder. Answers longer
+// than a single message are either split into several messages or attached as
+// a document, depending on the user's /longformat setting. markup is attached
+// to the last message, which is returned. Code blocks and Markdown tables are
+// sent as code entities and the REPLY_FOOTER is added to the end of the
+// answer. Split answers start each message with the CHUNK_INDICATOR.
+func deliverReply(c tele.Context, cfg *Config, thinking *placeholder, settings UserSettings, text string, markup *tele.ReplyMarkup) (*tele.Message, error) {
+	length := utf8.RuneCountInString(text)
+
+	if settings.LongFormat == longFormatFile && length > cfg.LongReplyThreshold {
+		msg, err := sendReplyFile(thinking, text, cfg.ReplyFooter, markup)
+		if err == nil {
+			thinking.Cancel()
+			return msg, nil
+		}
+		updateLogger(c).Error("Error sending reply as a file, splitting it instead", "err", err)
+	}
+
+	chunks := replyChunks(formatTables(text), cfg.ReplyFooter, cfg.ChunkIndicator)
+
+	var msg *tele.Message
+	for i, chunk := range chunks {
+		send := func(text string, opts ...interface{}) (*tele.Message, error) {
+			if i == len(chunks)-1 {
+				opts = append(opts, markup)
+			}
+			if i == 0 {
+				return thinking.Resolve(text, opts...)
+			}
+			return sendReply(c, text, opts...)
+		}
+
+		formatted, entities := formatCodeBlocks(chunk)
+		var err error
+		msg, err = send(formatted, entities)
+		if err != nil && len(entities) > 0 && isEntityParseError(err) {
+			// Deliver the answer as it came rather than lose it
+			updateLogger(c).Warn("Telegram rejected the formatting of a reply, sending it as plain text", "err", err)
+			msg, err = send(chunk)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// withFooter returns text followed by the REPLY_FOOTER footer, if any.
+func withFooter(text, footer string) string {
+	if footer == "" {
+		return text
+	}
+	return text + "\n\n" + footer
+}
+
+// replyChunks splits an answer into messages and adds the footer. When there
+// is more than one message, each starts with indicator, e.g. "(1/3)", and the
+// chunks are made short enough to leave room for it.
+func replyChunks(text, footer, indicator string) []string {
+	limit, reserved := telegramMessageLimit, 0
+	for {
+		chunks := []string{text}
+		if utf8.RuneCountInString(text) > limit {
+			chunks = splitMessage(text, limit)
+		}
+		chunks = appendFooter(chunks, footer, limit)
+		if len(chunks) == 1 || indicator == "" {
+			return chunks
+		}
+
+		// The indicator of the last chunk has the most digits
+		total := len(chunks)
+		need := utf8.RuneCountInString(chunkIndicator(indicator, total, total)) + 1
+		if need <= reserved {
+			for i := range chunks {
+				chunks[i] = chunkIndicator(indicator, i+1, total) + "\n" + chunks[i]
+			}
+			return chunks
+		}
+		// Split again with room for the indicator
+		reserved = need
+		limit = telegramMessageLimit - reserved
+	}
+}
+
+// chunkIndicator fills in the {n} and {total} placeholders of format.
+func chunkIndicator(format string, n, total int) string {
+	return strings.NewReplacer("{n}", strconv.Itoa(n), "{total}", strconv.Itoa(total)).Replace(format)
+}
+
+// appendFooter adds footer to the last of the chunks of an answer, or sends
+// it as a message of its own when the last chunk would get longer than limit.
+func appendFooter(chunks []string, footer string, limit int) []string {
+	if footer == "" {
+		return chunks
+	}
+	last := withFooter(chunks[len(chunks)-1], footer)
+	if utf8.RuneCountInString(last) > limit {
+		return append(chunks, footer)
+	}
+	chunks[len(chunks)-1] = last
+	return chunks
+}
+
+// sendReplyFile sends text as a document with the beginning of the answer as
+// the caption. Answers containing code blocks are sent as Markdown.
+func sendReplyFile(thinking *placeholder, text, footer string, markup *tele.ReplyMarkup) (*tele.Message, error) {
+	name := "answer.txt"
+	if strings.Contains(text, "```") {
+		name = "answer.md"
+	}
+
+	doc := &tele.Document{
+		File:     tele.FromReader(strings.NewReader(text)),
+		FileName: name,
+		Caption:  withFooter(replyFileCaption(text), footer),
+	}
+	return thinking.send(doc, markup)
+}
+
+// replyFileCaption returns the first line of text, shortened to fit nicely
+// above an attached answer.
+func replyFileCaption(text string) string {
+	first := strings.TrimSpace(text)
+	if i := strings.Index(first, "\n"); i >= 0 {
+		first = strings.TrimSpace(first[:i])
+	}
+	if utf8.RuneCountInString(first) > 200 {
+		first = string([]rune(first)[:200]) + "..."
+	}
+	return first + "\n\n(The full answer is attached)"
+}
+
+// longFormatHandler lets users choose how long answers are delivered with
+// /longformat chunk|file.
+func longFormatHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		format := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		if format != longFormatChunk && format != longFormatFile {
+			return c.Send("Usage: /longformat chunk to split long answers into several messages, /longformat file to get them as a document")
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.LongFormat = format }); err != nil {
+			updateLogger(c).Error("Error saving long format", "err", err)
+			return c.Send("Error saving your preference")
+		}
+
+		if format == longFormatFile {
+			return c.Send("Long answers will be sent as a document")
+		}
+		return c.Send("Long answers will be split into several messages")
+	}
+}
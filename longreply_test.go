@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReplyChunks(t *testing.T) {
+	const indicator = "({n}/{total})"
+
+	tests := []struct {
+		name       string
+		text       string
+		footer     string
+		indicator  string
+		wantChunks int
+	}{
+		{"fits", strings.Repeat("a", telegramMessageLimit), "", indicator, 1},
+		{"one over the limit", strings.Repeat("a", telegramMessageLimit+1), "", indicator, 2},
+		{"fits twice without the indicator", strings.Repeat("a", 2*telegramMessageLimit), "", indicator, 3},
+		{"nine chunks", strings.Repeat("a", 9*(telegramMessageLimit-6)), "", indicator, 9},
+		{"tenth chunk widens the indicator", strings.Repeat("a", 9*(telegramMessageLimit-6)+1), "", indicator, 10},
+		{"footer in its own chunk", strings.Repeat("a", telegramMessageLimit), "gemini-2.0-flash", indicator, 2},
+		{"custom format", strings.Repeat("a", telegramMessageLimit+1), "", "[part {n} of {total}]", 2},
+		{"indicator off", strings.Repeat("a", 2*telegramMessageLimit), "", "", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := replyChunks(tt.text, tt.footer, tt.indicator)
+			if len(chunks) != tt.wantChunks {
+				t.Fatalf("replyChunks() returned %d chunks, want %d", len(chunks), tt.wantChunks)
+			}
+
+			var text strings.Builder
+			for i, chunk := range chunks {
+				if n := utf8.RuneCountInString(chunk); n > telegramMessageLimit {
+					t.Errorf("chunk %d has %d characters, over the limit", i+1, n)
+				}
+				if len(chunks) > 1 && tt.indicator != "" {
+					prefix := chunkIndicator(tt.indicator, i+1, len(chunks)) + "\n"
+					var ok bool
+					if chunk, ok = strings.CutPrefix(chunk, prefix); !ok {
+						t.Errorf("chunk %d = %.20q..., want it to start with %q", i+1, chunk, prefix)
+					}
+				}
+				text.WriteString(chunk)
+			}
+
+			got, ok := strings.CutSuffix(text.String(), tt.footer)
+			if !ok {
+				t.Errorf("the last chunk doesn't end with the footer %q", tt.footer)
+			}
+			if strings.TrimRight(got, "\n") != tt.text {
+				t.Error("the chunks without their indicators don't add up to the answer")
+			}
+		})
+	}
+}
+
+func TestChunkIndicator(t *testing.T) {
+	tests := []struct {
+		format string
+		n      int
+		total  int
+		want   string
+	}{
+		{"({n}/{total})", 1, 3, "(1/3)"},
+		{"({n}/{total})", 10, 12, "(10/12)"},
+		{"Part {n}", 2, 5, "Part 2"},
+		{"{n}/{total} {n}", 2, 5, "2/5 2"},
+	}
+
+	for _, tt := range tests {
+		if got := chunkIndicator(tt.format, tt.n, tt.total); got != tt.want {
+			t.Errorf("chunkIndicator(%q, %d, %d) = %q, want %q", tt.format, tt.n, tt.total, got, tt.want)
+		}
+	}
+}
@@ -2,27 +2,32 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	tele "gopkg.in/telebot.v3"
 )
 
-const GEMINI_API_URL = "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash-lite:streamGenerateContent"
-
 type GeminiRequest struct {
-	SystemInstruction Content   `json:"system_instruction"`
-	Contents          []Content `json:"contents"`
-	SafetySettings    []Safety  `json:"safety_settings"`
+	SystemInstruction Content           `json:"system_instruction"`
+	Contents          []Content         `json:"contents"`
+	SafetySettings    []Safety          `json:"safety_settings"`
+	Tools             []Tool            `json:"tools,omitempty"`
+	GenerationConfig  *GenerationConfig `json:"generationConfig,omitempty"`
 }
 
 type Safety struct {
@@ -31,8 +36,31 @@ type Safety struct {
 }
 
 type Part struct {
-	Text       string    `json:"text,omitempty"`
-	InlineData *FileData `json:"inline_data,omitempty"`
+	Text             string            `json:"text,omitempty"`
+	InlineData       *FileData         `json:"inline_data,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+	// Thought marks a summary of the model's reasoning rather than answer text
+	Thought bool `json:"thought,omitempty"`
+}
+
+// UnmarshalJSON accepts inline data under both "inline_data" and the
+// "inlineData" name Gemini uses in responses.
+func (p *Part) UnmarshalJSON(data []byte) error {
+	type part Part
+	var raw struct {
+		part
+		InlineDataCamel *FileData `json:"inlineData"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*p = Part(raw.part)
+	if p.InlineData == nil {
+		p.InlineData = raw.InlineDataCamel
+	}
+	return nil
 }
 
 type FileData struct {
@@ -40,13 +68,42 @@ type FileData struct {
 	Data     string `json:"data"`
 }
 
+// UnmarshalJSON accepts both the snake_case field names used in requests and
+// stored history and the camelCase names Gemini uses in responses.
+func (f *FileData) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		MimeType      string `json:"mime_type"`
+		MimeTypeCamel string `json:"mimeType"`
+		Data          string `json:"data"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	f.MimeType = raw.MimeType
+	if f.MimeType == "" {
+		f.MimeType = raw.MimeTypeCamel
+	}
+	f.Data = raw.Data
+	return nil
+}
+
 type Content struct {
 	Role  string `json:"role"`
 	Parts []Part `json:"parts"`
 }
 
 type GenerationConfig struct {
-	ResponseModalities []string `json:"responseModalities"`
+	ResponseModalities []string        `json:"responseModalities,omitempty"`
+	ResponseMimeType   string          `json:"responseMimeType,omitempty"`
+	ResponseSchema     *Schema         `json:"responseSchema,omitempty"`
+	MaxOutputTokens    int             `json:"maxOutputTokens,omitempty"`
+	ThinkingConfig     *ThinkingConfig `json:"thinkingConfig,omitempty"`
+	CandidateCount     int             `json:"candidateCount,omitempty"`
+	ImageConfig        *ImageConfig    `json:"imageConfig,omitempty"`
+	StopSequences      []string        `json:"stopSequences,omitempty"`
+	SpeechConfig       *SpeechConfig   `json:"speechConfig,omitempty"`
+	ResponseLogprobs   bool            `json:"responseLogprobs,omitempty"`
 }
 
 type ImageGenerationRequest struct {
@@ -56,11 +113,23 @@ type ImageGenerationRequest struct {
 }
 
 type GeminiResponse struct {
-	Candidates []struct {
-		Content struct {
-			Parts []Part `json:"parts"`
-		} `json:"content"`
-	} `json:"candidates"`
+	Candidates    []Candidate    `json:"candidates"`
+	UsageMetadata *UsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+type Candidate struct {
+	Content struct {
+		Role  string `json:"role"`
+		Parts []Part `json:"parts"`
+	} `json:"content"`
+	FinishReason   string          `json:"finishReason,omitempty"`
+	LogprobsResult *LogprobsResult `json:"logprobsResult,omitempty"`
+}
+
+type UsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
 }
 
 type SSEResponse struct {
@@ -70,9 +139,29 @@ type SSEResponse struct {
 }
 
 type Message struct {
-	Role    string    `json:"role"`
-	Message string    `json:"message"`
-	Image   *FileData `json:"image,omitempty"`
+	Role      string    `json:"role"`
+	Message   string    `json:"message"`
+	Image     *FileData `json:"image,omitempty"`
+	ImageRef  int64     `json:"imageRef,omitempty"`
+	MessageID int       `json:"messageId,omitempty"`
+	// ChatID is the chat a model turn was sent to. Message IDs are only
+	// unique within a chat.
+	ChatID int64  `json:"chatId,omitempty"`
+	Rating string `json:"rating,omitempty"`
+	Model  string `json:"model,omitempty"`
+	// Summary marks the synthetic exchange that replaces summarized turns
+	Summary bool `json:"summary,omitempty"`
+	// Usage is the token usage of generating a model turn. It is added to
+	// the user's totals when the turn is saved and not stored itself.
+	Usage TokenUsage `json:"-"`
+	// Thoughts are the model's thought summaries, shown with the answer on
+	// request but never stored
+	Thoughts string `json:"-"`
+	// Alternatives are the other answers generated for the same prompt with
+	// /candidates, one of which can replace Message
+	Alternatives []string `json:"alternatives,omitempty"`
+	// Debug is the /debug token confidence summary, never stored
+	Debug string `json:"-"`
 }
 
 type UserMessages struct {
@@ -80,12 +169,56 @@ type UserMessages struct {
 	TelegramID int64     `json:"telegramId"`
 	Username   string    `json:"username"`
 	Messages   []Message `json:"messages"`
+	// ActiveSession is the named conversation the user is in. The default
+	// session is empty and keeps its turns in Messages.
+	ActiveSession string               `json:"activeSession"`
+	Sessions      map[string][]Message `json:"sessions"`
+	Settings      UserSettings         `json:"settings"`
+	Usage         TokenUsage           `json:"usage"`
+	Quota         DailyQuota           `json:"quota"`
+	// Greeted is set once the user got the first contact greeting
+	Greeted bool `json:"greeted,omitempty"`
+}
+
+// SessionMessages returns the turns of the active session.
+func (u *UserMessages) SessionMessages() []Message {
+	if u.ActiveSession == "" {
+		return u.Messages
+	}
+	return u.Sessions[u.ActiveSession]
 }
 
-func loadEnvFile(filename string) {
+// SetSessionMessages replaces the turns of the active session.
+func (u *UserMessages) SetSessionMessages(messages []Message) {
+	if u.ActiveSession == "" {
+		u.Messages = messages
+		return
+	}
+	if u.Sessions == nil {
+		u.Sessions = make(map[string][]Message)
+	}
+	u.Sessions[u.ActiveSession] = messages
+}
+
+// defaultEnvFile is read when ENV_FILE isn't set.
+const defaultEnvFile = ".env"
+
+// loadEnvFile sets the variables of the .env file at ENV_FILE, or .env by
+// default. Variables already set in the process environment win, so
+// deployments that inject real environment variables can do without a file.
+// A missing default file is skipped silently.
+func loadEnvFile() {
+	filename, explicit := os.LookupEnv("ENV_FILE")
+	if !explicit {
+		filename = defaultEnvFile
+	}
+
 	file, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return
+	}
 	if err != nil {
-		fmt.Println("Error opening file:", err)
+		slog.Error("Error opening env file", "err", err)
 		return
 	}
 	defer file.Close()
@@ -101,591 +234,865 @@ func loadEnvFile(filename string) {
 			continue
 		}
 		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		os.Setenv(key, value) // Set the environment variable
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		os.Setenv(key, parseEnvValue(parts[1]))
 	}
 
 	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading file:", err)
+		slog.Error("Error reading env file", "err", err)
 	}
 }
 
-func getUserMessages(telegramID int64) ([]Message, error) {
-	mokkyURL := os.Getenv("MOKKY_URL")
-	if mokkyURL == "" {
-		return nil, fmt.Errorf("MOKKY_URL environment variable is not set")
+// parseEnvValue unquotes a raw .env value and strips inline comments.
+// Double-quoted values support \" \\ and \n escapes, single-quoted values are
+// taken literally, and in unquoted values a # preceded by whitespace starts a
+// comment.
+func parseEnvValue(raw string) string {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return value
+	}
+
+	switch quote := value[0]; quote {
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(value[i])
+				}
+			case c == '"':
+				return sb.String() // Anything after the closing quote is a comment
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return sb.String()
+	case '\'':
+		if end := strings.IndexByte(value[1:], '\''); end >= 0 {
+			return value[1 : end+1]
+		}
+		return value[1:]
 	}
-	// Get messages for specific user directly
-	resp, err := http.Get(fmt.Sprintf("%susers?telegramId=%d", mokkyURL, telegramID))
+
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return value
+}
+
+// getUserMessages returns the turns of the user's active session with image
+// data stripped. In STATELESS mode there is never any history.
+func getUserMessages(cfg *Config, telegramID int64) ([]Message, error) {
+	stored, err := getStoredMessages(cfg, telegramID)
 	if err != nil {
-		return nil, fmt.Errorf("error getting messages from API: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return stripImageData(telegramID, stored), nil
+}
 
-	var users []UserMessages
-	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
-		return nil, fmt.Errorf("error decoding API response: %v", err)
+// getStoredMessages returns the turns of the user's active session as stored,
+// inline images included.
+func getStoredMessages(cfg *Config, telegramID int64) ([]Message, error) {
+	if cfg.Stateless {
+		return []Message{}, nil
+	}
+
+	historySaves.Wait(telegramID)
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting messages from API: %w", err)
 	}
 
-	if len(users) > 0 {
-		return users[0].Messages, nil
+	if user != nil {
+		return user.SessionMessages(), nil
 	}
 
 	return []Message{}, nil
 }
 
-func saveMessage(telegramID int64, userMsg, aiMsg string, sender *tele.User, imageData *FileData, imageInUserMsg bool) error {
-	mokkyURL := os.Getenv("MOKKY_URL")
-	if mokkyURL == "" {
-		return fmt.Errorf("MOKKY_URL environment variable is not set")
+// recordUsername returns the name stored in the user's record.
+func recordUsername(sender *tele.User) string {
+	if sender.Username != "" {
+		return sender.Username
+	}
+	if sender.FirstName != "" {
+		return sender.FirstName
 	}
+	return "no username " + fmt.Sprint(sender.ID)
+}
 
-	username := "no username " + fmt.Sprint(sender.ID)
-	if sender.Username != "" {
-		username = sender.Username
-	} else if sender.FirstName != "" {
-		username = sender.FirstName
+// saveMessage appends a user turn and the model turn answering it to the
+// user's stored history. Nothing is stored in STATELESS mode.
+func saveMessage(cfg *Config, telegramID int64, sender *tele.User, userTurn, modelTurn Message) error {
+	if cfg.Stateless {
+		return nil
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%susers?telegramId=%d", mokkyURL, telegramID))
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
 	if err != nil {
-		return fmt.Errorf("error checking user existence: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	var users []UserMessages
-	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
-		return fmt.Errorf("error decoding API response: %v", err)
+	if cfg.SeparateImageStorage {
+		externalizeImage(cfg, telegramID, &userTurn)
+		externalizeImage(cfg, telegramID, &modelTurn)
 	}
 
-	var messages []Message
-	var method, url string
+	if user == nil {
+		return createUser(cfg, &UserMessages{
+			TelegramID: telegramID,
+			Username:   recordUsername(sender),
+			Messages:   []Message{userTurn, modelTurn},
+			Usage:      modelTurn.Usage,
+		})
+	}
 
-	var userImage, modelImage *FileData
-	if imageInUserMsg {
-		userImage = imageData
-		modelImage = nil
-	} else {
-		userImage = nil
-		modelImage = imageData
-	}
-
-	if len(users) > 0 {
-		messages = append(users[0].Messages, []Message{
-			{
-				Role:    "user",
-				Message: userMsg,
-				Image:   userImage,
-			},
-			{
-				Role:    "model",
-				Message: aiMsg,
-				Image:   modelImage,
-			},
-		}...)
-		method = "PATCH"
-		url = fmt.Sprintf("%susers/%d", mokkyURL, users[0].ID)
-	} else {
-		messages = []Message{
-			{Role: "user", Message: userMsg, Image: userImage},
-			{Role: "model", Message: aiMsg, Image: modelImage},
-		}
-		method = "POST"
-		url = mokkyURL + "users"
+	user.Username = recordUsername(sender)
+	user.Usage.Add(modelTurn.Usage)
+	user.SetSessionMessages(append(user.SessionMessages(), userTurn, modelTurn))
+	return patchSession(cfg, user)
+}
+
+func deleteUserHistory(cfg *Config, telegramID int64) error {
+	historySaves.Wait(telegramID)
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("no history found for this user")
 	}
 
-	userMsgs := UserMessages{
-		TelegramID: telegramID,
-		Username:   username,
-		Messages:   messages,
+	deleteStoredImages(cfg, user.SessionMessages())
+	settingsCache.Delete(telegramID)
+
+	// Only the active session is cleared, other sessions are kept
+	user.SetSessionMessages([]Message{})
+	return patchHistory(cfg, user)
+}
+
+// photoCache keeps recently downloaded photos so the same photo sent again
+// isn't downloaded and encoded twice. main sizes it from the configuration.
+var photoCache = newLRUCache[string, *FileData](0, 0)
+
+// downloadPhoto fetches a Telegram photo and returns it base64 encoded.
+func downloadPhoto(b *tele.Bot, photo *tele.Photo) (*FileData, error) {
+	// The unique ID is the same for a file everywhere, file IDs may differ
+	key := photo.UniqueID
+	if key == "" {
+		key = photo.FileID
 	}
 
-	jsonData, err := json.Marshal(userMsgs)
+	if cached, ok := photoCache.Get(key); ok {
+		slog.Info("Using cached photo", "file_id", key)
+		photoData := *cached
+		return &photoData, nil
+	}
+
+	photoData, err := downloadFile(b, &photo.File, "image/jpeg")
 	if err != nil {
-		return fmt.Errorf("error marshaling messages: %v", err)
+		return nil, err
 	}
+	photoCache.Set(key, photoData)
+	return photoData, nil
+}
 
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(jsonData))
+// downloadRetryDelay is how long a failed file download waits before its
+// single retry.
+const downloadRetryDelay = time.Second
+
+// errEmptyFile is returned for files Telegram serves without any content.
+var errEmptyFile = errors.New("file is empty")
+
+// downloadFile fetches a Telegram file and returns it base64 encoded.
+// Transient failures are retried once after a short delay.
+func downloadFile(b *tele.Bot, f *tele.File, mimeType string) (*FileData, error) {
+	data, err := readTelegramFile(b, f)
+	if err != nil && !permanentDownloadError(err) {
+		slog.Warn("Error downloading file, retrying", "delay", downloadRetryDelay, "err", err)
+		time.Sleep(downloadRetryDelay)
+		data, err = readTelegramFile(b, f)
+	}
 	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
+	return &FileData{
+		MimeType: mimeType,
+		Data:     base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// readTelegramFile downloads the content of a Telegram file.
+func readTelegramFile(b *tele.Bot, f *tele.File) ([]byte, error) {
+	file, err := b.File(f)
 	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+		return nil, fmt.Errorf("error getting file: %w", err)
 	}
-	defer resp.Body.Close()
+	defer file.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API returned non-200 status code: %d", resp.StatusCode)
+	// FileSize is not always set, so read until the end instead of relying on
+	// it. A single Read may also return less than the whole file.
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file data: %w", err)
 	}
+	if len(data) == 0 {
+		return nil, errEmptyFile
+	}
+	return data, nil
+}
 
-	return nil
+// permanentDownloadError reports whether a download failed in a way a retry
+// can't fix, e.g. an invalid file ID, a file that is too big or one whose
+// download link has expired.
+func permanentDownloadError(err error) bool {
+	if errors.Is(err, errEmptyFile) {
+		return true
+	}
+	var tgErr *tele.Error
+	if errors.As(err, &tgErr) {
+		return tgErr.Code >= 400 && tgErr.Code < 500 && tgErr.Code != http.StatusTooManyRequests
+	}
+	// The file server answers expired links with 404
+	return strings.Contains(err.Error(), "404 Not Found")
 }
 
-func deleteUserHistory(telegramID int64) error {
-	mokkyURL := os.Getenv("MOKKY_URL")
-	if mokkyURL == "" {
-		return fmt.Errorf("MOKKY_URL environment variable is not set")
+// typingInterval is how often the typing action is refreshed. Telegram clears
+// it after about 5 seconds.
+const typingInterval = 4 * time.Second
+
+// keepTyping shows the typing indicator until the returned stop function is
+// called. Stop is safe to call more than once.
+func keepTyping(c tele.Context) func() {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+
+		c.Notify(tele.Typing)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.Notify(tele.Typing)
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
 	}
+}
 
-	// First get the user's record to get their ID
-	resp, err := http.Get(fmt.Sprintf("%susers?telegramId=%d", mokkyURL, telegramID))
-	if err != nil {
-		return fmt.Errorf("error checking user existence: %v", err)
+// sentAs reports whether a model turn was sent as the message messageID of
+// chatID. Turns stored before chat IDs were recorded only match in the
+// private chat with the user, where the chat ID is the user's ID.
+func (m Message) sentAs(telegramID, chatID int64, messageID int) bool {
+	if m.Role == "user" || m.MessageID != messageID {
+		return false
+	}
+	if m.ChatID == 0 {
+		return chatID == telegramID
 	}
-	defer resp.Body.Close()
+	return m.ChatID == chatID
+}
+
+// findUserMessage returns the index of the user message with the given
+// Telegram message ID, or -1 if it isn't in the history.
+func findUserMessage(messages []Message, messageID int) int {
+	for i, msg := range messages {
+		if msg.Role == "user" && msg.MessageID == messageID {
+			return i
+		}
+	}
+	return -1
+}
 
+// findUser fetches the stored record of a user. It returns nil if the user
+// has no record yet.
+func findUser(cfg *Config, telegramID int64) (*UserMessages, error) {
 	var users []UserMessages
-	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
-		return fmt.Errorf("error decoding API response: %v", err)
+	if err := storeRequest(cfg, "GET", fmt.Sprintf("users?telegramId=%d", telegramID), nil, &users); err != nil {
+		return nil, fmt.Errorf("error checking user existence: %w", err)
 	}
 
 	if len(users) == 0 {
-		return fmt.Errorf("no history found for this user")
+		return nil, nil
 	}
+	return &users[0], nil
+}
 
-	// Update the user's record with empty messages array
-	url := fmt.Sprintf("%susers/%d", mokkyURL, users[0].ID)
-	userMsgs := UserMessages{
-		ID:         users[0].ID,
-		TelegramID: telegramID,
-		Username:   users[0].Username,
-		Messages:   []Message{},
+// patchUserFields writes only the given fields of a user record, leaving
+// fields changed meanwhile by other handlers alone.
+func patchUserFields(cfg *Config, user *UserMessages, fields map[string]interface{}) error {
+	if err := storeRequest(cfg, "PATCH", fmt.Sprintf("users/%d", user.ID), fields, nil); err != nil {
+		return fmt.Errorf("error saving user: %w", err)
 	}
+	return nil
+}
 
-	jsonData, err := json.Marshal(userMsgs)
-	if err != nil {
-		return fmt.Errorf("error marshaling messages: %v", err)
+// patchHistory writes only the turns of the user's active session.
+func patchHistory(cfg *Config, user *UserMessages) error {
+	fields := map[string]interface{}{}
+	if user.ActiveSession == "" {
+		fields["messages"] = user.Messages
+	} else {
+		fields["sessions"] = user.Sessions
 	}
+	return patchUserFields(cfg, user, fields)
+}
 
-	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+// patchSession writes only the turns of the user's active session, the
+// username and the token usage. Fields changed meanwhile by other handlers,
+// such as the settings or the daily quota, are left alone. Mokky has no
+// operator to append to an array, so the session is written as a whole.
+func patchSession(cfg *Config, user *UserMessages) error {
+	fields := map[string]interface{}{
+		"username": user.Username,
+		"usage":    user.Usage,
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+	if user.ActiveSession == "" {
+		fields["messages"] = user.Messages
+	} else {
+		fields["sessions"] = user.Sessions
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API returned non-200 status code: %d", resp.StatusCode)
+	if err := storeRequest(cfg, "PATCH", fmt.Sprintf("users/%d", user.ID), fields, nil); err != nil {
+		return fmt.Errorf("error saving history: %w", err)
 	}
-
 	return nil
 }
 
-func cleanupMessageHistory(telegramID int64, messages []Message) error {
-	if len(messages) > 100 {
-		log.Printf("Message history for user %d exceeds 100 messages, cleaning up...", telegramID)
-		if err := deleteUserHistory(telegramID); err != nil {
-			return fmt.Errorf("error cleaning up message history: %v", err)
-		}
-		log.Printf("Successfully cleaned up message history for user %d", telegramID)
+// createUser stores a new user record.
+func createUser(cfg *Config, user *UserMessages) error {
+	if err := storeRequest(cfg, "POST", "users", user, nil); err != nil {
+		return fmt.Errorf("error creating user: %w", err)
 	}
 	return nil
 }
 
-func main() {
-	loadEnvFile(".env")
-	telegramToken := os.Getenv("TELEGRAM_TOKEN")
-	geminiApiKey := os.Getenv("GEMINI_TOKEN")
+// updateExchange rewrites a saved user message and the model reply that
+// follows it, used when the user edits a message that was already answered.
+func updateExchange(cfg *Config, telegramID int64, userMsgID int, userMsg string, modelTurn Message) error {
+	if cfg.Stateless {
+		return nil
+	}
 
-	if telegramToken == "" || geminiApiKey == "" {
-		log.Fatal("Please set TELEGRAM_TOKEN and GEMINI_API_KEY environment variables")
+	historySaves.Wait(telegramID)
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("no history found for this user")
 	}
 
-	pref := tele.Settings{
-		Token:  telegramToken,
-		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+	messages := user.SessionMessages()
+	idx := findUserMessage(messages, userMsgID)
+	if idx < 0 {
+		return fmt.Errorf("message %d not found in history", userMsgID)
 	}
+	messages[idx].Message = userMsg
+	if idx+1 < len(messages) && messages[idx+1].Role != "user" {
+		messages[idx+1] = modelTurn
+	}
+	user.Usage.Add(modelTurn.Usage)
 
-	b, err := tele.NewBot(pref)
-	if err != nil {
-		log.Fatal(err)
-		return
+	return patchSession(cfg, user)
+}
+
+// trimHistory keeps the most recent keep messages. The result always starts
+// with a user turn so user/model pairs are never split.
+func trimHistory(messages []Message, keep int) []Message {
+	if len(messages) <= keep {
+		return messages
 	}
 
-	b.Handle(tele.OnText, func(c tele.Context) error {
-		userMsg := c.Text()
+	trimmed := messages[len(messages)-keep:]
+	for len(trimmed) > 0 && trimmed[0].Role != "user" {
+		trimmed = trimmed[1:]
+	}
+	return trimmed
+}
 
-		c.Notify(tele.Typing)
+// cleanupMessageHistory trims the stored history down to the most recent
+// turns once it grows past the configured limit, and returns the messages
+// that remain. Only turns are trimmed, the rest of the user record is kept.
+func cleanupMessageHistory(cfg *Config, telegramID int64, messages []Message) ([]Message, error) {
+	if len(messages) <= cfg.MaxHistoryMessages {
+		return messages, nil
+	}
 
-		prevMessages, err := getUserMessages(c.Sender().ID)
-		if err != nil {
-			log.Printf("Error getting previous messages: %v\n", err)
-		}
+	slog.Info("Trimming message history", "user_id", telegramID, "max", cfg.MaxHistoryMessages, "keep", cfg.KeepHistoryMessages)
 
-		if err := cleanupMessageHistory(c.Sender().ID, prevMessages); err != nil {
-			log.Printf("Error during message cleanup: %v\n", err)
-		}
+	// The passed messages may have their images stripped, so trim a fresh copy
+	// of the record to avoid losing image data
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return messages, fmt.Errorf("error cleaning up message history: %v", err)
+	}
+	if user == nil {
+		return messages, nil
+	}
 
-		var contextMessages []Content
-		for _, msg := range prevMessages {
-			contextMessages = append(contextMessages, Content{
-				Role:  msg.Role,
-				Parts: []Part{{Text: msg.Message}},
-			})
-		}
-		contextMessages = append(contextMessages, Content{
-			Role:  "user",
-			Parts: []Part{{Text: userMsg}},
-		})
+	stored := user.SessionMessages()
+	kept := trimHistory(stored, cfg.KeepHistoryMessages)
+	deleteStoredImages(cfg, stored[:len(stored)-len(kept)])
+	user.SetSessionMessages(kept)
+	if err := patchHistory(cfg, user); err != nil {
+		return messages, fmt.Errorf("error cleaning up message history: %v", err)
+	}
 
-		reqBody := GeminiRequest{
-			SystemInstruction: Content{
-				Parts: []Part{
-					{Text: "You are a helpful assistant. When responding, act as if you are continuing a conversation. Use only these punctuation marks: , . ? ! - \n" +
-						"Do not use any other special characters or formatting. Keep your responses under 4096 characters. Respond with the actual content only, no need to add role prefixes."},
-				},
-			},
-			Contents: contextMessages,
-			SafetySettings: []Safety{
-				{
-					Category:  "HARM_CATEGORY_HARASSMENT",
-					Threshold: "BLOCK_NONE",
-				},
-				{
-					Category:  "HARM_CATEGORY_HATE_SPEECH",
-					Threshold: "BLOCK_NONE",
-				},
-				{
-					Category:  "HARM_CATEGORY_SEXUALLY_EXPLICIT",
-					Threshold: "BLOCK_NONE",
-				},
-				{
-					Category:  "HARM_CATEGORY_DANGEROUS_CONTENT",
-					Threshold: "BLOCK_NONE",
-				},
-			},
-		}
-
-		jsonData, err := json.Marshal(reqBody)
-		if err != nil {
-			log.Println("Error marshaling request body:", err)
-			return c.Send("Error processing your request")
-		}
+	slog.Info("Trimmed message history", "user_id", telegramID)
+	return trimHistory(messages, cfg.KeepHistoryMessages), nil
+}
 
-		url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent?key=%s", geminiApiKey)
+// newPoller returns the update source selected by BOT_MODE. Long polling is
+// the default, starts after the last update handled before a restart and
+// backs off while Telegram can't be reached. In webhook mode
+// the bot listens on WEBHOOK_LISTEN and registers WEBHOOK_URL with Telegram.
+//
+// Telegram only delivers webhooks over HTTPS on ports 443, 80, 88 or 8443.
+// Behind a reverse proxy that terminates TLS, leave the certificate settings
+// empty and the bot serves plain HTTP on the listen address. Without a proxy,
+// set WEBHOOK_TLS_CERT and WEBHOOK_TLS_KEY so the bot terminates TLS itself;
+// a self-signed certificate is uploaded to Telegram automatically.
+func newPoller(cfg *Config, checkpoint *updateCheckpoint) tele.Poller {
+	if cfg.BotMode != "webhook" {
+		return newReconnectingPoller(cfg, checkpoint.LastID())
+	}
 
-		client := &http.Client{}
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Println("Error creating request:", err)
-			return c.Send("Error creating request")
-		}
+	webhook := &tele.Webhook{
+		Listen:      cfg.WebhookListen,
+		SecretToken: cfg.WebhookSecret,
+		Endpoint:    &tele.WebhookEndpoint{PublicURL: cfg.WebhookURL},
+		// Reactions are only sent when asked for
+		AllowedUpdates: tele.AllowedUpdates,
+	}
+	if cfg.WebhookTLSCert != "" {
+		webhook.TLS = &tele.WebhookTLS{Cert: cfg.WebhookTLSCert, Key: cfg.WebhookTLSKey}
+		webhook.Endpoint.Cert = cfg.WebhookTLSCert
+	}
 
-		req.Header.Set("Content-Type", "application/json")
+	slog.Info("Using webhook mode", "listen", cfg.WebhookListen, "url", cfg.WebhookURL)
+	return webhook
+}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Error making request to Gemini API:", err)
-			return c.Send("Error connecting to AI service")
+// textPromptHandler returns the handler answering a text prompt, used for
+// text messages, edits of unanswered messages and questions about forwarded
+// messages. Repeated prompts are answered from promptCache.
+func textPromptHandler(cfg *Config, promptCache *lruCache[promptKey, string]) func(c tele.Context, userMsg string) error {
+	return func(c tele.Context, userMsg string) error {
+		cfg := userConfig(cfg, c.Sender())
+		userMsg = strings.TrimSpace(userMsg)
+		if userMsg == "" {
+			return c.Send("Your message is empty. Please send a question or some text for me to answer")
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			log.Printf("Error Response Body: %s\n", body)
-			return c.Send("Error: API returned non-200 status code")
+		if ok, err := useDailyQuota(c, cfg); !ok {
+			return err
 		}
+		greetNewUser(c, cfg)
 
-		var geminiResp GeminiResponse
-		if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
-			log.Println("Error decoding response:", err)
-			return c.Send("Error decoding AI response")
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
 		}
 
-		if len(geminiResp.Candidates) > 0 && len(geminiResp.Candidates[0].Content.Parts) > 0 {
-			responseText := geminiResp.Candidates[0].Content.Parts[0].Text
-			telegramID := c.Sender().ID
-			if err := saveMessage(telegramID, userMsg, responseText, c.Sender(), nil, false); err != nil {
-				log.Printf("Error saving messages: %v\n", err)
-			}
-			return c.Send(responseText)
+		cacheKey := newPromptKey(c.Sender().ID, userMsg)
+		if cached, ok := promptCache.Get(cacheKey); ok {
+			updateLogger(c).Info("Answering repeated prompt from cache")
+			_, err := deliverReply(c, cfg, noPlaceholder(c, replyTarget(c, settings)), settings, cached, ratingMarkup(cfg))
+			return err
 		}
 
-		return c.Send("Sorry, I couldn't generate a response")
-	})
-
-	b.Handle(tele.OnPhoto, func(c tele.Context) error {
-		photo := c.Message().Photo
-		if photo == nil {
-			return c.Send("No photo found in message")
+		stopTyping := keepTyping(c)
+		defer stopTyping()
+		thinking := startPlaceholder(c, cfg, replyTarget(c, settings))
+		defer thinking.Cancel()
+
+		// The stored turns keep the image data that the latest generated
+		// image is loaded back from
+		stored, err := getStoredMessages(cfg, c.Sender().ID)
+		prevMessages := stripImageData(c.Sender().ID, stored)
+		switch {
+		case errors.Is(err, errStoreUnavailable):
+			// Keep the conversation going with what was said since the outage
+			updateLogger(c).Error("Error getting previous messages, using the history kept in memory", "err", err)
+			prevMessages = offlineHistory.Messages(c.Sender().ID)
+			if offlineHistory.ShouldNotify(c.Sender().ID) {
+				if err := c.Send(offlineNotice); err != nil {
+					updateLogger(c).Error("Error sending storage notice", "err", err)
+				}
+			}
+		case err != nil:
+			updateLogger(c).Error("Error getting previous messages", "err", err)
+		default:
+			prevMessages = restoreOfflineHistory(cfg, c.Sender(), prevMessages)
 		}
 
-		c.Notify(tele.Typing)
-
-		// Download the photo
-		file, err := b.File(&photo.File)
+		prevMessages, err = cleanupMessageHistory(cfg, c.Sender().ID, prevMessages)
 		if err != nil {
-			log.Printf("Error getting photo file: %v\n", err)
-			return c.Send("Error processing image")
+			updateLogger(c).Error("Error during message cleanup", "err", err)
 		}
 
-		// Read the file data
-		data := make([]byte, photo.File.FileSize)
-		_, err = file.Read(data)
+		prevMessages, err = summarizeOldContext(cfg, c.Sender().ID, userModel(cfg, settings), prevMessages)
 		if err != nil {
-			log.Printf("Error reading photo data: %v\n", err)
-			return c.Send("Error reading image")
+			updateLogger(c).Error("Error summarizing old context", "err", err)
 		}
+		prevMessages = withLatestModelImage(cfg, c.Sender().ID, prevMessages, stored)
 
-		// Convert to base64
-		base64Data := base64.StdEncoding.EncodeToString(data)
-		imageData := &FileData{
-			MimeType: "image/jpeg",
-			Data:     base64Data,
+		opts := replyOptions(cfg, c, settings, userMsg)
+		var modelTurn Message
+		if streamingEnabled(cfg, settings) {
+			modelTurn, err = streamReply(cfg, prevMessages, userMsg, opts, thinking.Update)
+		} else {
+			modelTurn, err = generateReply(cfg, prevMessages, userMsg, opts)
 		}
-
-		userMsg := c.Message().Caption
-		if userMsg == "" {
-			userMsg = "Image sent without caption"
-		}
-
-		reqBody := GeminiRequest{
-			SystemInstruction: Content{
-				Parts: []Part{
-					{Text: "You are a helpful assistant. When analyzing images, provide detailed descriptions and answer any questions about them. Use only these punctuation marks: , . ? ! - \n"},
-				},
-			},
-			Contents: []Content{
-				{
-					Role: "user",
-					Parts: []Part{
-						{Text: userMsg},
-						{InlineData: imageData},
-					},
-				},
-			},
-			SafetySettings: []Safety{
-				{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"},
-				{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "BLOCK_NONE"},
-				{Category: "HARM_CATEGORY_SEXUALLY_EXPLICIT", Threshold: "BLOCK_NONE"},
-				{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_NONE"},
-			},
-		}
-
-		jsonData, err := json.Marshal(reqBody)
+		stopTyping()
 		if err != nil {
-			log.Println("Error marshaling request body:", err)
-			return c.Send("Error processing your request")
+			updateLogger(c).Error("Error generating reply", "err", err)
+			_, err = thinking.Resolve(replyErrorMessage(err))
+			return err
 		}
 
-		url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent?key=%s", geminiApiKey)
-
-		client := &http.Client{}
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+		text := withDebug(withThoughts(modelTurn.Thoughts, modelTurn.Message), modelTurn.Debug) + fallbackNote(opts.Model, modelTurn.Model)
+		reply, err := deliverReply(c, cfg, thinking, settings, text, ratingMarkup(cfg))
 		if err != nil {
-			log.Println("Error creating request:", err)
-			return c.Send("Error creating request")
+			return err
 		}
+		modelTurn.MessageID, modelTurn.ChatID = reply.ID, c.Chat().ID
+		promptCache.Set(cacheKey, modelTurn.Message)
+		sendAlternatives(c, cfg, settings, reply, modelTurn)
 
-		req.Header.Set("Content-Type", "application/json")
+		telegramID := c.Sender().ID
+		userTurn := Message{Role: "user", Message: userMsg, MessageID: c.Message().ID}
+		historySaves.Save(cfg, telegramID, c.Sender(), userTurn, modelTurn)
+		return nil
+	}
+}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Error making request to Gemini API:", err)
-			return c.Send("Error connecting to AI service")
-		}
-		defer resp.Body.Close()
+func main() {
+	loadEnvFile()
+	cfg, err := LoadConfig()
+	if err != nil {
+		slog.Error("Error loading the configuration", "err", err)
+		os.Exit(1)
+	}
+	setupLogging(cfg)
+	if cfg.MockGemini {
+		slog.Info("MOCK_GEMINI is enabled, Gemini API calls will return canned responses")
+	}
+	if cfg.Stateless {
+		slog.Info("STATELESS is enabled, conversations won't be stored")
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			log.Printf("Error Response Body: %s\n", body)
-			return c.Send("Error: API returned non-200 status code")
-		}
+	httpTransport = newHTTPTransport(cfg)
+	checkpoint := loadUpdateCheckpoint(cfg)
 
-		var geminiResp GeminiResponse
-		if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
-			log.Println("Error decoding response:", err)
-			return c.Send("Error decoding AI response")
-		}
+	pref := tele.Settings{
+		Token:  cfg.TelegramToken,
+		Poller: newPoller(cfg, checkpoint),
+		Client: newRateLimitedClient(cfg),
+	}
 
-		if len(geminiResp.Candidates) > 0 && len(geminiResp.Candidates[0].Content.Parts) > 0 {
-			responseText := geminiResp.Candidates[0].Content.Parts[0].Text
-			telegramID := c.Sender().ID
-			if err := saveMessage(telegramID, userMsg, responseText, c.Sender(), imageData, true); err != nil {
-				log.Printf("Error saving messages: %v\n", err)
-			}
-			return c.Send(responseText)
-		}
+	b, err := tele.NewBot(pref)
+	if err != nil {
+		slog.Error("Error creating the bot", "err", err)
+		os.Exit(1)
+	}
+	b.Poller = tele.NewMiddlewarePoller(b.Poller, reactionFilter(b))
+
+	commands := newCommandRegistry(b)
+	geminiLimiter = newRequestLimiter(cfg)
+	historySaves = newSaveQueue(cfg, cfg.SaveQueueSize)
+	workers := newWorkerPool(cfg)
+	cooldown := newUserCooldown(cfg)
+	enabled := newBotSwitch(cfg)
+
+	b.Use(recoverMiddleware, timingMiddleware, senderMiddleware)
+	b.Use(newUpdateDedup(cfg.UpdateDedupWindow, checkpoint).middleware)
+	b.Use(accessMiddleware(cfg))
+	b.Use(enabled.middleware)
+	b.Use(topicMiddleware)
+
+	// Identical prompts sent again within a short window, e.g. retries on a
+	// bad connection, are answered from the cache
+	promptCache := newLRUCache[promptKey, string](cfg.PromptCacheSize, cfg.PromptCacheTTL)
+	photoCache = newLRUCache[string, *FileData](cfg.PhotoCacheSize, cfg.PhotoCacheTTL)
+	settingsCache = newLRUCache[int64, UserSettings](cfg.SettingsCacheSize, cfg.SettingsCacheTTL)
+	chatSettingsCache = newLRUCache[int64, ChatSettings](cfg.SettingsCacheSize, cfg.SettingsCacheTTL)
+
+	handleTextPrompt := textPromptHandler(cfg, promptCache)
 
-		return c.Send("Sorry, I couldn't generate a response")
-	})
+	b.Handle(tele.OnText, func(c tele.Context) error {
+		prompt := withForwardedContext(c.Message(), c.Text())
 
-	b.Handle("/history", func(c tele.Context) error {
-		c.Notify(tele.Typing)
-		err := deleteUserHistory(c.Sender().ID)
-		if err != nil {
-			log.Printf("Error deleting user history: %v\n", err)
-			return c.Send("Error deleting user history")
+		// Questions about a forwarded photo are answered with the photo
+		if replyTo := c.Message().ReplyTo; replyTo != nil && replyTo.Photo != nil && isForwarded(replyTo) {
+			return answerMedia(c, cfg, "Image", prompt, func() (*FileData, string, error) {
+				imageData, err := downloadPhoto(b, replyTo.Photo)
+				return imageData, "", err
+			})
 		}
-		return c.Send("Your messsage history has been cleared!")
-	})
+		return handleTextPrompt(c, prompt)
+	}, cooldown.middleware, workers.middleware)
 
-	b.Handle("/generate", func(c tele.Context) error {
-		prompt := c.Message().Payload
-		if prompt == "" {
-			return c.Send("Please provide a prompt for image generation. Example: /generate a futuristic cityscape with flying cars")
+	b.Handle(tele.OnEdited, func(c tele.Context) error {
+		edited := c.Message()
+		if edited.Text == "" || strings.HasPrefix(edited.Text, "/") {
+			return nil
 		}
 
-		c.Notify(tele.Typing)
-		log.Printf("Processing image generation request with prompt: %s", prompt)
-
-		// Create request body for image generation
-		reqBody := ImageGenerationRequest{
-			Contents: []Content{
-				{
-					Parts: []Part{
-						{Text: prompt},
-					},
-				},
-			},
-			GenerationConfig: GenerationConfig{
-				ResponseModalities: []string{"Text", "Image"},
-			},
-			SafetySettings: []Safety{
-				{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"},
-				{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "BLOCK_NONE"},
-				{Category: "HARM_CATEGORY_SEXUALLY_EXPLICIT", Threshold: "BLOCK_NONE"},
-				{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_NONE"},
-			},
-		}
-
-		jsonData, err := json.Marshal(reqBody)
-		if err != nil {
-			log.Println("Error marshaling request body:", err)
-			return c.Send("Error processing your request")
+		if time.Since(edited.Time()) > cfg.MaxEditAge {
+			return c.Send("This message is too old to regenerate a response for it")
 		}
+		cfg := userConfig(cfg, c.Sender())
 
-		url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash-exp-image-generation:generateContent?key=%s", geminiApiKey)
-		log.Printf("Sending request to URL: %s", url)
-
-		client := &http.Client{Timeout: 60 * time.Second} // Longer timeout for image generation
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+		stored, err := getStoredMessages(cfg, c.Sender().ID)
 		if err != nil {
-			log.Println("Error creating request:", err)
-			return c.Send("Error creating request")
+			updateLogger(c).Error("Error getting previous messages", "err", err)
 		}
+		prevMessages := stripImageData(c.Sender().ID, stored)
 
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Error making request to Gemini API:", err)
-			return c.Send("Error connecting to AI service")
+		// Messages that were never answered are handled as a fresh prompt
+		idx := findUserMessage(prevMessages, edited.ID)
+		if idx < 0 {
+			return handleTextPrompt(c, edited.Text)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			log.Printf("Error Response Body: %s\n", body)
-			return c.Send(fmt.Sprintf("Error: API returned status code %d", resp.StatusCode))
-		}
+		stopTyping := keepTyping(c)
+		defer stopTyping()
 
-		// Read full response body
-		responseBody, err := io.ReadAll(resp.Body)
+		settings, err := getUserSettings(cfg, c.Sender().ID)
 		if err != nil {
-			log.Printf("Error reading response body: %v", err)
-			return c.Send("Error reading API response")
+			updateLogger(c).Error("Error getting user settings", "err", err)
 		}
 
-		// Extract base64 image data directly with regex
-		log.Printf("Extracting image data from response")
+		opts := replyOptions(cfg, c, settings, edited.Text)
+		modelTurn, err := generateReply(cfg, withLatestModelImage(cfg, c.Sender().ID, prevMessages[:idx], stored), edited.Text, opts)
+		stopTyping()
+		if err != nil {
+			updateLogger(c).Error("Error generating reply", "err", err)
+			return c.Send(replyErrorMessage(err))
+		}
 
-		// Use regex to find the base64 encoded image data
-		re := regexp.MustCompile(`"data"\s*:\s*"([^"]+)"`)
-		matches := re.FindStringSubmatch(string(responseBody))
+		// Replace the previous answer in the chat if we know which message it was
+		if idx+1 < len(prevMessages) && prevMessages[idx+1].Role != "user" {
+			modelTurn.MessageID = prevMessages[idx+1].MessageID
+		}
+		text := withDebug(withThoughts(modelTurn.Thoughts, modelTurn.Message), modelTurn.Debug) + fallbackNote(opts.Model, modelTurn.Model)
+		shown := withFooter(formatTables(text), cfg.ReplyFooter)
+		if modelTurn.MessageID != 0 && utf8.RuneCountInString(shown) <= telegramMessageLimit {
+			prevReply := &tele.StoredMessage{MessageID: strconv.Itoa(modelTurn.MessageID), ChatID: c.Chat().ID}
+			formatted, entities := formatCodeBlocks(shown)
+			if _, err := b.Edit(prevReply, formatted, entities, ratingMarkup(cfg)); err != nil {
+				updateLogger(c).Error("Error editing previous reply", "err", err)
+				modelTurn.MessageID = 0
+			}
+		} else {
+			// Answers that no longer fit in one message are sent anew
+			modelTurn.MessageID = 0
+		}
+		if modelTurn.MessageID == 0 {
+			reply, err := deliverReply(c, cfg, noPlaceholder(c, replyTarget(c, settings)), settings, text, ratingMarkup(cfg))
+			if err != nil {
+				return err
+			}
+			modelTurn.MessageID = reply.ID
+		}
+		modelTurn.ChatID = c.Chat().ID
 
-		if len(matches) < 2 {
-			log.Printf("No image data found in the response")
-			return c.Send("Sorry, couldn't generate an image. Please try with a different prompt.")
+		if err := updateExchange(cfg, c.Sender().ID, edited.ID, edited.Text, modelTurn); err != nil {
+			updateLogger(c).Error("Error updating edited exchange", "err", err)
 		}
+		return nil
+	}, cooldown.middleware, workers.middleware)
 
-		base64Data := matches[1]
-		log.Printf("Found base64 image data of length: %d", len(base64Data))
+	b.Handle(tele.OnPhoto, func(c tele.Context) error {
+		photo := c.Message().Photo
+		if photo == nil {
+			return c.Send("No photo found in message")
+		}
 
-		// Create FileData structure to save in database
-		imageData := &FileData{
-			MimeType: "image/png",
-			Data:     base64Data,
+		prompt := c.Message().Caption
+		if isForwarded(c.Message()) {
+			prompt = forwardedContent(c.Message(), prompt)
 		}
 
-		// Extract any text from the response (if present)
-		reText := regexp.MustCompile(`"text"\s*:\s*"([^"]*)"`)
-		textMatches := reText.FindStringSubmatch(string(responseBody))
+		return answerMedia(c, cfg, "Image", prompt, func() (*FileData, string, error) {
+			imageData, err := downloadPhoto(b, photo)
+			return imageData, "", err
+		})
+	}, cooldown.middleware, workers.middleware)
 
-		var responseText string
-		if len(textMatches) >= 2 && textMatches[1] != "" {
-			responseText = textMatches[1]
-			log.Printf("Found text to use as caption: %s", textMatches[1])
-		} else {
-			responseText = "Generated image based on your prompt."
+	b.Handle(tele.OnVideoNote, func(c tele.Context) error {
+		note := c.Message().VideoNote
+		return answerMedia(c, cfg, "Video", c.Message().Caption, func() (*FileData, string, error) {
+			return downloadVideo(b, cfg, &note.File, "video/mp4", note.Thumbnail)
+		})
+	}, cooldown.middleware, workers.middleware)
+
+	b.Handle(tele.OnAnimation, func(c tele.Context) error {
+		animation := c.Message().Animation
+		mimeType := animation.MIME
+		if mimeType == "" {
+			mimeType = "video/mp4"
 		}
+		return answerMedia(c, cfg, "Animation", c.Message().Caption, func() (*FileData, string, error) {
+			return downloadVideo(b, cfg, &animation.File, mimeType, animation.Thumbnail)
+		})
+	}, cooldown.middleware, workers.middleware)
 
-		// Save the message and image to the database
-		telegramID := c.Sender().ID
-		if err := saveMessage(telegramID, prompt, responseText, c.Sender(), imageData, false); err != nil {
-			log.Printf("Error saving generated image to database: %v\n", err)
-			// Continue even if saving fails
-		} else {
-			log.Printf("Successfully saved generated image to user history")
+	b.Handle(tele.OnSticker, func(c tele.Context) error {
+		sticker := c.Message().Sticker
+		if sticker.Animated && sticker.Thumbnail == nil {
+			return c.Send("Sorry, I can't see this animated sticker")
 		}
+		return answerMedia(c, cfg, "Sticker", "", func() (*FileData, string, error) {
+			return downloadSticker(b, cfg, sticker)
+		})
+	}, cooldown.middleware, workers.middleware)
 
-		// Decode the base64 data for sending via Telegram
-		decodedImageData, err := base64.StdEncoding.DecodeString(base64Data)
+	commands.Handle("/history", func(c tele.Context) error {
+		c.Notify(tele.Typing)
+		err := deleteUserHistory(cfg, c.Sender().ID)
 		if err != nil {
-			log.Printf("Error decoding base64 image data: %v", err)
-			return c.Send("Error processing the generated image")
+			updateLogger(c).Error("Error deleting user history", "err", err)
+			return c.Send("Error deleting user history")
 		}
+		return c.Send("Your messsage history has been cleared!")
+	})
 
-		log.Printf("Successfully decoded image data, size: %d bytes", len(decodedImageData))
-
-		// Save the image to a temporary file
-		tempFile, err := os.CreateTemp("", "gemini-image-*.png")
-		if err != nil {
-			log.Printf("Error creating temp file: %v", err)
-			return c.Send("Error saving the generated image")
+	commands.Handle("/undo", undoHandler(cfg))
+	commands.Handle("/showhistory", showHistoryHandler(cfg))
+	commands.Handle("/help", helpHandler)
+	commands.Handle("/preset", presetHandler(cfg))
+	commands.Handle("/maxtokens", maxTokensHandler(cfg))
+	commands.Handle("/thinking", thinkingHandler(cfg))
+	commands.Handle("/model", modelHandler(cfg))
+	commands.Handle("/candidates", candidatesHandler(cfg))
+	commands.Handle("/summarize", summarizeHandler(cfg), cooldown.middleware, workers.middleware)
+	commands.Handle("/translate", translateHandler(cfg), cooldown.middleware, workers.middleware)
+	commands.Handle("/context", contextHandler(cfg))
+	commands.Handle("/session", sessionHandler(cfg))
+	commands.Handle("/sessions", sessionsHandler(cfg))
+	commands.Handle("/lang", langHandler(cfg))
+	commands.Handle("/longformat", longFormatHandler(cfg))
+	commands.Handle("/stream", streamHandler(cfg))
+	commands.Handle("/quote", quoteHandler(cfg))
+	commands.Handle("/rawoutput", rawOutputHandler(cfg))
+	commands.Handle("/stop", stopHandler(cfg))
+	commands.Handle("/mykey", myKeyHandler(cfg))
+	commands.Handle("/feedback", feedbackHandler(b, cfg))
+	commands.Handle("/enable", enabled.toggleHandler(true))
+	commands.Handle("/disable", enabled.toggleHandler(false))
+	commands.Handle("/ping", pingHandler(b, cfg))
+	commands.Handle("/about", aboutHandler(cfg))
+	commands.Handle("/topusers", topUsersHandler(cfg))
+	commands.Handle("/resetusage", resetUsageHandler(cfg))
+	commands.Handle("/inspect", inspectHandler(cfg))
+	commands.Handle("/debug", debugHandler(cfg))
+	commands.Handle("/ratings", ratingsHandler(cfg))
+	commands.Handle("/setpersona", setPersonaHandler(b, cfg))
+	commands.Handle("/pinned", pinnedHandler(b, cfg))
+	b.Handle(tele.OnPinned, pinnedUpdateHandler)
+	b.Handle(&btnRateUp, rateHandler(b, cfg))
+	b.Handle(onReaction, reactionHandler(cfg), workers.middleware)
+	b.Handle(&btnCandidate, chooseCandidateHandler(b, cfg))
+	b.Handle(&btnModel, chooseModelHandler(b, cfg))
+	b.Handle(&btnHistoryPage, historyPageHandler(cfg))
+
+	commands.Handle("/generate", func(c tele.Context) error {
+		prompt := strings.TrimSpace(c.Message().Payload)
+		// Replying to a message with a bare /generate uses its text as the prompt
+		if replyTo := c.Message().ReplyTo; prompt == "" && replyTo != nil {
+			prompt = strings.TrimSpace(replyTo.Text)
+			if prompt == "" {
+				prompt = strings.TrimSpace(replyTo.Caption)
+			}
+		}
+		if prompt == "" {
+			return c.Send("Please provide a prompt for image generation, or reply to a message with /generate. Example: /generate a futuristic cityscape with flying cars")
 		}
 
-		tempFileName := tempFile.Name()
-		defer os.Remove(tempFileName) // Clean up the file when done
+		return runImageGeneration(c, cfg, prompt, nil, imageOptions{}, 1)
+	}, cooldown.middleware, workers.middleware)
+
+	commands.Handle("/imagine", imagineHandler(cfg), cooldown.middleware, workers.middleware)
+	commands.Handle("/regenerate", regenerateImageHandler(cfg), cooldown.middleware, workers.middleware)
+	commands.Handle("/getimage", getImageHandler(cfg))
+	commands.Handle("/describe", describeHandler(b, cfg), cooldown.middleware, workers.middleware)
+	commands.Handle("/ocr", ocrHandler(b, cfg), cooldown.middleware, workers.middleware)
+	commands.Handle("/say", sayHandler(cfg), cooldown.middleware, workers.middleware)
+
+	commands.Handle("/edit", func(c tele.Context) error {
+		prompt := strings.TrimSpace(c.Message().Payload)
+		replyTo := c.Message().ReplyTo
+		if replyTo == nil || replyTo.Photo == nil {
+			return c.Send("Please reply to a photo with /edit and describe the change. Example: /edit make the sky purple")
+		}
+		if prompt == "" {
+			return c.Send("Please describe how to edit the image. Example: /edit make the sky purple")
+		}
 
-		// Write the image data to the file
-		if _, err := tempFile.Write(decodedImageData); err != nil {
-			log.Printf("Error writing to temp file: %v", err)
-			tempFile.Close()
-			return c.Send("Error saving the generated image")
+		source, err := downloadPhoto(b, replyTo.Photo)
+		if err != nil {
+			updateLogger(c).Error("Error downloading photo to edit", "err", err)
+			return c.Send("Couldn't fetch the image, please send it again")
 		}
-		tempFile.Close()
 
-		log.Printf("Image saved to temporary file: %s", tempFileName)
+		return runImageGeneration(c, cfg, prompt, source, imageOptions{}, 1)
+	}, cooldown.middleware, workers.middleware)
 
-		// Send the image file to the user
-		photo := &tele.Photo{File: tele.FromDisk(tempFileName)}
+	if err := commands.Alias(cfg.CommandAliases); err != nil {
+		slog.Error("Error registering command aliases", "err", err)
+		os.Exit(1)
+	}
+	if err := b.SetCommands(commands.Commands()); err != nil {
+		slog.Error("Error setting the command list", "err", err)
+	}
 
-		// Add caption if there's text
-		if responseText != "" {
-			photo.Caption = responseText
-		}
+	notifyInterruptedJobs(b, cfg)
 
-		err = c.Send(photo)
-		if err != nil {
-			log.Printf("Error sending photo: %v", err)
-			return c.Send("Generated an image but couldn't send it. Please try again.")
-		}
+	health := newHealthServer(cfg)
+	health.Start()
 
-		log.Printf("Successfully sent image to user")
-		return nil
-	})
+	// Stop polling on SIGINT or SIGTERM and write the saves still queued
+	// before exiting
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		slog.Info("Shutting down", "signal", sig.String())
+		health.SetReady(false)
+		b.Stop()
+	}()
 
-	log.Println("Bot is running...")
+	slog.Info("Bot is running")
+	health.SetReady(true)
 	b.Start()
+	workers.Close()
+	historySaves.Close()
+	checkpoint.Close()
+	health.Close()
 }
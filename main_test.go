@@ -0,0 +1,420 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestParseEnvValue(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"empty", "", ""},
+		{"plain", "value", "value"},
+		{"surrounding spaces", "  value  ", "value"},
+		{"inline comment", "value # comment", "value"},
+		{"tab before comment", "value\t# comment", "value"},
+		{"hash inside value", "abc#def", "abc#def"},
+		{"double quoted", `"two words"`, "two words"},
+		{"double quoted with comment", `"value" # comment`, "value"},
+		{"double quoted hash", `"a # b"`, "a # b"},
+		{"escapes", `"line\nnext \"quoted\" back\\slash"`, "line\nnext \"quoted\" back\\slash"},
+		{"tab escape", `"a\tb"`, "a\tb"},
+		{"unterminated double quote", `"open`, "open"},
+		{"single quoted", `'no \n escapes'`, `no \n escapes`},
+		{"single quoted with comment", `'value' # comment`, "value"},
+		{"unterminated single quote", `'open`, "open"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseEnvValue(tt.raw); got != tt.want {
+				t.Errorf("parseEnvValue(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+// unsetEnv unsets key for the test and restores it afterwards.
+func unsetEnv(t *testing.T, key string) {
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "test.env")
+	content := "# comment\nENV_TEST_NEW=\"from file\"\nENV_TEST_SET=from file\nnot a variable\n"
+	if err := os.WriteFile(envFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		envFile string
+		wantNew string
+		wantLog bool
+	}{
+		{"explicit file", envFile, "from file", false},
+		{"missing explicit file", filepath.Join(dir, "missing.env"), "", true},
+		{"missing default file", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			if tt.envFile != "" {
+				t.Setenv("ENV_FILE", tt.envFile)
+			} else {
+				unsetEnv(t, "ENV_FILE")
+				// The default .env is looked up in the working directory
+				wd, _ := os.Getwd()
+				if err := os.Chdir(dir); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.Chdir(wd) })
+			}
+			unsetEnv(t, "ENV_TEST_NEW")
+			t.Setenv("ENV_TEST_SET", "from environment")
+
+			loadEnvFile()
+
+			if got := os.Getenv("ENV_TEST_NEW"); got != tt.wantNew {
+				t.Errorf("ENV_TEST_NEW = %q, want %q", got, tt.wantNew)
+			}
+			if got := os.Getenv("ENV_TEST_SET"); got != "from environment" {
+				t.Errorf("ENV_TEST_SET = %q, want the environment to win", got)
+			}
+			if logged := strings.Contains(logs.String(), "Error opening env file"); logged != tt.wantLog {
+				t.Errorf("logged an error = %v, want %v:\n%s", logged, tt.wantLog, logs)
+			}
+		})
+	}
+}
+
+// turns returns n turns alternating between user and model, starting with
+// the user.
+func turns(n int) []Message {
+	messages := make([]Message, n)
+	for i := range messages {
+		messages[i] = Message{Role: "user", Message: fmt.Sprint(i)}
+		if i%2 == 1 {
+			messages[i].Role = "model"
+		}
+	}
+	return messages
+}
+
+func TestTrimHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  []Message
+		keep      int
+		wantFirst string
+		wantLen   int
+	}{
+		{"under the limit", turns(4), 6, "0", 4},
+		{"at the limit", turns(6), 6, "0", 6},
+		{"keeps the most recent", turns(10), 4, "6", 4},
+		{"doesn't start with a model turn", turns(10), 5, "6", 4},
+		{"keep one", turns(10), 1, "", 0},
+		{"keep none", turns(4), 0, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimHistory(tt.messages, tt.keep)
+			if len(got) != tt.wantLen {
+				t.Fatalf("trimHistory() kept %d turns, want %d", len(got), tt.wantLen)
+			}
+			if len(got) > 0 && (got[0].Message != tt.wantFirst || got[0].Role != "user") {
+				t.Errorf("trimHistory() starts with %s turn %q, want user turn %q", got[0].Role, got[0].Message, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestCleanupMessageHistory(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	cfg.MaxHistoryMessages = 8
+	cfg.KeepHistoryMessages = 4
+	store.users[1] = &UserMessages{ID: 1, TelegramID: 7, Messages: turns(10)}
+
+	got, err := cleanupMessageHistory(cfg, 7, turns(10))
+	if err != nil {
+		t.Fatalf("cleanupMessageHistory() error = %v", err)
+	}
+	if len(got) != 4 || got[0].Message != "6" {
+		t.Errorf("cleanupMessageHistory() = %v, want the last 4 turns", got)
+	}
+	if stored := store.messages(7); len(stored) != 4 || stored[0].Message != "6" {
+		t.Errorf("stored turns = %v, want the last 4", stored)
+	}
+
+	// Histories within the limit aren't touched
+	store.reads = 0
+	if got, _ := cleanupMessageHistory(cfg, 7, turns(8)); len(got) != 8 || store.reads != 0 {
+		t.Errorf("cleanupMessageHistory() of 8 turns kept %d and read the store %d times, want 8 and 0", len(got), store.reads)
+	}
+}
+
+// fakeTelegram serves the Bot API methods the bot calls and records them.
+// Methods that send or edit a message answer with a message, everything else
+// with true, unless answers has a result for the method. The content of the
+// files in files is served by file ID, and every download is recorded as a
+// "download" call. The first downloads of a file fail with the statuses in
+// failures.
+type fakeTelegram struct {
+	mu       sync.Mutex
+	calls    []botCall
+	answers  map[string]string
+	files    map[string]string
+	failures map[string][]int
+	nextID   int
+}
+
+// botCall is a recorded Bot API call with its parameters.
+type botCall struct {
+	method string
+	params map[string]string
+}
+
+// newTestBot returns a bot talking to a fakeTelegram.
+func newTestBot(t *testing.T) (*tele.Bot, *fakeTelegram) {
+	api := &fakeTelegram{
+		answers:  make(map[string]string),
+		files:    make(map[string]string),
+		failures: make(map[string][]int),
+	}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	b, err := tele.NewBot(tele.Settings{URL: server.URL, Token: "123:abc", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, api
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/file/") {
+		f.serveFile(w, r)
+		return
+	}
+
+	method := path.Base(r.URL.Path)
+	params := make(map[string]string)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err == nil {
+			for key, values := range r.MultipartForm.Value {
+				params[key] = values[0]
+			}
+		}
+	} else {
+		var raw map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&raw)
+		for key, value := range raw {
+			var s string
+			if json.Unmarshal(value, &s) != nil {
+				s = string(value)
+			}
+			params[key] = s
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, botCall{method: method, params: params})
+	f.nextID++
+	id := f.nextID
+	result, ok := f.answers[method]
+	if _, known := f.files[params["file_id"]]; method == "getFile" && known && !ok {
+		result, ok = fmt.Sprintf(`{"file_id":%q,"file_path":%q}`, params["file_id"], params["file_id"]), true
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		result = "true"
+		if strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit") {
+			result = fmt.Sprintf(`{"message_id":%d,"date":0,"chat":{"id":%s}}`, id, cmp.Or(params["chat_id"], "0"))
+		}
+	}
+	fmt.Fprintf(w, `{"ok":true,"result":%s}`, result)
+}
+
+// serveFile serves the content of a file downloaded by its path, which is the
+// file ID.
+func (f *fakeTelegram) serveFile(w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+	f.mu.Lock()
+	f.calls = append(f.calls, botCall{method: "download", params: map[string]string{"file_id": id}})
+	content, ok := f.files[id]
+	var status int
+	if failures := f.failures[id]; len(failures) > 0 {
+		status, f.failures[id] = failures[0], failures[1:]
+	}
+	f.mu.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, content)
+}
+
+// Calls returns the recorded calls of method.
+func (f *fakeTelegram) Calls(method string) []botCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []botCall
+	for _, call := range f.calls {
+		if call.method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Texts returns the texts of the messages sent so far.
+func (f *fakeTelegram) Texts() []string {
+	var texts []string
+	for _, call := range f.Calls("sendMessage") {
+		texts = append(texts, call.params["text"])
+	}
+	return texts
+}
+
+func TestDownloadPhotoCache(t *testing.T) {
+	old := photoCache
+	photoCache = newLRUCache[string, *FileData](10, time.Hour)
+	t.Cleanup(func() { photoCache = old })
+	b, api := newTestBot(t)
+	api.files["photo"] = "jpeg data"
+	api.files["resent"] = "jpeg data"
+
+	photo := &tele.Photo{File: tele.File{FileID: "photo", UniqueID: "unique"}}
+	first, err := downloadPhoto(b, photo)
+	if err != nil {
+		t.Fatalf("downloadPhoto() error = %v", err)
+	}
+	// The same photo sent again has another file ID but the same unique ID
+	resent := &tele.Photo{File: tele.File{FileID: "resent", UniqueID: "unique"}}
+	second, err := downloadPhoto(b, resent)
+	if err != nil {
+		t.Fatalf("downloadPhoto() error = %v", err)
+	}
+
+	if downloads := len(api.Calls("download")); downloads != 1 {
+		t.Errorf("downloaded %d times, want 1", downloads)
+	}
+	if *first != *second || first == second {
+		t.Errorf("cached photo = %+v, want a copy of %+v", second, first)
+	}
+
+	// Changes to a returned photo don't reach the cache
+	second.Data = ""
+	if third, _ := downloadPhoto(b, photo); third.Data != first.Data {
+		t.Error("the cached photo was modified through a returned copy")
+	}
+}
+
+func TestDownloadFileRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		failures      []int
+		wantErr       bool
+		wantDownloads int
+	}{
+		{"no failure", "jpeg data", nil, false, 1},
+		{"transient failure", "jpeg data", []int{http.StatusBadGateway}, false, 2},
+		{"failing twice", "jpeg data", []int{http.StatusBadGateway, http.StatusInternalServerError}, true, 2},
+		{"expired link", "jpeg data", []int{http.StatusNotFound}, true, 1},
+		{"empty file", "", nil, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, api := newTestBot(t)
+			api.files["photo"] = tt.content
+			api.failures["photo"] = tt.failures
+
+			got, err := downloadFile(b, &tele.File{FileID: "photo"}, "image/jpeg")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("downloadFile() = %+v, want an error", got)
+				}
+			} else if err != nil {
+				t.Fatalf("downloadFile() error = %v", err)
+			} else if want := base64.StdEncoding.EncodeToString([]byte(tt.content)); got.Data != want || got.MimeType != "image/jpeg" {
+				t.Errorf("downloadFile() = %+v, want the encoded content", got)
+			}
+			if downloads := len(api.Calls("download")); downloads != tt.wantDownloads {
+				t.Errorf("downloaded %d times, want %d", downloads, tt.wantDownloads)
+			}
+		})
+	}
+}
+
+func TestSaveMessageWrites(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	sender := &tele.User{ID: 1, Username: "alice"}
+
+	// The first exchange creates the record
+	userTurn, modelTurn := exchange(0)
+	modelTurn.Usage = TokenUsage{PromptTokens: 10}
+	if err := saveMessage(cfg, sender.ID, sender, userTurn, modelTurn); err != nil {
+		t.Fatalf("saveMessage() error = %v", err)
+	}
+	if len(store.writes) != 1 || store.writes[0].method != http.MethodPost {
+		t.Fatalf("writes = %+v, want one POST", store.writes)
+	}
+
+	// Settings changed meanwhile are left alone by later saves
+	store.users[1].Settings.Language = "German"
+	userTurn, modelTurn = exchange(1)
+	modelTurn.Usage = TokenUsage{PromptTokens: 5}
+	if err := saveMessage(cfg, sender.ID, sender, userTurn, modelTurn); err != nil {
+		t.Fatalf("saveMessage() error = %v", err)
+	}
+	want := storeWrite{method: http.MethodPatch, fields: []string{"messages", "usage", "username"}}
+	if len(store.writes) != 2 || !reflect.DeepEqual(store.writes[1], want) {
+		t.Errorf("writes = %+v, want a PATCH of the turns, usage and username", store.writes)
+	}
+	got := store.users[1]
+	if len(got.Messages) != 4 || got.Usage.PromptTokens != 15 || got.Settings.Language != "German" {
+		t.Errorf("stored record = %+v, want 4 turns, 15 tokens and the German settings", got)
+	}
+
+	// In a named session only the sessions are written
+	store.users[1].ActiveSession = "work"
+	if err := saveMessage(cfg, sender.ID, sender, userTurn, modelTurn); err != nil {
+		t.Fatalf("saveMessage() error = %v", err)
+	}
+	want = storeWrite{method: http.MethodPatch, fields: []string{"sessions", "usage", "username"}}
+	if len(store.writes) != 3 || !reflect.DeepEqual(store.writes[2], want) {
+		t.Errorf("writes = %+v, want a PATCH of the sessions, usage and username", store.writes)
+	}
+	if got := store.users[1]; len(got.Sessions["work"]) != 2 || len(got.Messages) != 4 {
+		t.Errorf("stored record = %+v, want 2 turns in the session and 4 in the default one", got)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Limits for /maxtokens. The upper bound is the output limit of the text
+// model, the lower one keeps answers from being cut off mid-sentence.
+const (
+	minOutputTokens = 16
+	maxOutputTokens = 8192
+)
+
+// maxTokensHandler caps the length of answers with /maxtokens <n>.
+// "/maxtokens off" goes back to the model default.
+func maxTokensHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		payload := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		if payload == "" {
+			settings, err := getUserSettings(cfg, c.Sender().ID)
+			if err != nil {
+				updateLogger(c).Error("Error getting user settings", "err", err)
+			}
+			current := "off"
+			if settings.MaxOutputTokens > 0 {
+				current = strconv.Itoa(settings.MaxOutputTokens)
+			}
+			return c.Send(fmt.Sprintf("Max answer tokens: %s\n\nUsage: /maxtokens <%d-%d>, /maxtokens off to use the model default", current, minOutputTokens, maxOutputTokens))
+		}
+
+		tokens := 0
+		if payload != "off" {
+			n, err := strconv.Atoi(payload)
+			if err != nil || n < minOutputTokens || n > maxOutputTokens {
+				return c.Send(fmt.Sprintf("Please choose a number between %d and %d, or off", minOutputTokens, maxOutputTokens))
+			}
+			tokens = n
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.MaxOutputTokens = tokens }); err != nil {
+			updateLogger(c).Error("Error saving max tokens", "err", err)
+			return c.Send("Error saving your preference")
+		}
+
+		if tokens == 0 {
+			return c.Send("Answers use the default length limit again")
+		}
+		return c.Send(fmt.Sprintf("Answers are now limited to %d tokens", tokens))
+	}
+}
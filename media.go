@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const mediaSystemInstruction = "You are a helpful assistant. When analyzing images or videos, provide detailed descriptions and answer any questions about them. Use only these punctuation marks: , . ? ! - \n"
+
+// answerMedia answers a photo, video note or animation with the user's prompt,
+// usually the caption. download fetches the media and may return a note that
+// is added to the prompt, e.g. when only a frame of a video could be sent.
+// kind names the media in messages.
+func answerMedia(c tele.Context, cfg *Config, kind, prompt string, download func() (*FileData, string, error)) error {
+	cfg = userConfig(cfg, c.Sender())
+	if ok, err := useDailyQuota(c, cfg); !ok {
+		return err
+	}
+
+	settings, err := getUserSettings(cfg, c.Sender().ID)
+	if err != nil {
+		updateLogger(c).Error("Error getting user settings", "err", err)
+	}
+
+	stopTyping := keepTyping(c)
+	defer stopTyping()
+	thinking := startPlaceholder(c, cfg, replyTarget(c, settings))
+	defer thinking.Cancel()
+
+	media, note, err := download()
+	if err != nil {
+		updateLogger(c).Error("Error downloading media", "kind", strings.ToLower(kind), "err", err)
+		return c.Send("Couldn't fetch the " + strings.ToLower(kind) + ", please send it again")
+	}
+
+	userMsg := strings.TrimSpace(prompt)
+	if userMsg == "" {
+		userMsg = kind + " sent without caption"
+	}
+
+	prompt = userMsg
+	if note != "" {
+		prompt += "\n\n" + note
+	}
+
+	reqBody := GeminiRequest{
+		SystemInstruction: Content{
+			Parts: []Part{{Text: mediaSystemInstruction}},
+		},
+		Contents: []Content{
+			{
+				Role: "user",
+				Parts: []Part{
+					{Text: prompt},
+					{InlineData: media},
+				},
+			},
+		},
+	}
+
+	requested := userModel(cfg, settings)
+	body, model, err := generateWithFallback(cfg, requested, reqBody, cfg.TextTimeout)
+	stopTyping()
+	if err != nil {
+		updateLogger(c).Error("Error generating content", "err", err)
+		return c.Send(replyErrorMessage(err))
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		updateLogger(c).Error("Error decoding response", "err", err)
+		return c.Send("Error decoding AI response")
+	}
+
+	if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
+		return c.Send("Sorry, I couldn't generate a response")
+	}
+
+	responseText := cleanReply(cfg, geminiResp.Candidates[0].Content.Parts[0].Text, userMsg)
+	telegramID := c.Sender().ID
+	userTurn := Message{Role: "user", Message: userMsg, Image: media, MessageID: c.Message().ID}
+	modelTurn := Message{Role: responseRole(geminiResp.Candidates[0].Content.Role), Message: responseText, Model: model}
+	modelTurn.Usage.AddMetadata(geminiResp.UsageMetadata)
+	historySaves.Save(cfg, telegramID, c.Sender(), userTurn, modelTurn)
+
+	_, err = deliverReply(c, cfg, thinking, settings, responseText+fallbackNote(requested, model), nil)
+	return err
+}
+
+// downloadVideo fetches a video note or animation. Videos over MAX_VIDEO_BYTES
+// are replaced by their thumbnail, and the returned note tells the model it
+// only sees a single frame.
+func downloadVideo(b *tele.Bot, cfg *Config, file *tele.File, mimeType string, thumbnail *tele.Photo) (*FileData, string, error) {
+	if file.FileSize == 0 || file.FileSize <= int64(cfg.MaxVideoBytes) {
+		video, err := downloadFile(b, file, mimeType)
+		if err != nil {
+			return nil, "", err
+		}
+		if base64.StdEncoding.DecodedLen(len(video.Data)) <= cfg.MaxVideoBytes {
+			return video, "", nil
+		}
+	}
+
+	if thumbnail == nil {
+		return nil, "", fmt.Errorf("video is larger than %d bytes and has no thumbnail", cfg.MaxVideoBytes)
+	}
+
+	slog.Info("Video is too large, using its thumbnail instead", "bytes", file.FileSize, "max_bytes", cfg.MaxVideoBytes)
+	frame, err := downloadPhoto(b, thumbnail)
+	if err != nil {
+		return nil, "", err
+	}
+	return frame, "(The video was too large, this is a single frame of it)", nil
+}
+
+// downloadSticker fetches a sticker. Static stickers are WebP images and video
+// stickers WebM videos, which Gemini reads as they are. Animated stickers are
+// Lottie animations Gemini can't read, so their thumbnail is sent instead.
+// The returned note tells the model which emoji the sticker stands for.
+func downloadSticker(b *tele.Bot, cfg *Config, sticker *tele.Sticker) (*FileData, string, error) {
+	var notes []string
+	if sticker.Emoji != "" {
+		notes = append(notes, "(The sticker stands for "+sticker.Emoji+")")
+	}
+
+	var (
+		data *FileData
+		err  error
+	)
+	switch {
+	case sticker.Video:
+		var note string
+		data, note, err = downloadVideo(b, cfg, &sticker.File, "video/webm", sticker.Thumbnail)
+		if note != "" {
+			notes = append(notes, note)
+		}
+	case sticker.Animated:
+		if sticker.Thumbnail == nil {
+			return nil, "", fmt.Errorf("animated sticker has no thumbnail")
+		}
+		data, err = downloadFile(b, &sticker.Thumbnail.File, "image/webp")
+		notes = append(notes, "(The sticker is animated, this is a single frame of it)")
+	default:
+		data, err = downloadFile(b, &sticker.File, "image/webp")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Thumbnails may be JPEG rather than WebP, so the type is read from the data
+	if raw, err := base64.StdEncoding.DecodeString(data.Data); err == nil && strings.HasPrefix(data.MimeType, "image/") {
+		data.MimeType = http.DetectContentType(raw)
+	}
+	return data, strings.Join(notes, "\n"), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestDownloadVideo(t *testing.T) {
+	const maxBytes = 10
+	thumbnail := &tele.Photo{File: tele.File{FileID: "thumb"}}
+
+	tests := []struct {
+		name          string
+		file          tele.File
+		content       string
+		thumbnail     *tele.Photo
+		want          string
+		wantNote      bool
+		wantErr       bool
+		wantDownloads []string
+	}{
+		{"small video", tele.File{FileID: "video", FileSize: 5}, "video", thumbnail, "video", false, false, []string{"video"}},
+		{"unknown size", tele.File{FileID: "video"}, "video", thumbnail, "video", false, false, []string{"video"}},
+		{"unknown size turns out too large", tele.File{FileID: "video"}, strings.Repeat("v", 20), thumbnail, "frame", true, false, []string{"video", "thumb"}},
+		{"too large", tele.File{FileID: "video", FileSize: 20}, strings.Repeat("v", 20), thumbnail, "frame", true, false, []string{"thumb"}},
+		{"too large without thumbnail", tele.File{FileID: "video", FileSize: 20}, strings.Repeat("v", 20), nil, "", false, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, api := newTestBot(t)
+			api.files["video"] = tt.content
+			api.files["thumb"] = "frame"
+			cfg := &Config{MaxVideoBytes: maxBytes}
+
+			file := tt.file
+			data, note, err := downloadVideo(b, cfg, &file, "video/mp4", tt.thumbnail)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadVideo() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got, _ := base64.StdEncoding.DecodeString(data.Data); string(got) != tt.want {
+					t.Errorf("downloadVideo() = %q, want %q", got, tt.want)
+				}
+				if (note != "") != tt.wantNote {
+					t.Errorf("downloadVideo() note = %q, want a note %v", note, tt.wantNote)
+				}
+				wantMIME := "video/mp4"
+				if tt.wantNote {
+					wantMIME = "image/jpeg"
+				}
+				if data.MimeType != wantMIME {
+					t.Errorf("downloadVideo() mime type = %q, want %q", data.MimeType, wantMIME)
+				}
+			}
+
+			var downloads []string
+			for _, call := range api.Calls("download") {
+				downloads = append(downloads, call.params["file_id"])
+			}
+			if strings.Join(downloads, ",") != strings.Join(tt.wantDownloads, ",") {
+				t.Errorf("downloaded %q, want %q", downloads, tt.wantDownloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// recoverMiddleware turns a panic in a handler into a logged error and a
+// generic reply, so one bad update doesn't take down the bot.
+func recoverMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				updateLogger(c).Error("Panic while handling update", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+				if c.Chat() != nil {
+					c.Send("Sorry, something went wrong while handling your message")
+				}
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return next(c)
+	}
+}
+
+// timingMiddleware logs how long each update took to handle.
+func timingMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		start := time.Now()
+		err := next(c)
+		updateLogger(c).Info("Handled update", "duration", time.Since(start).Round(time.Millisecond))
+		return err
+	}
+}
+
+// senderMiddleware drops updates without a sender, e.g. channel posts. Every
+// handler keys history and settings by the sender, so they can't be answered.
+// Nothing is sent back, as a reply in a channel would be public.
+func senderMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Sender() != nil {
+			return next(c)
+		}
+
+		updateLogger(c).Info("Ignoring update without a sender")
+		return nil
+	}
+}
+
+// botSwitch is the global enable flag. While the bot is disabled only admins
+// get answers.
+type botSwitch struct {
+	cfg     *Config
+	enabled atomic.Bool
+}
+
+func newBotSwitch(cfg *Config) *botSwitch {
+	s := &botSwitch{cfg: cfg}
+	s.enabled.Store(cfg.BotEnabled)
+	return s
+}
+
+func (s *botSwitch) middleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !s.enabled.Load() && !isAdmin(s.cfg, c.Sender()) {
+			return nil
+		}
+		return next(c)
+	}
+}
+
+// toggleHandler lets admins turn the bot on and off with /enable and /disable.
+func (s *botSwitch) toggleHandler(enable bool) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !isAdmin(s.cfg, c.Sender()) {
+			return c.Send("Only bot admins can use this command")
+		}
+
+		s.enabled.Store(enable)
+		updateLogger(c).Info("Bot enabled changed", "enabled", enable)
+		if enable {
+			return c.Send("The bot is enabled for everyone")
+		}
+		return c.Send("The bot is disabled, only admins get answers")
+	}
+}
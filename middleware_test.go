@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// logBuffer collects log output written from any goroutine.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs sends the default logger to a buffer for the test.
+func captureLogs(t *testing.T) *logBuffer {
+	logs := &logBuffer{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return logs
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+	b, api := newTestBot(t)
+	c := b.NewContext(tele.Update{ID: 3, Message: &tele.Message{
+		Sender: &tele.User{ID: 1},
+		Chat:   &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+		Text:   "hi",
+	}})
+
+	handler := recoverMiddleware(func(c tele.Context) error {
+		var settings *UserSettings
+		return c.Send(settings.Language)
+	})
+	err := handler(c)
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: ") {
+		t.Errorf("error = %v, want the panic", err)
+	}
+
+	if texts := api.Texts(); len(texts) != 1 || texts[0] != "Sorry, something went wrong while handling your message" {
+		t.Errorf("replies = %q, want the apology", texts)
+	}
+	logged := logs.String()
+	for _, want := range []string{"Panic while handling update", "update_id=3", "nil pointer dereference", "stack="} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("logs don't contain %q:\n%s", want, logged)
+		}
+	}
+
+	// Handlers that don't panic are left alone
+	if err := recoverMiddleware(func(tele.Context) error { return nil })(c); err != nil {
+		t.Errorf("error = %v without a panic", err)
+	}
+}
+
+func TestSenderMiddleware(t *testing.T) {
+	channel := &tele.Chat{ID: -100, Type: tele.ChatChannel}
+	tests := []struct {
+		name       string
+		update     tele.Update
+		wantCalled bool
+	}{
+		{"message", tele.Update{ID: 1, Message: &tele.Message{Sender: &tele.User{ID: 1}, Chat: &tele.Chat{ID: 1}, Text: "hi"}}, true},
+		{"channel post", tele.Update{ID: 2, ChannelPost: &tele.Message{Chat: channel, Text: "news"}}, false},
+		{"edited channel post", tele.Update{ID: 3, EditedChannelPost: &tele.Message{Chat: channel, Text: "news"}}, false},
+		{"callback", tele.Update{ID: 4, Callback: &tele.Callback{ID: "1", Sender: &tele.User{ID: 1}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			b, api := newTestBot(t)
+
+			called := false
+			handler := senderMiddleware(func(c tele.Context) error {
+				called = true
+				// Handlers rely on the sender being set
+				_ = c.Sender().ID
+				return nil
+			})
+			if err := handler(b.NewContext(tt.update)); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			if texts := api.Texts(); len(texts) != 0 {
+				t.Errorf("replies = %q, want none", texts)
+			}
+			if ignored := strings.Contains(logs.String(), "Ignoring update without a sender"); ignored == tt.wantCalled {
+				t.Errorf("logged the ignored update = %v, want %v", ignored, !tt.wantCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxModelNameLength keeps model names within Telegram's 64 byte limit for
+// callback data.
+const maxModelNameLength = 50
+
+var (
+	modelMenu = &tele.ReplyMarkup{}
+	btnModel  = modelMenu.Data("Model", "model")
+)
+
+// defaultModels are the text models users can pick from when TEXT_MODELS
+// isn't set.
+var defaultModels = []string{textModel, "gemini-2.5-flash", "gemini-2.5-pro"}
+
+// userModel returns the text model the user chose with /model, or the default
+// one when the choice is no longer offered.
+func userModel(cfg *Config, settings UserSettings) string {
+	if settings.Model != "" && slices.Contains(cfg.Models, settings.Model) {
+		return settings.Model
+	}
+	return textModel
+}
+
+// modelMarkup returns a keyboard with a button per available model, the
+// current one marked with a check.
+func modelMarkup(cfg *Config, current string) *tele.ReplyMarkup {
+	markup := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, model := range cfg.Models {
+		btn := btnModel
+		btn.Text = model
+		if model == current {
+			btn.Text = "✓ " + model
+		}
+		btn.Data = model
+		rows = append(rows, markup.Row(btn))
+	}
+	markup.Inline(rows...)
+	return markup
+}
+
+// setUserModel stores model as the user's text model. It returns false for
+// models that aren't offered.
+func setUserModel(cfg *Config, sender *tele.User, model string) (bool, error) {
+	if !slices.Contains(cfg.Models, model) {
+		return false, nil
+	}
+	if model == textModel {
+		model = ""
+	}
+	return true, updateUserSettings(cfg, sender, func(s *UserSettings) { s.Model = model })
+}
+
+// modelHandler shows the available models as buttons with /model, or picks
+// one directly with /model <name>.
+func modelHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		name := strings.TrimSpace(c.Message().Payload)
+		if name == "" {
+			settings, err := getUserSettings(cfg, c.Sender().ID)
+			if err != nil {
+				updateLogger(c).Error("Error getting user settings", "err", err)
+			}
+			return c.Send("Choose the model that answers your messages:", modelMarkup(cfg, userModel(cfg, settings)))
+		}
+
+		ok, err := setUserModel(cfg, c.Sender(), name)
+		if !ok {
+			return c.Send("Unknown model. Available models: " + strings.Join(cfg.Models, ", "))
+		}
+		if err != nil {
+			updateLogger(c).Error("Error saving model", "err", err)
+			return c.Send("Error saving your preference")
+		}
+		return c.Send("Your messages will be answered by " + name)
+	}
+}
+
+// chooseModelHandler stores the model whose button was pressed and updates
+// the keyboard to show it as the current one.
+func chooseModelHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		model := c.Data()
+		ok, err := setUserModel(cfg, c.Sender(), model)
+		if !ok {
+			return c.Respond(&tele.CallbackResponse{Text: "This model is no longer available"})
+		}
+		if err != nil {
+			updateLogger(c).Error("Error saving model", "err", err)
+			return c.Respond(&tele.CallbackResponse{Text: "Error saving your preference"})
+		}
+
+		if _, err := b.EditReplyMarkup(c.Message(), modelMarkup(cfg, model)); err != nil {
+			updateLogger(c).Error("Error updating model buttons", "err", err)
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "Your messages will be answered by " + model})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestChooseModelHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		failWrites bool
+		wantAnswer string
+		wantModel  string
+		wantSaved  bool
+	}{
+		{"offered model", "gemini-2.5-pro", false, "Your messages will be answered by gemini-2.5-pro", "gemini-2.5-pro", true},
+		{"default model", textModel, false, "Your messages will be answered by " + textModel, "", true},
+		{"unknown model", "gemini-ultra", false, "This model is no longer available", "", false},
+		{"failing store", "gemini-2.5-pro", true, "Error saving your preference", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSettingsCache(t)
+			store, cfg := newFakeUserStore(t, 0)
+			cfg.Models = defaultModels
+			store.failWrites = tt.failWrites
+			b, api := newTestBot(t)
+
+			c := b.NewContext(tele.Update{Callback: &tele.Callback{
+				ID:      "1",
+				Sender:  &tele.User{ID: 7, Username: "alice"},
+				Message: &tele.Message{ID: 3, Chat: &tele.Chat{ID: 7, Type: tele.ChatPrivate}},
+				Data:    tt.data,
+			}})
+			if err := chooseModelHandler(b, cfg)(c); err != nil {
+				t.Fatalf("chooseModelHandler() error = %v", err)
+			}
+
+			answers := api.Calls("answerCallbackQuery")
+			if len(answers) != 1 || answers[0].params["text"] != tt.wantAnswer {
+				t.Errorf("callback answers = %v, want %q", answers, tt.wantAnswer)
+			}
+			if saved := len(store.users) > 0; saved != tt.wantSaved {
+				t.Fatalf("settings saved = %v, want %v", saved, tt.wantSaved)
+			}
+			if tt.wantSaved && store.users[1].Settings.Model != tt.wantModel {
+				t.Errorf("stored model = %q, want %q", store.users[1].Settings.Model, tt.wantModel)
+			}
+
+			edits := api.Calls("editMessageReplyMarkup")
+			if !tt.wantSaved {
+				if len(edits) != 0 {
+					t.Error("the model buttons were updated without a saved choice")
+				}
+				return
+			}
+			if len(edits) != 1 || !strings.Contains(edits[0].params["reply_markup"], "✓ "+tt.data) {
+				t.Errorf("button updates = %v, want %q checked", edits, tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// ocrNoText is what the model answers when a photo has no readable text.
+const ocrNoText = "NO_TEXT_FOUND"
+
+const ocrSystemInstruction = "You extract text from images. Return only the text visible in the image, exactly as written, " +
+	"keeping its line breaks. Do not translate, correct, summarize or comment on it. " +
+	"If the image contains no readable text, answer with " + ocrNoText + " and nothing else."
+
+// ocrPrompt is the user turn stored for an /ocr request.
+const ocrPrompt = "Extract the text from this photo"
+
+// ocrHandler answers /ocr sent as a reply to a photo with the text found in
+// it. The exchange is saved like any other photo turn.
+func ocrHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		cfg := userConfig(cfg, c.Sender())
+		replyTo := c.Message().ReplyTo
+		if replyTo == nil || replyTo.Photo == nil {
+			return c.Send("Please reply to a photo with /ocr")
+		}
+		if ok, err := useDailyQuota(c, cfg); !ok {
+			return err
+		}
+
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+
+		stopTyping := keepTyping(c)
+		defer stopTyping()
+		thinking := startPlaceholder(c, cfg, replyTarget(c, settings))
+		defer thinking.Cancel()
+
+		imageData, err := downloadPhoto(b, replyTo.Photo)
+		if err != nil {
+			updateLogger(c).Error("Error downloading photo for OCR", "err", err)
+			return c.Send("Couldn't fetch the image, please send it again")
+		}
+
+		reqBody := GeminiRequest{
+			SystemInstruction: Content{
+				Parts: []Part{{Text: ocrSystemInstruction}},
+			},
+			Contents: []Content{
+				{
+					Role: "user",
+					Parts: []Part{
+						{Text: ocrPrompt},
+						{InlineData: imageData},
+					},
+				},
+			},
+		}
+
+		requested := userModel(cfg, settings)
+		body, model, err := generateWithFallback(cfg, requested, reqBody, cfg.TextTimeout)
+		stopTyping()
+		if err != nil {
+			updateLogger(c).Error("Error extracting text", "err", err)
+			return c.Send(replyErrorMessage(err))
+		}
+
+		var geminiResp GeminiResponse
+		if err := json.Unmarshal(body, &geminiResp); err != nil {
+			updateLogger(c).Error("Error decoding response", "err", err)
+			return c.Send("Error decoding AI response")
+		}
+		if len(geminiResp.Candidates) == 0 {
+			return c.Send("Sorry, I couldn't generate a response")
+		}
+
+		text := strings.TrimSpace(candidateText(geminiResp.Candidates[0].Content.Parts))
+		if text == "" || text == ocrNoText {
+			text = "No text found"
+		}
+
+		userTurn := Message{Role: "user", Message: ocrPrompt, Image: imageData, MessageID: c.Message().ID}
+		modelTurn := Message{Role: "model", Message: text, Model: model}
+		modelTurn.Usage.AddMetadata(geminiResp.UsageMetadata)
+		historySaves.Save(cfg, c.Sender().ID, c.Sender(), userTurn, modelTurn)
+
+		_, err = deliverReply(c, cfg, thinking, settings, text+fallbackNote(requested, model), nil)
+		return err
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// personaVariables are the variables a persona may use, e.g. {{username}}.
+var personaVariables = []string{"username", "date", "language"}
+
+// parsePersona parses a persona as a template that may only use
+// personaVariables. vars holds their values, and missing ones are empty.
+func parsePersona(tmpl string, vars map[string]string) (*template.Template, error) {
+	funcs := template.FuncMap{}
+	for _, name := range personaVariables {
+		value := vars[name]
+		funcs[name] = func() string { return value }
+	}
+	return template.New("persona").Funcs(funcs).Option("missingkey=error").Parse(tmpl)
+}
+
+// renderPersona fills the variables of a persona template.
+func renderPersona(tmpl string, vars map[string]string) (string, error) {
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl, nil
+	}
+
+	t, err := parsePersona(tmpl, vars)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, map[string]string{}); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// validatePersona checks that a persona renders, so broken templates are
+// rejected when they are set rather than on every answer.
+func validatePersona(tmpl string) error {
+	_, err := renderPersona(tmpl, map[string]string{"username": "user", "date": "2006-01-02", "language": "English"})
+	if err != nil {
+		return fmt.Errorf("invalid persona template, the variables are {{%s}}: %v", strings.Join(personaVariables, "}}, {{"), err)
+	}
+	return nil
+}
+
+// personaVars returns the values of the persona variables for a message.
+func personaVars(c tele.Context, language string) map[string]string {
+	sender := c.Sender()
+	username := sender.FirstName
+	if username == "" {
+		username = sender.Username
+	}
+	if language == "" {
+		language = sender.LanguageCode
+	}
+	return map[string]string{
+		"username": username,
+		"date":     time.Now().UTC().Format("2006-01-02"),
+		"language": language,
+	}
+}
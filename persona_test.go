@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestRenderPersona(t *testing.T) {
+	vars := map[string]string{"username": "Alice", "date": "2024-03-10", "language": "German"}
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		vars    map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"plain text", "You are a pirate.", vars, "You are a pirate.", false},
+		{"variables", "Talk to {{username}} in {{language}}. Today is {{date}}.", vars, "Talk to Alice in German. Today is 2024-03-10.", false},
+		{"missing value", "Hi {{username}}!", map[string]string{}, "Hi !", false},
+		{"braces without template", "Use { and } freely", vars, "Use { and } freely", false},
+		{"unknown variable", "Hi {{name}}", vars, "", true},
+		{"field access", "Hi {{.username}}", vars, "", true},
+		{"unclosed action", "Hi {{username", vars, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderPersona(tt.tmpl, tt.vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderPersona(%q) error = %v, want error %v", tt.tmpl, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderPersona(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePersona(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		wantErr bool
+	}{
+		{"You are a pirate.", false},
+		{"Greet {{username}} on {{date}} in {{language}}", false},
+		{"Greet {{user}}", true},
+		{"{{if}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			if err := validatePersona(tt.tmpl); (err != nil) != tt.wantErr {
+				t.Errorf("validatePersona(%q) error = %v, want error %v", tt.tmpl, err, tt.wantErr)
+			}
+		})
+	}
+}
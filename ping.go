@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// pingCacheTTL is how long a Gemini latency measurement is reused so /ping
+// spam doesn't burn quota.
+const pingCacheTTL = 30 * time.Second
+
+// geminiPinger measures and caches the round-trip time to the Gemini API.
+type geminiPinger struct {
+	mu      sync.Mutex
+	latency time.Duration
+	err     error
+	checked time.Time
+}
+
+// measure returns the Gemini latency and whether it came from the cache.
+func (p *geminiPinger) measure(cfg *Config) (time.Duration, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.checked.IsZero() && time.Since(p.checked) < pingCacheTTL {
+		return p.latency, true, p.err
+	}
+
+	reqBody := map[string]interface{}{
+		"contents":         []Content{{Role: "user", Parts: []Part{{Text: "ping"}}}},
+		"generationConfig": map[string]int{"maxOutputTokens": 1},
+	}
+
+	start := time.Now()
+	_, err := generateContent(cfg, textModel, reqBody, 10*time.Second)
+	p.latency, p.err, p.checked = time.Since(start), err, time.Now()
+
+	return p.latency, false, p.err
+}
+
+// pingHandler reports how long it takes to send a Telegram message and to get
+// an answer from Gemini.
+func pingHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	pinger := &geminiPinger{}
+
+	return func(c tele.Context) error {
+		start := time.Now()
+		msg, err := sendReply(c, "Pong...")
+		if err != nil {
+			return err
+		}
+		telegramLatency := time.Since(start)
+
+		geminiStatus := "unavailable"
+		latency, cached, err := pinger.measure(cfg)
+		if err != nil {
+			updateLogger(c).Error("Error pinging Gemini", "err", err)
+		} else {
+			geminiStatus = fmt.Sprintf("%dms", latency.Milliseconds())
+			if cached {
+				geminiStatus += " (cached)"
+			}
+		}
+
+		_, err = b.Edit(msg, fmt.Sprintf("Pong! Telegram: %dms, Gemini: %s", telegramLatency.Milliseconds(), geminiStatus))
+		return err
+	}
+}
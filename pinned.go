@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxPinnedLength bounds the pinned message sent as context, so a long pin
+// can't crowd out the conversation.
+const maxPinnedLength = 4000
+
+// pinnedCache keeps the pinned message text of recent chats, so it isn't
+// fetched from Telegram for every answer. An empty text means the chat has no
+// pinned message. New pins update it as they happen.
+var pinnedCache = newLRUCache[int64, string](256, 10*time.Minute)
+
+// isGroupChat reports whether chat is a group or supergroup.
+func isGroupChat(chat *tele.Chat) bool {
+	return chat != nil && (chat.Type == tele.ChatGroup || chat.Type == tele.ChatSuperGroup)
+}
+
+// pinnedText returns the text of a pinned message, cut to maxPinnedLength.
+func pinnedText(msg *tele.Message) string {
+	if msg == nil {
+		return ""
+	}
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > maxPinnedLength {
+		text = string(runes[:maxPinnedLength])
+	}
+	return text
+}
+
+// chatPinnedMessage returns the text of the pinned message of a chat, or an
+// empty string if it has none.
+func chatPinnedMessage(b *tele.Bot, chat *tele.Chat) (string, error) {
+	if text, ok := pinnedCache.Get(chat.ID); ok {
+		return text, nil
+	}
+
+	full, err := b.ChatByID(chat.ID)
+	if err != nil {
+		return "", err
+	}
+	text := pinnedText(full.PinnedMessage)
+	pinnedCache.Set(chat.ID, text)
+	return text, nil
+}
+
+// pinnedContext returns the pinned message of the chat to use as background
+// for answers, if the chat turned it on with /pinned.
+func pinnedContext(cfg *Config, c tele.Context) string {
+	if !isGroupChat(c.Chat()) {
+		return ""
+	}
+
+	settings, err := cachedChatSettings(cfg, c.Chat().ID)
+	if err != nil {
+		updateLogger(c).Error("Error getting chat settings", "err", err)
+		return ""
+	}
+	if !settings.PinnedContext {
+		return ""
+	}
+
+	text, err := chatPinnedMessage(c.Bot(), c.Chat())
+	if err != nil {
+		updateLogger(c).Error("Error getting pinned message", "err", err)
+		return ""
+	}
+	return text
+}
+
+// pinnedUpdateHandler refreshes the cached pinned message when a message is
+// pinned in a chat.
+func pinnedUpdateHandler(c tele.Context) error {
+	if c.Chat() != nil && c.Message().PinnedMessage != nil {
+		pinnedCache.Set(c.Chat().ID, pinnedText(c.Message().PinnedMessage))
+	}
+	return nil
+}
+
+// pinnedHandler lets chat administrators use the pinned message as background
+// for answers in the chat with /pinned on, or stop with /pinned off.
+func pinnedHandler(b *tele.Bot, cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !isGroupChat(c.Chat()) {
+			return c.Send("This command only works in group chats")
+		}
+
+		var enable bool
+		switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+		case "on":
+			enable = true
+		case "off":
+			enable = false
+		default:
+			return c.Send("Usage: /pinned on to answer with the pinned message in mind, /pinned off to ignore it")
+		}
+
+		isAdmin, err := isChatAdmin(b, c.Chat(), c.Sender())
+		if err != nil {
+			updateLogger(c).Error("Error checking chat admin", "err", err)
+			return c.Send("Error checking your permissions")
+		}
+		if !isAdmin {
+			return c.Send("Only chat administrators can change this setting")
+		}
+
+		if err := updateChatSettings(cfg, c.Chat().ID, func(s *ChatSettings) { s.PinnedContext = enable }); err != nil {
+			updateLogger(c).Error("Error saving pinned context setting", "err", err)
+			return c.Send("Error saving the setting")
+		}
+
+		if !enable {
+			return c.Send("The pinned message is no longer used for answers")
+		}
+		text, err := chatPinnedMessage(b, c.Chat())
+		if err != nil {
+			updateLogger(c).Error("Error getting pinned message", "err", err)
+		}
+		if text == "" {
+			return c.Send("The pinned message will be used for answers. This chat has no pinned text message yet")
+		}
+		return c.Send("The pinned message will be used for answers")
+	}
+}
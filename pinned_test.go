@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestPinnedContextInRequest(t *testing.T) {
+	const pinned = "Rule 1: be kind"
+	group := &tele.Chat{ID: -100, Type: tele.ChatGroup}
+
+	tests := []struct {
+		name    string
+		chat    *tele.Chat
+		enabled bool
+		getChat string
+		want    bool
+	}{
+		{"turned on", group, true, `{"id":-100,"type":"group","pinned_message":{"message_id":9,"date":0,"text":"` + pinned + `"}}`, true},
+		{"pinned caption", group, true, `{"id":-100,"type":"group","pinned_message":{"message_id":9,"date":0,"caption":"` + pinned + `"}}`, true},
+		{"turned off", group, false, `{"id":-100,"type":"group","pinned_message":{"message_id":9,"date":0,"text":"` + pinned + `"}}`, false},
+		{"no pinned message", group, true, `{"id":-100,"type":"group"}`, false},
+		{"private chat", &tele.Chat{ID: 1, Type: tele.ChatPrivate}, true, `{"id":1,"type":"private","pinned_message":{"message_id":9,"date":0,"text":"` + pinned + `"}}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useChatSettingsCache(t)
+			old := pinnedCache
+			pinnedCache = newLRUCache[int64, string](10, time.Hour)
+			t.Cleanup(func() { pinnedCache = old })
+
+			var sent struct {
+				SystemInstruction Content `json:"system_instruction"`
+			}
+			cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&sent)
+				fmt.Fprint(w, geminiText("hi"))
+			})
+			_, storeCfg := newFakeChatStore(t)
+			cfg.MokkyURL, cfg.StoreTimeout = storeCfg.MokkyURL, storeCfg.StoreTimeout
+			if err := updateChatSettings(cfg, tt.chat.ID, func(s *ChatSettings) { s.PinnedContext = tt.enabled }); err != nil {
+				t.Fatalf("updateChatSettings() error = %v", err)
+			}
+			b, api := newTestBot(t)
+			api.answers["getChat"] = tt.getChat
+
+			// The pinned message is fetched once per chat
+			for i := 0; i < 2; i++ {
+				c := b.NewContext(tele.Update{Message: &tele.Message{
+					Sender: &tele.User{ID: 1},
+					Chat:   tt.chat,
+					Text:   "what does rule 1 say?",
+				}})
+				opts := replyOptions(cfg, c, UserSettings{}, "what does rule 1 say?")
+				if _, err := generateReply(cfg, nil, "what does rule 1 say?", opts); err != nil {
+					t.Fatalf("generateReply() error = %v", err)
+				}
+
+				var instruction string
+				for _, part := range sent.SystemInstruction.Parts {
+					instruction += part.Text
+				}
+				if got := strings.Contains(instruction, "The pinned message of this chat"); got != tt.want {
+					t.Errorf("system instruction has the pinned message = %v, want %v:\n%s", got, tt.want, instruction)
+				}
+				if tt.want && !strings.HasSuffix(instruction, "\n"+pinned) {
+					t.Errorf("system instruction = %q, want it to end with the pinned message", instruction)
+				}
+			}
+			wantCalls := 0
+			if tt.enabled && isGroupChat(tt.chat) {
+				wantCalls = 1
+			}
+			if calls := len(api.Calls("getChat")); calls != wantCalls {
+				t.Errorf("getChat was called %d times, want %d", calls, wantCalls)
+			}
+		})
+	}
+}
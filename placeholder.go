@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// placeholder is a "thinking" message that is sent when a response takes a
+// while and is later edited into the final answer. Fast responses never show
+// it. Streamed answers are shown in it as they arrive.
+type placeholder struct {
+	c        tele.Context
+	mu       sync.Mutex
+	timer    *time.Timer
+	msg      *tele.Message
+	done     bool
+	interval time.Duration
+	edited   time.Time
+	replyTo  *tele.Message
+}
+
+// startPlaceholder schedules the placeholder message configured with
+// THINKING_PLACEHOLDER. It returns a placeholder that does nothing when the
+// feature is disabled. Messages are sent as replies to replyTo, if set.
+func startPlaceholder(c tele.Context, cfg *Config, replyTo *tele.Message) *placeholder {
+	p := &placeholder{c: c, interval: cfg.StreamEditInterval, replyTo: replyTo}
+	if cfg.ThinkingPlaceholder == "" {
+		return p
+	}
+
+	p.timer = time.AfterFunc(cfg.PlaceholderDelay, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.done || p.msg != nil {
+			return
+		}
+
+		msg, err := p.send(cfg.ThinkingPlaceholder)
+		if err != nil {
+			updateLogger(c).Error("Error sending placeholder", "err", err)
+			return
+		}
+		p.msg = msg
+	})
+	return p
+}
+
+// noPlaceholder returns a placeholder that is never shown, for answers that
+// are sent right away.
+func noPlaceholder(c tele.Context, replyTo *tele.Message) *placeholder {
+	return &placeholder{c: c, replyTo: replyTo}
+}
+
+// send sends a message to the chat of the placeholder, quoting replyTo.
+func (p *placeholder) send(what interface{}, opts ...interface{}) (*tele.Message, error) {
+	if p.replyTo != nil {
+		// Send options have to come first, later options are merged into them
+		opts = append([]interface{}{&tele.SendOptions{ReplyTo: p.replyTo, AllowWithoutReply: true}}, opts...)
+	}
+	return sendReply(p.c, what, opts...)
+}
+
+// Update shows the partial text of a streamed answer. Edits are throttled to
+// one per STREAM_EDIT_INTERVAL, the final text is shown by Resolve.
+func (p *placeholder) Update(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done || time.Since(p.edited) < p.interval {
+		return
+	}
+	if utf8.RuneCountInString(text) > telegramMessageLimit {
+		text = string([]rune(text)[:telegramMessageLimit])
+	}
+
+	if p.msg == nil {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		msg, err := p.send(text)
+		if err != nil {
+			updateLogger(p.c).Error("Error sending streamed answer", "err", err)
+			return
+		}
+		p.msg = msg
+	} else if _, err := p.c.Bot().Edit(p.msg, text); err != nil {
+		updateLogger(p.c).Error("Error updating streamed answer", "err", err)
+	}
+	p.edited = time.Now()
+}
+
+// stop prevents the placeholder from being sent if it hasn't been yet and
+// returns the placeholder message, if any.
+func (p *placeholder) stop() *tele.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	msg := p.msg
+	p.msg = nil
+	return msg
+}
+
+// Resolve replaces the placeholder with text. If no placeholder was shown or
+// editing it fails, text is sent as a new message instead.
+func (p *placeholder) Resolve(text string, opts ...interface{}) (*tele.Message, error) {
+	msg := p.stop()
+	if msg == nil {
+		return p.send(text, opts...)
+	}
+
+	edited, err := p.c.Bot().Edit(msg, text, opts...)
+	if err == nil {
+		return edited, nil
+	}
+	// A streamed answer may already show the final text
+	if errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified) {
+		return msg, nil
+	}
+
+	updateLogger(p.c).Error("Error editing placeholder, sending a new message", "err", err)
+	if err := p.c.Bot().Delete(msg); err != nil {
+		updateLogger(p.c).Error("Error deleting placeholder", "err", err)
+	}
+	return p.send(text, opts...)
+}
+
+// Cancel removes the placeholder, for answers that can't be edited into it
+// such as photos.
+func (p *placeholder) Cancel() {
+	if msg := p.stop(); msg != nil {
+		if err := p.c.Bot().Delete(msg); err != nil {
+			updateLogger(p.c).Error("Error deleting placeholder", "err", err)
+		}
+	}
+}
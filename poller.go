@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// pollRetryDelay is the wait before polling again after the first failure.
+// It doubles with every failure in a row up to POLL_RETRY_MAX.
+const pollRetryDelay = time.Second
+
+// reconnectingPoller long polls Telegram like tele.LongPoller, but waits with
+// exponential backoff after failed requests instead of retrying right away,
+// so losing the network doesn't turn into a busy loop.
+type reconnectingPoller struct {
+	timeout  time.Duration
+	maxDelay time.Duration
+	lastID   int
+}
+
+// newReconnectingPoller returns a poller that starts after the update lastID,
+// the last one handled before a restart.
+func newReconnectingPoller(cfg *Config, lastID int) *reconnectingPoller {
+	return &reconnectingPoller{timeout: cfg.PollTimeout, maxDelay: cfg.PollRetryMax, lastID: lastID}
+}
+
+// Poll fetches updates until stop is closed.
+func (p *reconnectingPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	delay := time.Duration(0)
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		updates, err := p.getUpdates(b)
+		if err != nil {
+			failures++
+			delay = nextPollDelay(delay, p.maxDelay)
+			slog.Error("Error polling updates, reconnecting", "attempt", failures, "delay", delay, "err", err)
+			continue
+		}
+		if failures > 0 {
+			slog.Info("Reconnected to Telegram", "failed_attempts", failures)
+			failures = 0
+		}
+		delay = 0
+
+		for _, update := range updates {
+			p.lastID = update.ID
+			dest <- update
+		}
+	}
+}
+
+// getUpdates requests the updates after the last one received.
+func (p *reconnectingPoller) getUpdates(b *tele.Bot) ([]tele.Update, error) {
+	allowed, _ := json.Marshal(tele.AllowedUpdates)
+	data, err := b.Raw("getUpdates", map[string]string{
+		"offset":          strconv.Itoa(p.lastID + 1),
+		"timeout":         strconv.Itoa(int(p.timeout / time.Second)),
+		"allowed_updates": string(allowed),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Result []tele.Update
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// nextPollDelay doubles the wait after a failure, starting at pollRetryDelay
+// and capped at maxDelay.
+func nextPollDelay(delay, maxDelay time.Duration) time.Duration {
+	if delay == 0 {
+		delay = pollRetryDelay
+	} else {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextPollDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		maxDelay time.Duration
+		want     time.Duration
+	}{
+		{"first failure", 0, time.Minute, pollRetryDelay},
+		{"doubles", 4 * time.Second, time.Minute, 8 * time.Second},
+		{"capped", 40 * time.Second, time.Minute, time.Minute},
+		{"stays at the cap", time.Minute, time.Minute, time.Minute},
+		{"cap below the first delay", 0, 500 * time.Millisecond, 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextPollDelay(tt.delay, tt.maxDelay); got != tt.want {
+				t.Errorf("nextPollDelay(%v, %v) = %v, want %v", tt.delay, tt.maxDelay, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextPollDelaySequence(t *testing.T) {
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	var delay time.Duration
+	for i, w := range want {
+		delay = nextPollDelay(delay, 10*time.Second)
+		if delay != w*time.Second {
+			t.Errorf("delay after %d failures = %v, want %v", i+1, delay, w*time.Second)
+		}
+	}
+}
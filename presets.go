@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// responsePresets are predefined answer styles users can pick with /preset.
+// They are added to the system instruction next to the chat persona.
+var responsePresets = map[string]string{
+	"concise":  "Answer as briefly as possible. Prefer one short paragraph and skip introductions and summaries.",
+	"detailed": "Give thorough answers. Explain the reasoning, cover edge cases and add examples where they help.",
+	"formal":   "Use a formal, professional tone. Avoid slang, jokes and emoji.",
+	"casual":   "Use a relaxed, friendly tone, as if chatting with a friend.",
+	"code":     "Focus on code. Lead with a working code example and keep the prose to short explanations of it.",
+}
+
+// presetNames returns the names of all presets in alphabetical order.
+func presetNames() []string {
+	names := make([]string, 0, len(responsePresets))
+	for name := range responsePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presetHandler selects the answer style with /preset <name>. "/preset off"
+// goes back to the default style.
+func presetHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		name := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		if name == "" {
+			settings, err := getUserSettings(cfg, c.Sender().ID)
+			if err != nil {
+				updateLogger(c).Error("Error getting user settings", "err", err)
+			}
+			current := "off"
+			if settings.Preset != "" {
+				current = settings.Preset
+			}
+			return c.Send("Current preset: " + current + "\n\nAvailable presets: " + strings.Join(presetNames(), ", ") + "\nUsage: /preset concise, /preset off to go back to the default style")
+		}
+
+		if name == "off" {
+			name = ""
+		} else if _, ok := responsePresets[name]; !ok {
+			return c.Send("Unknown preset. Available presets: " + strings.Join(presetNames(), ", "))
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.Preset = name }); err != nil {
+			updateLogger(c).Error("Error saving preset", "err", err)
+			return c.Send("Error saving your preset")
+		}
+
+		if name == "" {
+			return c.Send("Preset turned off, answers use the default style")
+		}
+		return c.Send("Preset " + name + " is now active")
+	}
+}
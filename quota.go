@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// quotaDayFormat identifies the UTC day a quota count belongs to.
+const quotaDayFormat = "2006-01-02"
+
+// DailyQuota counts the messages a user sent on one UTC day.
+type DailyQuota struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// use counts n messages sent at now and reports whether they are all within
+// limit. The count starts over on a new UTC day. Nothing is counted when the
+// messages don't all fit.
+func (q *DailyQuota) use(now time.Time, limit, n int) bool {
+	day := now.UTC().Format(quotaDayFormat)
+	if q.Day != day {
+		q.Day = day
+		q.Count = 0
+	}
+	if q.Count+n > limit {
+		return false
+	}
+	q.Count += n
+	return true
+}
+
+// untilQuotaReset returns the time left until the quotas reset at the next
+// midnight UTC.
+func untilQuotaReset(now time.Time) time.Duration {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return midnight.Sub(now)
+}
+
+// useDailyQuota counts a message of sender against DAILY_MESSAGE_LIMIT. It
+// reports whether the message may be answered, and tells the user when they
+// will be able to continue if not. Admins are exempt.
+func useDailyQuota(c tele.Context, cfg *Config) (bool, error) {
+	return reserveDailyQuota(c, cfg, 1)
+}
+
+// reserveDailyQuota counts n messages of sender at once, e.g. for a batch of
+// images, and reports whether all of them may be answered.
+func reserveDailyQuota(c tele.Context, cfg *Config, n int) (bool, error) {
+	sender := c.Sender()
+	if cfg.DailyMessageLimit <= 0 || isAdmin(cfg, sender) {
+		return true, nil
+	}
+
+	now := time.Now()
+	quota, ok, err := countDailyQuota(cfg, sender, n, now)
+	if err != nil {
+		// Don't lock users out while the store is having trouble
+		updateLogger(c).Error("Error loading daily quota", "err", err)
+		return true, nil
+	}
+	if ok {
+		return true, nil
+	}
+
+	wait := untilQuotaReset(now).Round(time.Minute)
+	if left := cfg.DailyMessageLimit - quota.Count; left > 0 {
+		return false, c.Send(fmt.Sprintf("You only have %d of %d messages left for today, not enough for %d. The limit resets in %s", left, cfg.DailyMessageLimit, n, formatWait(wait)))
+	}
+	return false, c.Send(fmt.Sprintf("You've used all %d messages for today. The limit resets in %s", cfg.DailyMessageLimit, formatWait(wait)))
+}
+
+// countDailyQuota counts n messages of sender sent at now and returns the
+// quota afterwards and whether the messages fit. The record is read and
+// written under the user's lock, so messages arriving at once can't both pass
+// the limit or create two records. In STATELESS mode quotas are only counted
+// in memory.
+func countDailyQuota(cfg *Config, sender *tele.User, n int, now time.Time) (DailyQuota, bool, error) {
+	if cfg.Stateless {
+		var ok bool
+		quota := statelessQuotas.Update(sender.ID, func(q *DailyQuota) { ok = q.use(now, cfg.DailyMessageLimit, n) })
+		return quota, ok, nil
+	}
+
+	defer userLocks.Lock(sender.ID)()
+	user, err := findUser(cfg, sender.ID)
+	if err != nil {
+		return DailyQuota{}, false, err
+	}
+
+	if user == nil {
+		user = &UserMessages{
+			TelegramID: sender.ID,
+			Username:   recordUsername(sender),
+			Messages:   []Message{},
+		}
+		if !user.Quota.use(now, cfg.DailyMessageLimit, n) {
+			return user.Quota, false, nil
+		}
+		if err := createUser(cfg, user); err != nil {
+			slog.Error("Error saving daily quota", "user_id", sender.ID, "err", err)
+		}
+		return user.Quota, true, nil
+	}
+
+	if !user.Quota.use(now, cfg.DailyMessageLimit, n) {
+		return user.Quota, false, nil
+	}
+	// Only the quota is written so a history save running at the same time
+	// isn't overwritten
+	if err := patchUserFields(cfg, user, map[string]interface{}{"quota": user.Quota}); err != nil {
+		slog.Error("Error saving daily quota", "user_id", sender.ID, "err", err)
+	}
+	return user.Quota, true, nil
+}
+
+// formatWait formats a duration as hours and minutes, e.g. "3h 12m".
+func formatWait(d time.Duration) string {
+	hours := int(d / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
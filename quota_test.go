@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestDailyQuotaUse(t *testing.T) {
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		quota     DailyQuota
+		now       time.Time
+		n         int
+		want      bool
+		wantQuota DailyQuota
+	}{
+		{"first message", DailyQuota{}, day, 1, true, DailyQuota{"2024-03-10", 1}},
+		{"within limit", DailyQuota{"2024-03-10", 3}, day, 1, true, DailyQuota{"2024-03-10", 4}},
+		{"reaches limit", DailyQuota{"2024-03-10", 4}, day, 1, true, DailyQuota{"2024-03-10", 5}},
+		{"over limit", DailyQuota{"2024-03-10", 5}, day, 1, false, DailyQuota{"2024-03-10", 5}},
+		{"new day starts over", DailyQuota{"2024-03-09", 5}, day, 1, true, DailyQuota{"2024-03-10", 1}},
+		{"day is UTC", DailyQuota{"2024-03-10", 5}, time.Date(2024, 3, 11, 1, 0, 0, 0, time.FixedZone("UTC+3", 3*60*60)), 1, false, DailyQuota{"2024-03-10", 5}},
+		{"batch fits", DailyQuota{"2024-03-10", 1}, day, 4, true, DailyQuota{"2024-03-10", 5}},
+		{"batch doesn't fit", DailyQuota{"2024-03-10", 2}, day, 4, false, DailyQuota{"2024-03-10", 2}},
+		{"batch over a new day", DailyQuota{"2024-03-09", 5}, day, 6, false, DailyQuota{"2024-03-10", 0}},
+	}
+
+	const limit = 5
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.quota
+			if got := q.use(tt.now, limit, tt.n); got != tt.want {
+				t.Errorf("use(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+			if q != tt.wantQuota {
+				t.Errorf("quota after use(%d) = %+v, want %+v", tt.n, q, tt.wantQuota)
+			}
+		})
+	}
+}
+
+func TestUntilQuotaReset(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Duration
+	}{
+		{"noon", time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), 12 * time.Hour},
+		{"midnight", time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), 24 * time.Hour},
+		{"other zone", time.Date(2024, 3, 10, 23, 30, 0, 0, time.FixedZone("UTC+2", 2*60*60)), 2*time.Hour + 30*time.Minute},
+		{"end of month", time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC), time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := untilQuotaReset(tt.now); got != tt.want {
+				t.Errorf("untilQuotaReset(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountDailyQuotaConcurrent(t *testing.T) {
+	store, cfg := newFakeUserStore(t, time.Millisecond)
+	cfg.DailyMessageLimit = 5
+	sender := &tele.User{ID: 1, Username: "alice"}
+	now := time.Now()
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int64
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := countDailyQuota(cfg, sender, 1, now)
+			if err != nil {
+				t.Errorf("countDailyQuota() error = %v", err)
+			}
+			if ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != int64(cfg.DailyMessageLimit) {
+		t.Errorf("%d messages were allowed, want %d", got, cfg.DailyMessageLimit)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.users) != 1 {
+		t.Fatalf("store has %d user records, want 1", len(store.users))
+	}
+	for _, user := range store.users {
+		if user.Quota.Count != cfg.DailyMessageLimit {
+			t.Errorf("stored count = %d, want %d", user.Quota.Count, cfg.DailyMessageLimit)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a simple token bucket rate limiter.
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64 // tokens added per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+func newTokenBucket(rate, capacity float64) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait before
+// using it.
+func (tb *tokenBucket) reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	tb.tokens = math.Min(tb.capacity, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket has refilled completely since it was last
+// used, so replacing it with a new bucket changes nothing.
+func (tb *tokenBucket) idle(now time.Time) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.tokens+now.Sub(tb.last).Seconds()*tb.rate >= tb.capacity
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (tb *tokenBucket) Wait(ctx context.Context) error {
+	delay := tb.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chatSweepInterval is how often buckets of chats that went idle are removed.
+const chatSweepInterval = time.Minute
+
+// outboundLimiter enforces Telegram's global and per-chat message limits.
+// Buckets of chats are created on their first message and removed once they
+// have refilled, so the map only holds recently active chats.
+type outboundLimiter struct {
+	global   *tokenBucket
+	chatRate float64
+
+	mu        sync.Mutex
+	chats     map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newOutboundLimiter(globalRate, chatRate float64) *outboundLimiter {
+	return &outboundLimiter{
+		global:   newTokenBucket(globalRate, globalRate),
+		chatRate: chatRate,
+		chats:    make(map[string]*tokenBucket),
+		now:      time.Now,
+	}
+}
+
+// sweep removes the buckets of idle chats, at most once per
+// chatSweepInterval. l.mu must be held.
+func (l *outboundLimiter) sweep() {
+	now := l.now()
+	if now.Sub(l.lastSweep) < chatSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for chatID, bucket := range l.chats {
+		if bucket.idle(now) {
+			delete(l.chats, chatID)
+		}
+	}
+}
+
+// Wait blocks until a message to chatID may be sent. An empty chatID only
+// applies the global limit.
+func (l *outboundLimiter) Wait(ctx context.Context, chatID string) error {
+	if chatID != "" {
+		l.mu.Lock()
+		l.sweep()
+		bucket, ok := l.chats[chatID]
+		if !ok {
+			bucket = newTokenBucket(l.chatRate, 1)
+			l.chats[chatID] = bucket
+		}
+		l.mu.Unlock()
+
+		if err := bucket.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return l.global.Wait(ctx)
+}
+
+// rateLimitedTransport delays outgoing send and edit calls to the Bot API so
+// the bot stays within Telegram's limits, and retries a call once after the
+// wait Telegram asks for when it was still too fast. It sits below telebot, so every
+// c.Send, b.Send and b.Edit goes through it. Other methods such as
+// getUpdates and sendChatAction pass through untouched.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *outboundLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+	if !isOutboundMethod(method) {
+		return t.next.RoundTrip(req)
+	}
+
+	chatID, err := requestChatID(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.limiter.Wait(req.Context(), chatID); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests || req.GetBody == nil {
+		return resp, err
+	}
+
+	// Telegram asks to wait after sending too fast. Wait as long as it says
+	// and retry once, telebot reports a FloodError if that fails too.
+	retryAfter, resp, err := floodWait(resp)
+	if err != nil || retryAfter <= 0 {
+		return resp, err
+	}
+	slog.Warn("Flood wait, retrying once", "retry_after", retryAfter, "method", method, "chat_id", chatID)
+
+	timer := time.NewTimer(retryAfter)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	retry := req.Clone(req.Context())
+	if retry.Body, err = req.GetBody(); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(retry)
+}
+
+// maxFloodWait bounds how long a send waits on a flood error before giving
+// up, so a handler isn't blocked for minutes.
+const maxFloodWait = 30 * time.Second
+
+// floodWait reads the retry_after of a 429 response. The body is buffered and
+// restored so the response can still be returned. retryAfter is 0 when the
+// wait is unknown or too long to wait for.
+func floodWait(resp *http.Response) (time.Duration, *http.Response, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return 0, resp, nil
+	}
+
+	retryAfter := time.Duration(payload.Parameters.RetryAfter) * time.Second
+	if retryAfter > maxFloodWait {
+		slog.Warn("Flood wait is too long, not retrying", "retry_after", retryAfter)
+		return 0, resp, nil
+	}
+	return retryAfter, resp, nil
+}
+
+// isOutboundMethod reports whether a Bot API method posts or changes a
+// message.
+func isOutboundMethod(method string) bool {
+	if method == "sendChatAction" {
+		return false
+	}
+	return strings.HasPrefix(method, "send") ||
+		strings.HasPrefix(method, "edit") ||
+		method == "copyMessage" ||
+		method == "forwardMessage"
+}
+
+// requestChatID extracts the chat_id parameter of a Bot API request. The body
+// is buffered and restored so the request can still be sent.
+func requestChatID(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json":
+		var payload struct {
+			ChatID json.RawMessage `json:"chat_id"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return "", nil
+		}
+		if id, err := strconv.Unquote(string(payload.ChatID)); err == nil {
+			return id, nil
+		}
+		return string(payload.ChatID), nil
+	case strings.HasPrefix(mediaType, "multipart/"):
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(int64(len(body)))
+		if err != nil {
+			return "", nil
+		}
+		defer form.RemoveAll()
+		if values := form.Value["chat_id"]; len(values) > 0 {
+			return values[0], nil
+		}
+	}
+	return "", nil
+}
+
+// botAPITimeout bounds Bot API requests such as uploads. Long polls get
+// pollTimeoutMargin on top of POLL_TIMEOUT instead if that is longer.
+const (
+	botAPITimeout     = time.Minute
+	pollTimeoutMargin = 10 * time.Second
+)
+
+// newRateLimitedClient returns the HTTP client used for the Bot API. Its
+// timeout is longer than POLL_TIMEOUT, so idle long polls end with an empty
+// answer rather than a network error.
+func newRateLimitedClient(cfg *Config) *http.Client {
+	timeout := botAPITimeout
+	if pollTimeout := cfg.PollTimeout + pollTimeoutMargin; pollTimeout > timeout {
+		timeout = pollTimeout
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &rateLimitedTransport{
+			next:    httpTransport,
+			limiter: newOutboundLimiter(cfg.OutboundRate, cfg.ChatRate),
+		},
+	}
+}
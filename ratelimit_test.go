@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a time source tests move forward by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		capacity float64
+		// gaps are the times between reservations
+		gaps []time.Duration
+		want []time.Duration
+	}{
+		{
+			name:     "burst within capacity",
+			rate:     1,
+			capacity: 3,
+			gaps:     []time.Duration{0, 0, 0},
+			want:     []time.Duration{0, 0, 0},
+		},
+		{
+			name:     "waits once empty",
+			rate:     1,
+			capacity: 1,
+			gaps:     []time.Duration{0, 0, 0},
+			want:     []time.Duration{0, time.Second, 2 * time.Second},
+		},
+		{
+			name:     "refills over time",
+			rate:     2,
+			capacity: 1,
+			gaps:     []time.Duration{0, 250 * time.Millisecond, time.Second},
+			want:     []time.Duration{0, 250 * time.Millisecond, 0},
+		},
+		{
+			name:     "refill is capped",
+			rate:     1,
+			capacity: 2,
+			gaps:     []time.Duration{0, time.Hour, 0, 0},
+			want:     []time.Duration{0, 0, 0, time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			tb := newTokenBucket(tt.rate, tt.capacity)
+			tb.last, tb.now = clock.Now(), clock.Now
+
+			for i, gap := range tt.gaps {
+				clock.Advance(gap)
+				if got := tb.reserve(); got != tt.want[i] {
+					t.Errorf("reserve %d = %v, want %v", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOutboundLimiterSweepsIdleChats(t *testing.T) {
+	clock := newFakeClock()
+	l := newOutboundLimiter(1000, 1)
+	l.now = clock.Now
+
+	newBucket := func() *tokenBucket {
+		tb := newTokenBucket(l.chatRate, 1)
+		tb.last, tb.now = clock.Now(), clock.Now
+		return tb
+	}
+	l.chats["idle"] = newBucket()
+	l.chats["idle"].reserve()
+	l.chats["busy"] = newBucket()
+
+	// The idle chat refills within a second, the busy one keeps sending
+	clock.Advance(chatSweepInterval)
+	l.chats["busy"].reserve()
+	l.chats["busy"].reserve()
+
+	l.mu.Lock()
+	l.sweep()
+	l.mu.Unlock()
+
+	if _, ok := l.chats["idle"]; ok {
+		t.Error("bucket of an idle chat was kept")
+	}
+	if _, ok := l.chats["busy"]; !ok {
+		t.Error("bucket of a busy chat was removed")
+	}
+}
+
+func TestRequestChatID(t *testing.T) {
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	writer.WriteField("chat_id", "-100123")
+	writer.WriteField("caption", "hi")
+	writer.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"json number", "application/json", `{"chat_id":42,"text":"hi"}`, "42"},
+		{"json string", "application/json", `{"chat_id":"@channel","text":"hi"}`, "@channel"},
+		{"json without chat", "application/json", `{"text":"hi"}`, ""},
+		{"invalid json", "application/json", `{`, ""},
+		{"multipart", writer.FormDataContentType(), form.String(), "-100123"},
+		{"other type", "text/plain", "chat_id=1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "https://api.telegram.org/botTOKEN/sendMessage", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+
+			got, err := requestChatID(req)
+			if err != nil {
+				t.Fatalf("requestChatID() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("requestChatID() = %q, want %q", got, tt.want)
+			}
+
+			// The body must still be readable for sending and retrying
+			for _, read := range []func() (io.ReadCloser, error){
+				func() (io.ReadCloser, error) { return req.Body, nil },
+				req.GetBody,
+			} {
+				body, err := read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data, _ := io.ReadAll(body); string(data) != tt.body {
+					t.Errorf("body after requestChatID = %q, want %q", data, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestChatIDWithoutBody(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://api.telegram.org/botTOKEN/getMe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := requestChatID(req); got != "" || err != nil {
+		t.Errorf("requestChatID() = %q, %v, want no chat", got, err)
+	}
+}
+
+func TestFloodWait(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want time.Duration
+	}{
+		{"retry after", `{"ok":false,"error_code":429,"parameters":{"retry_after":3}}`, 3 * time.Second},
+		{"at the cap", `{"ok":false,"error_code":429,"parameters":{"retry_after":30}}`, maxFloodWait},
+		{"too long", `{"ok":false,"error_code":429,"parameters":{"retry_after":600}}`, 0},
+		{"no parameters", `{"ok":false,"error_code":429}`, 0},
+		{"not json", "Too Many Requests", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusTooManyRequests, Body: io.NopCloser(strings.NewReader(tt.body))}
+			got, resp, err := floodWait(resp)
+			if err != nil {
+				t.Fatalf("floodWait() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("floodWait() = %v, want %v", got, tt.want)
+			}
+			// telebot still has to read the body to report the error
+			if data, _ := io.ReadAll(resp.Body); string(data) != tt.body {
+				t.Errorf("body after floodWait = %q, want %q", data, tt.body)
+			}
+		})
+	}
+}
+
+// roundTripFunc turns a function into an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRateLimitedTransportRetriesFloodWait(t *testing.T) {
+	var bodies []string
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Body:       io.NopCloser(strings.NewReader(`{"ok":false,"error_code":429,"parameters":{"retry_after":1}}`)),
+			}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"ok":true}`))}, nil
+	})
+	transport := &rateLimitedTransport{next: next, limiter: newOutboundLimiter(1000, 1000)}
+
+	const body = `{"chat_id":42,"text":"hi"}`
+	req, err := http.NewRequest(http.MethodPost, "https://api.telegram.org/botTOKEN/sendMessage", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("RoundTrip() status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the 1s flood wait", elapsed)
+	}
+	if len(bodies) != 2 || bodies[1] != body {
+		t.Errorf("sent bodies = %q, want the request sent twice", bodies)
+	}
+}
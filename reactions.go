@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// reactionRatings maps the emoji reactions counted as feedback to the rating
+// they stand for, the same ratings the 👍/👎 buttons store.
+var reactionRatings = map[string]string{
+	"👍": "up",
+	"👎": "down",
+}
+
+// onReaction is the endpoint reactions are dispatched to, as telebot has none
+// of its own for them.
+const onReaction = "\areaction"
+
+// reactionContext gives a reaction update the sender and chat telebot only
+// reads from messages and callbacks, so access checks and logging see them.
+type reactionContext struct {
+	tele.Context
+	reaction *tele.MessageReaction
+}
+
+func (c *reactionContext) Sender() *tele.User { return c.reaction.User }
+
+func (c *reactionContext) Chat() *tele.Chat { return c.reaction.Chat }
+
+func (c *reactionContext) Recipient() tele.Recipient { return c.reaction.Chat }
+
+// reactionFilter returns a poller filter that takes reactions out of the
+// update stream and runs the onReaction handler for them with b.Trigger.
+// telebot doesn't dispatch reaction updates itself, but Trigger goes through
+// the same middleware as every other update: dedup, access, the enable
+// switch and, as registered, the worker pool.
+func reactionFilter(b *tele.Bot) func(*tele.Update) bool {
+	return func(u *tele.Update) bool {
+		reaction := u.MessageReaction
+		if reaction == nil {
+			return true
+		}
+
+		c := &reactionContext{Context: b.NewContext(*u), reaction: reaction}
+		if err := b.Trigger(onReaction, c); err != nil {
+			updateLogger(c).Error("Error handling reaction", "err", err)
+		}
+		return false
+	}
+}
+
+// reactionHandler rates the model turn a user reacted to. Removing the
+// reaction removes the rating, and reactions without a rating are ignored.
+func reactionHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		reaction := c.Update().MessageReaction
+		rating, ok := "", len(reaction.NewReaction) == 0
+		for _, r := range reaction.NewReaction {
+			if rating, ok = reactionRatings[r.Emoji]; ok {
+				break
+			}
+		}
+		if !ok {
+			return nil
+		}
+
+		err := rateMessage(cfg, c.Sender().ID, reaction.Chat.ID, reaction.MessageID, rating)
+		if err != nil && !errors.Is(err, errTurnNotFound) {
+			updateLogger(c).Error("Error saving reaction rating", "err", err)
+		}
+		return nil
+	}
+}
+
+// ratingsHandler shows admins how the answers were rated with /ratings,
+// counting both buttons and reactions.
+func ratingsHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !isAdmin(cfg, c.Sender()) {
+			return c.Send("Only bot admins can use this command")
+		}
+
+		users, err := allUsers(cfg)
+		if err != nil {
+			updateLogger(c).Error("Error loading users", "err", err)
+			return c.Send("Error loading users")
+		}
+
+		counts := map[string]int{}
+		rated := 0
+		for _, user := range users {
+			userRated := false
+			for _, messages := range userSessions(user) {
+				for _, msg := range messages {
+					if msg.Rating != "" {
+						counts[msg.Rating]++
+						userRated = true
+					}
+				}
+			}
+			if userRated {
+				rated++
+			}
+		}
+
+		total := counts["up"] + counts["down"]
+		if total == 0 {
+			return c.Send("No answers have been rated yet")
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Ratings of stored answers from %d users:\n", rated)
+		fmt.Fprintf(&sb, "\n👍 %d (%.0f%%)", counts["up"], 100*float64(counts["up"])/float64(total))
+		fmt.Fprintf(&sb, "\n👎 %d (%.0f%%)", counts["down"], 100*float64(counts["down"])/float64(total))
+		return c.Send(sb.String())
+	}
+}
+
+// userSessions returns the turns of every session of user, the default one
+// included.
+func userSessions(user UserMessages) [][]Message {
+	sessions := [][]Message{user.Messages}
+	for _, messages := range user.Sessions {
+		sessions = append(sessions, messages)
+	}
+	return sessions
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestRateMessageMatchesChat(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	store.users[1] = &UserMessages{ID: 1, TelegramID: 7, Messages: []Message{
+		{Role: "user", Message: "private question", MessageID: 4},
+		{Role: "model", Message: "private answer", MessageID: 5, ChatID: 7},
+		{Role: "user", Message: "group question", MessageID: 4},
+		{Role: "model", Message: "group answer", MessageID: 5, ChatID: -100},
+		{Role: "user", Message: "old question", MessageID: 8},
+		{Role: "model", Message: "old answer", MessageID: 9},
+	}}
+
+	tests := []struct {
+		name    string
+		chatID  int64
+		msgID   int
+		want    string
+		wantErr error
+	}{
+		{"group answer", -100, 5, "group answer", nil},
+		{"private answer", 7, 5, "private answer", nil},
+		{"answer stored without chat in private", 7, 9, "old answer", nil},
+		{"answer stored without chat in a group", -100, 9, "", errTurnNotFound},
+		{"question", 7, 4, "", errTurnNotFound},
+		{"unknown message", 7, 42, "", errTurnNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range store.users[1].Messages {
+				store.users[1].Messages[i].Rating = ""
+			}
+
+			err := rateMessage(cfg, 7, tt.chatID, tt.msgID, "up")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("rateMessage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var rated []string
+			for _, msg := range store.users[1].Messages {
+				if msg.Rating == "up" {
+					rated = append(rated, msg.Message)
+				}
+			}
+			if tt.want == "" && len(rated) > 0 || tt.want != "" && (len(rated) != 1 || rated[0] != tt.want) {
+				t.Errorf("rated turns = %q, want %q", rated, tt.want)
+			}
+		})
+	}
+}
+
+func TestReactionFilter(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	cfg.BlockedIDs = []int64{8}
+	for id, telegramID := range []int64{7, 8} {
+		store.users[int64(id+1)] = &UserMessages{ID: int64(id + 1), TelegramID: telegramID, Messages: []Message{
+			{Role: "user", Message: "question", MessageID: 4},
+			{Role: "model", Message: "answer", MessageID: 5, ChatID: telegramID},
+		}}
+	}
+
+	b, _ := newTestBot(t)
+	b.Use(senderMiddleware, accessMiddleware(cfg))
+	b.Handle(onReaction, reactionHandler(cfg))
+	filter := reactionFilter(b)
+
+	react := func(userID int64, emoji ...string) bool {
+		reaction := &tele.MessageReaction{Chat: &tele.Chat{ID: userID}, User: &tele.User{ID: userID}, MessageID: 5}
+		for _, e := range emoji {
+			reaction.NewReaction = append(reaction.NewReaction, tele.Reaction{Type: "emoji", Emoji: e})
+		}
+		return filter(&tele.Update{ID: 1, MessageReaction: reaction})
+	}
+	rating := func(recordID int64) string {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.users[recordID].Messages[1].Rating
+	}
+
+	if !filter(&tele.Update{Message: &tele.Message{Text: "hi"}}) {
+		t.Error("filter dropped a message update")
+	}
+
+	if react(7, "👎") {
+		t.Error("filter passed a reaction on to telebot")
+	}
+	if got := rating(1); got != "down" {
+		t.Errorf("rating after 👎 = %q, want down", got)
+	}
+	react(7, "🔥")
+	if got := rating(1); got != "down" {
+		t.Errorf("rating after an unrelated reaction = %q, want it kept", got)
+	}
+	react(7)
+	if got := rating(1); got != "" {
+		t.Errorf("rating after removing the reaction = %q, want none", got)
+	}
+
+	// Reactions go through the access check like other updates
+	react(8, "👍")
+	if got := rating(2); got != "" {
+		t.Errorf("blocked user's reaction was saved as %q", got)
+	}
+}
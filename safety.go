@@ -0,0 +1,38 @@
+package main
+
+// Harm categories of the Gemini safety settings.
+const (
+	harmHarassment       = "HARM_CATEGORY_HARASSMENT"
+	harmHateSpeech       = "HARM_CATEGORY_HATE_SPEECH"
+	harmSexuallyExplicit = "HARM_CATEGORY_SEXUALLY_EXPLICIT"
+	harmDangerousContent = "HARM_CATEGORY_DANGEROUS_CONTENT"
+	harmCivicIntegrity   = "HARM_CATEGORY_CIVIC_INTEGRITY"
+)
+
+// defaultSafetyProfile is used for models without a profile of their own.
+const defaultSafetyProfile = "default"
+
+// safetyProfiles lists the harm categories each model accepts. Models reject
+// requests with categories they don't know, so only these are sent.
+var safetyProfiles = map[string][]string{
+	defaultSafetyProfile: {harmHarassment, harmHateSpeech, harmSexuallyExplicit, harmDangerousContent},
+	"gemini-2.0-flash":   {harmHarassment, harmHateSpeech, harmSexuallyExplicit, harmDangerousContent, harmCivicIntegrity},
+	"gemini-2.5-flash":   {harmHarassment, harmHateSpeech, harmSexuallyExplicit, harmDangerousContent, harmCivicIntegrity},
+	"gemini-2.5-pro":     {harmHarassment, harmHateSpeech, harmSexuallyExplicit, harmDangerousContent, harmCivicIntegrity},
+	imageModel:           {harmHarassment, harmHateSpeech, harmSexuallyExplicit, harmDangerousContent},
+}
+
+// safetySettings returns the safety settings sent to model, turning blocking
+// off for every category of its profile.
+func safetySettings(model string) []Safety {
+	categories, ok := safetyProfiles[model]
+	if !ok {
+		categories = safetyProfiles[defaultSafetyProfile]
+	}
+
+	settings := make([]Safety, 0, len(categories))
+	for _, category := range categories {
+		settings = append(settings, Safety{Category: category, Threshold: "BLOCK_NONE"})
+	}
+	return settings
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSafetySettings(t *testing.T) {
+	withCivic := []string{harmHarassment, harmHateSpeech, harmSexuallyExplicit, harmDangerousContent, harmCivicIntegrity}
+	withoutCivic := []string{harmHarassment, harmHateSpeech, harmSexuallyExplicit, harmDangerousContent}
+
+	tests := []struct {
+		model string
+		want  []string
+	}{
+		{"gemini-2.0-flash", withCivic},
+		{"gemini-2.5-pro", withCivic},
+		{imageModel, withoutCivic},
+		{"gemini-1.5-flash", withoutCivic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			var categories []string
+			for _, setting := range safetySettings(tt.model) {
+				categories = append(categories, setting.Category)
+				if setting.Threshold != "BLOCK_NONE" {
+					t.Errorf("%s threshold = %q, want BLOCK_NONE", setting.Category, setting.Threshold)
+				}
+			}
+			if !slices.Equal(categories, tt.want) {
+				t.Errorf("safetySettings(%q) categories = %q, want %q", tt.model, categories, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafetySettingsFollowFallback(t *testing.T) {
+	const fallback = "gemini-1.5-flash"
+	sent := make(map[string]int)
+	cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		model, _, _ := strings.Cut(path.Base(r.URL.Path), ":")
+		var req GeminiRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		sent[model] = len(req.SafetySettings)
+
+		if model == textModel {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, geminiText("hi"))
+	})
+	cfg.FallbackModel = fallback
+
+	if _, err := generateReply(cfg, nil, "hello", ReplyOptions{Model: textModel}); err != nil {
+		t.Fatalf("generateReply() error = %v", err)
+	}
+	if sent[textModel] != len(safetyProfiles[textModel]) || sent[fallback] != len(safetyProfiles[defaultSafetyProfile]) {
+		t.Errorf("sent %d safety settings to %s and %d to %s, want each model's own", sent[textModel], textModel, sent[fallback], fallback)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// saveJob is an exchange waiting to be written to the user's history.
+type saveJob struct {
+	telegramID int64
+	sender     *tele.User
+	userTurn   Message
+	modelTurn  Message
+}
+
+// saveQueue writes exchanges to the store from a single goroutine, so
+// handlers don't wait for the store and pending saves can be flushed on
+// shutdown. It counts the queued saves of each user, so reading the history
+// can wait until the previous exchanges are written.
+type saveQueue struct {
+	cfg    *Config
+	mu     sync.Mutex
+	closed bool
+	jobs   chan saveJob
+	done   chan struct{}
+
+	// pendingMu is separate from mu, which Save holds while the queue is
+	// full and the writer has to count saves down
+	pendingMu sync.Mutex
+	pending   map[int64]int
+	written   *sync.Cond
+}
+
+// historySaves is the queue used by handlers. It is nil, meaning saves are
+// written right away, until main starts it.
+var historySaves *saveQueue
+
+func newSaveQueue(cfg *Config, size int) *saveQueue {
+	q := &saveQueue{
+		cfg:     cfg,
+		jobs:    make(chan saveJob, size),
+		done:    make(chan struct{}),
+		pending: make(map[int64]int),
+	}
+	q.written = sync.NewCond(&q.pendingMu)
+	go q.run()
+	return q
+}
+
+func (q *saveQueue) run() {
+	defer close(q.done)
+	for job := range q.jobs {
+		q.write(job)
+		q.finish(job.telegramID)
+	}
+}
+
+// finish counts down a written save of the user and wakes up Wait.
+func (q *saveQueue) finish(telegramID int64) {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+
+	q.pending[telegramID]--
+	if q.pending[telegramID] <= 0 {
+		delete(q.pending, telegramID)
+		q.written.Broadcast()
+	}
+}
+
+// Wait blocks until the queued saves of the user are written, so history
+// read afterwards includes them.
+func (q *saveQueue) Wait(telegramID int64) {
+	if q == nil {
+		return
+	}
+
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	for q.pending[telegramID] > 0 {
+		q.written.Wait()
+	}
+}
+
+func (q *saveQueue) write(job saveJob) {
+	err := saveMessage(q.cfg, job.telegramID, job.sender, job.userTurn, job.modelTurn)
+	if errors.Is(err, errStoreUnavailable) {
+		slog.Error("Error saving messages, keeping them in memory", "user_id", job.telegramID, "err", err)
+		offlineHistory.Append(job.telegramID, job.userTurn, job.modelTurn)
+		return
+	}
+	if err != nil {
+		slog.Error("Error saving messages", "user_id", job.telegramID, "err", err)
+	}
+}
+
+// Save queues an exchange for saving. Without a queue, or once it is closed,
+// the exchange is saved right away.
+func (q *saveQueue) Save(cfg *Config, telegramID int64, sender *tele.User, userTurn, modelTurn Message) {
+	job := saveJob{telegramID: telegramID, sender: sender, userTurn: userTurn, modelTurn: modelTurn}
+	if q == nil {
+		(&saveQueue{cfg: cfg}).write(job)
+		return
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.write(job)
+		return
+	}
+	q.pendingMu.Lock()
+	q.pending[telegramID]++
+	q.pendingMu.Unlock()
+	// Sending under the lock keeps Close from closing the channel meanwhile
+	q.jobs <- job
+	q.mu.Unlock()
+}
+
+// Close stops accepting new jobs and waits until all queued saves are
+// written.
+func (q *saveQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+	slog.Info("All pending history saves were written")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeUserStore serves the users collection of Mokky from memory. Every
+// write takes delay, so saves pile up in the queue.
+type fakeUserStore struct {
+	mu    sync.Mutex
+	users map[int64]*UserMessages
+	delay time.Duration
+	// reads counts the lookups, failWrites fails every write
+	reads      int
+	failWrites bool
+	// writes records the method of every write and the fields it sent
+	writes []storeWrite
+}
+
+// storeWrite is a recorded write to the users collection.
+type storeWrite struct {
+	method string
+	fields []string
+}
+
+func newFakeUserStore(t *testing.T, delay time.Duration) (*fakeUserStore, *Config) {
+	store := &fakeUserStore{users: make(map[int64]*UserMessages), delay: delay}
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+	return store, &Config{MokkyURL: server.URL + "/", StoreTimeout: 5 * time.Second}
+}
+
+func (s *fakeUserStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method != http.MethodGet && s.failWrites:
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	case r.Method == http.MethodGet && r.URL.Path == "/users":
+		s.reads++
+		telegramID, _ := strconv.ParseInt(r.URL.Query().Get("telegramId"), 10, 64)
+		users := []UserMessages{}
+		for _, user := range s.users {
+			if user.TelegramID == telegramID {
+				users = append(users, *user)
+			}
+		}
+		json.NewEncoder(w).Encode(users)
+	case r.Method == http.MethodPost && r.URL.Path == "/users":
+		time.Sleep(s.delay)
+		var user UserMessages
+		json.NewDecoder(r.Body).Decode(&user)
+		s.writes = append(s.writes, storeWrite{method: r.Method})
+		user.ID = int64(len(s.users) + 1)
+		s.users[user.ID] = &user
+		json.NewEncoder(w).Encode(user)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/users/"):
+		time.Sleep(s.delay)
+		id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/users/"), 10, 64)
+		user, ok := s.users[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// Fields in the body replace the stored ones, like Mokky does
+		var fields map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&fields)
+		s.writes = append(s.writes, storeWrite{method: r.Method, fields: slices.Sorted(maps.Keys(fields))})
+		var record map[string]json.RawMessage
+		stored, _ := json.Marshal(user)
+		json.Unmarshal(stored, &record)
+		for key, value := range fields {
+			record[key] = value
+		}
+		merged, _ := json.Marshal(record)
+		var updated UserMessages
+		json.Unmarshal(merged, &updated)
+		s.users[id] = &updated
+		json.NewEncoder(w).Encode(updated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// messages returns the stored turns of the user.
+func (s *fakeUserStore) messages(telegramID int64) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.TelegramID == telegramID {
+			return user.Messages
+		}
+	}
+	return nil
+}
+
+func exchange(i int) (Message, Message) {
+	return Message{Role: "user", Message: fmt.Sprintf("question %d", i)},
+		Message{Role: "model", Message: fmt.Sprintf("answer %d", i)}
+}
+
+func TestSaveQueueFlushesOnClose(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 5*time.Millisecond)
+	q := newSaveQueue(cfg, 10)
+
+	const saves = 5
+	sender := &tele.User{ID: 1, Username: "alice"}
+	for i := 0; i < saves; i++ {
+		userTurn, modelTurn := exchange(i)
+		q.Save(cfg, sender.ID, sender, userTurn, modelTurn)
+	}
+	q.Close()
+
+	got := store.messages(sender.ID)
+	if len(got) != 2*saves {
+		t.Fatalf("stored %d turns after Close, want %d", len(got), 2*saves)
+	}
+	for i := 0; i < saves; i++ {
+		if want := fmt.Sprintf("question %d", i); got[2*i].Message != want {
+			t.Errorf("turn %d = %q, want %q", 2*i+1, got[2*i].Message, want)
+		}
+	}
+
+	// Saves after Close are written right away
+	userTurn, modelTurn := exchange(saves)
+	q.Save(cfg, sender.ID, sender, userTurn, modelTurn)
+	if got := len(store.messages(sender.ID)); got != 2*saves+2 {
+		t.Errorf("stored %d turns after a save past Close, want %d", got, 2*saves+2)
+	}
+}
+
+func TestSaveQueueWait(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 5*time.Millisecond)
+	q := newSaveQueue(cfg, 10)
+	defer q.Close()
+
+	sender := &tele.User{ID: 1, Username: "alice"}
+	for i := 0; i < 3; i++ {
+		userTurn, modelTurn := exchange(i)
+		q.Save(cfg, sender.ID, sender, userTurn, modelTurn)
+	}
+	q.Wait(sender.ID)
+
+	if got := len(store.messages(sender.ID)); got != 6 {
+		t.Errorf("stored %d turns after Wait, want 6", got)
+	}
+	// Users without queued saves don't wait
+	q.Wait(2)
+}
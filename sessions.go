@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// defaultSessionName is how the default session is shown to users.
+const defaultSessionName = "default"
+
+var sessionNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// switchSession makes name the active session of the user, creating the
+// user's record if needed. The default session is stored as an empty name.
+func switchSession(cfg *Config, sender *tele.User, name string) error {
+	if name == defaultSessionName {
+		name = ""
+	}
+
+	defer userLocks.Lock(sender.ID)()
+	user, err := findUser(cfg, sender.ID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return createUser(cfg, &UserMessages{
+			TelegramID:    sender.ID,
+			Username:      recordUsername(sender),
+			Messages:      []Message{},
+			ActiveSession: name,
+		})
+	}
+
+	user.ActiveSession = name
+	if name != "" {
+		if user.Sessions == nil {
+			user.Sessions = make(map[string][]Message)
+		}
+		if _, ok := user.Sessions[name]; !ok {
+			user.Sessions[name] = []Message{}
+		}
+	}
+	return patchUserFields(cfg, user, map[string]interface{}{
+		"activeSession": user.ActiveSession,
+		"sessions":      user.Sessions,
+	})
+}
+
+// sessionHandler switches the active conversation with /session <name>.
+func sessionHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		name := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		if name == "" {
+			return c.Send("Usage: /session <name> to switch conversations, for example /session work. Use /session default to go back and /sessions to list them")
+		}
+		if !sessionNamePattern.MatchString(name) {
+			return c.Send("Session names can only contain up to 32 lowercase letters, digits, - and _")
+		}
+
+		if err := switchSession(cfg, c.Sender(), name); err != nil {
+			updateLogger(c).Error("Error switching session", "err", err)
+			return c.Send("Error switching session")
+		}
+		return c.Send(fmt.Sprintf("Switched to session %q", name))
+	}
+}
+
+// sessionsHandler lists the user's sessions and marks the active one.
+func sessionsHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		user, err := findUser(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error listing sessions", "err", err)
+			return c.Send("Error listing sessions")
+		}
+		if user == nil {
+			user = &UserMessages{}
+		}
+
+		names := make([]string, 0, len(user.Sessions))
+		for name := range user.Sessions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var sb strings.Builder
+		sb.WriteString("Your sessions:\n")
+		writeSession := func(name, stored string, count int) {
+			marker := "  "
+			if stored == user.ActiveSession {
+				marker = "> "
+			}
+			fmt.Fprintf(&sb, "%s%s (%d messages)\n", marker, name, count)
+		}
+
+		writeSession(defaultSessionName, "", len(user.Messages))
+		for _, name := range names {
+			writeSession(name, name, len(user.Sessions[name]))
+		}
+		return c.Send(sb.String())
+	}
+}
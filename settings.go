@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// UserSettings holds per-user preferences stored in the user record.
+type UserSettings struct {
+	// Language pins the response language. Empty means auto-detect.
+	Language string `json:"language,omitempty"`
+	// LongFormat is how answers longer than a message are delivered, "chunk"
+	// (default) or "file"
+	LongFormat string `json:"longFormat,omitempty"`
+	// Stream overrides the operator's STREAMING default when set
+	Stream *bool `json:"stream,omitempty"`
+	// Quote overrides whether answers quote the message they reply to
+	Quote *bool `json:"quote,omitempty"`
+	// Preset is the name of the answer style chosen with /preset
+	Preset string `json:"preset,omitempty"`
+	// MaxOutputTokens caps the length of answers, 0 means the model default
+	MaxOutputTokens int `json:"maxOutputTokens,omitempty"`
+	// ThinkingBudget overrides the model's thinking budget, nil means the
+	// model default
+	ThinkingBudget *int `json:"thinkingBudget,omitempty"`
+	// ShowThoughts shows the model's thoughts above its answers
+	ShowThoughts bool `json:"showThoughts,omitempty"`
+	// Candidates is how many answers are generated to choose from, 0 or 1
+	// means a single one
+	Candidates int `json:"candidates,omitempty"`
+	// RawOutput drops the plain text restriction from the system instruction
+	RawOutput bool `json:"rawOutput,omitempty"`
+	// StopSequences end answers where one of them appears
+	StopSequences []string `json:"stopSequences,omitempty"`
+	// APIKey is the user's own Gemini API key, encrypted with API_KEY_SECRET
+	APIKey string `json:"apiKey,omitempty"`
+	// Debug shows admins how confident the model was in its answers
+	Debug bool `json:"debug,omitempty"`
+	// Model is the text model chosen with /model, empty means the default
+	Model string `json:"model,omitempty"`
+}
+
+// settingsCache keeps the settings of recent users so they aren't fetched
+// from the store on every message. Changes are written through it. main
+// sizes it from the configuration.
+var settingsCache = newLRUCache[int64, UserSettings](0, 0)
+
+// getUserSettings returns the stored settings of a user, or the defaults if
+// the user has no record yet. The cache is filled under the user's lock, so a
+// record read before updateUserSettings wrote new settings can't replace
+// them in the cache afterwards. In STATELESS mode settings are only kept in
+// memory.
+func getUserSettings(cfg *Config, telegramID int64) (UserSettings, error) {
+	if cfg.Stateless {
+		return statelessSettings.Get(telegramID), nil
+	}
+	if settings, ok := settingsCache.Get(telegramID); ok {
+		return settings, nil
+	}
+
+	defer userLocks.Lock(telegramID)()
+	// Another message may have filled the cache while this one waited
+	if settings, ok := settingsCache.Get(telegramID); ok {
+		return settings, nil
+	}
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return UserSettings{}, err
+	}
+	var settings UserSettings
+	if user != nil {
+		settings = user.Settings
+	}
+	settingsCache.Set(telegramID, settings)
+	return settings, nil
+}
+
+// updateUserSettings applies update to the user's settings and stores them,
+// creating the user's record if needed.
+func updateUserSettings(cfg *Config, sender *tele.User, update func(*UserSettings)) error {
+	if cfg.Stateless {
+		statelessSettings.Update(sender.ID, update)
+		return nil
+	}
+
+	defer userLocks.Lock(sender.ID)()
+	user, err := findUser(cfg, sender.ID)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		user = &UserMessages{
+			TelegramID: sender.ID,
+			Username:   recordUsername(sender),
+			Messages:   []Message{},
+		}
+		update(&user.Settings)
+		if err := createUser(cfg, user); err != nil {
+			settingsCache.Delete(sender.ID)
+			return err
+		}
+		settingsCache.Set(sender.ID, user.Settings)
+		return nil
+	}
+
+	update(&user.Settings)
+	// Only the settings are written so a history save isn't overwritten
+	if err := patchUserFields(cfg, user, map[string]interface{}{"settings": user.Settings}); err != nil {
+		// The store may or may not have the change, read it again next time
+		settingsCache.Delete(sender.ID)
+		return fmt.Errorf("error saving settings: %v", err)
+	}
+	settingsCache.Set(sender.ID, user.Settings)
+	return nil
+}
+
+// replyTarget returns the message answers should quote, or nil. By default
+// answers quote their prompt in groups, where several conversations can be
+// interleaved, but not in private chats.
+func replyTarget(c tele.Context, settings UserSettings) *tele.Message {
+	quote := c.Chat().Type != tele.ChatPrivate
+	if settings.Quote != nil {
+		quote = *settings.Quote
+	}
+	if !quote {
+		return nil
+	}
+	return c.Message()
+}
+
+// quoteHandler lets users choose with /quote on|off whether answers quote
+// the message they reply to.
+func quoteHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		var quote bool
+		switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+		case "on":
+			quote = true
+		case "off":
+			quote = false
+		default:
+			return c.Send("Usage: /quote on to have answers reply to your message, /quote off to send them as standalone messages")
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.Quote = &quote }); err != nil {
+			updateLogger(c).Error("Error saving quote setting", "err", err)
+			return c.Send("Error saving your preference")
+		}
+
+		if quote {
+			return c.Send("Answers will reply to your messages")
+		}
+		return c.Send("Answers will be sent as standalone messages")
+	}
+}
+
+// replyOptions collects the chat and user settings that shape a text answer
+// to prompt.
+func replyOptions(cfg *Config, c tele.Context, settings UserSettings, prompt string) ReplyOptions {
+	language := responseLanguage(settings, prompt)
+	persona := chatPersona(cfg, c.Chat().ID)
+	if rendered, err := renderPersona(persona, personaVars(c, language)); err != nil {
+		updateLogger(c).Error("Error rendering persona", "err", err)
+	} else {
+		persona = rendered
+	}
+
+	return ReplyOptions{
+		Persona:         persona,
+		Language:        language,
+		Style:           responsePresets[settings.Preset],
+		MaxOutputTokens: settings.MaxOutputTokens,
+		ThinkingBudget:  settings.ThinkingBudget,
+		ShowThoughts:    settings.ShowThoughts,
+		CandidateCount:  settings.Candidates,
+		RawOutput:       settings.RawOutput,
+		StopSequences:   settings.StopSequences,
+		Logprobs:        settings.Debug && isAdmin(cfg, c.Sender()),
+		MaxContextTurns: cfg.MaxContextTurns,
+		Model:           userModel(cfg, settings),
+		PinnedMessage:   pinnedContext(cfg, c),
+	}
+}
+
+// rawOutputHandler lets users allow full formatting and emoji in answers with
+// /rawoutput on, or go back to plain text with /rawoutput off.
+func rawOutputHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		var raw bool
+		switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+		case "on":
+			raw = true
+		case "off":
+			raw = false
+		default:
+			return c.Send("Usage: /rawoutput on to allow any formatting and emoji in answers, /rawoutput off for plain text")
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.RawOutput = raw }); err != nil {
+			updateLogger(c).Error("Error saving raw output setting", "err", err)
+			return c.Send("Error saving your preference")
+		}
+
+		if raw {
+			return c.Send("Answers may now use any formatting and emoji")
+		}
+		return c.Send("Answers will be plain text again")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// useSettingsCache replaces the settings cache for the test.
+func useSettingsCache(t *testing.T) {
+	old := settingsCache
+	settingsCache = newLRUCache[int64, UserSettings](10, time.Hour)
+	t.Cleanup(func() { settingsCache = old })
+}
+
+func TestSettingsCacheCoherence(t *testing.T) {
+	useSettingsCache(t)
+	store, cfg := newFakeUserStore(t, 0)
+	sender := &tele.User{ID: 1, Username: "alice"}
+
+	if err := updateUserSettings(cfg, sender, func(s *UserSettings) { s.Language = "German" }); err != nil {
+		t.Fatalf("updateUserSettings() error = %v", err)
+	}
+	settings, err := getUserSettings(cfg, sender.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Language != "German" {
+		t.Errorf("Language = %q, want German", settings.Language)
+	}
+
+	// An update is visible right away, without reading the store
+	if err := updateUserSettings(cfg, sender, func(s *UserSettings) { s.Language = "French" }); err != nil {
+		t.Fatalf("updateUserSettings() error = %v", err)
+	}
+	store.mu.Lock()
+	reads := store.reads
+	store.mu.Unlock()
+	if settings, _ := getUserSettings(cfg, sender.ID); settings.Language != "French" {
+		t.Errorf("Language after update = %q, want French", settings.Language)
+	}
+	store.mu.Lock()
+	if store.reads != reads {
+		t.Errorf("reading cached settings made %d store reads, want none", store.reads-reads)
+	}
+	// A failed write drops the entry, so the stored settings are read again
+	store.failWrites = true
+	store.mu.Unlock()
+
+	if err := updateUserSettings(cfg, sender, func(s *UserSettings) { s.Language = "Spanish" }); err == nil {
+		t.Fatal("updateUserSettings() with a failing store error = nil")
+	}
+	if _, ok := settingsCache.Get(sender.ID); ok {
+		t.Error("settings are still cached after a failed write")
+	}
+	if settings, _ := getUserSettings(cfg, sender.ID); settings.Language != "French" {
+		t.Errorf("Language after a failed write = %q, want the stored French", settings.Language)
+	}
+}
+
+func TestUpdateUserSettingsWrites(t *testing.T) {
+	useSettingsCache(t)
+	store, cfg := newFakeUserStore(t, 0)
+	sender := &tele.User{ID: 1, Username: "alice"}
+
+	// The first update creates the record
+	if err := updateUserSettings(cfg, sender, func(s *UserSettings) { s.Language = "German" }); err != nil {
+		t.Fatalf("updateUserSettings() error = %v", err)
+	}
+	if len(store.writes) != 1 || store.writes[0].method != http.MethodPost {
+		t.Fatalf("writes = %+v, want one POST", store.writes)
+	}
+
+	// Turns saved meanwhile are left alone by later updates
+	userTurn, modelTurn := exchange(0)
+	store.users[1].Messages = []Message{userTurn, modelTurn}
+	if err := updateUserSettings(cfg, sender, func(s *UserSettings) { s.Language = "French" }); err != nil {
+		t.Fatalf("updateUserSettings() error = %v", err)
+	}
+	want := storeWrite{method: http.MethodPatch, fields: []string{"settings"}}
+	if len(store.writes) != 2 || !reflect.DeepEqual(store.writes[1], want) {
+		t.Errorf("writes = %+v, want a PATCH of only the settings", store.writes)
+	}
+	if got := store.users[1]; got.Settings.Language != "French" || len(got.Messages) != 2 {
+		t.Errorf("stored record = %+v, want French settings and both turns", got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "sync"
+
+// memoryRecords keeps per-user or per-chat records in memory. In STATELESS
+// mode it replaces the store for settings and daily quotas, which then only
+// last until the bot restarts.
+type memoryRecords[K comparable, V any] struct {
+	mu      sync.Mutex
+	records map[K]V
+}
+
+func newMemoryRecords[K comparable, V any]() *memoryRecords[K, V] {
+	return &memoryRecords[K, V]{records: make(map[K]V)}
+}
+
+// Get returns the record of key, or the zero value if there is none.
+func (m *memoryRecords[K, V]) Get(key K) V {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.records[key]
+}
+
+// Update applies update to the record of key and returns the result.
+func (m *memoryRecords[K, V]) Update(key K, update func(*V)) V {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record := m.records[key]
+	update(&record)
+	m.records[key] = record
+	return record
+}
+
+// The records kept instead of the store in STATELESS mode.
+var (
+	statelessSettings     = newMemoryRecords[int64, UserSettings]()
+	statelessChatSettings = newMemoryRecords[int64, ChatSettings]()
+	statelessQuotas       = newMemoryRecords[int64, DailyQuota]()
+)
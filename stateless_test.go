@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestStatelessTextPromptSkipsStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("store request %s %s in STATELESS mode", r.Method, r.URL)
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := &Config{
+		MokkyURL:             server.URL + "/",
+		StoreTimeout:         time.Second,
+		Stateless:            true,
+		MockGemini:           true,
+		DailyMessageLimit:    2,
+		Greeting:             "Welcome!",
+		MaxHistoryMessages:   10,
+		ContextSummaryTokens: 1,
+	}
+	historySaves = newSaveQueue(cfg, 10)
+	t.Cleanup(func() { historySaves.Close() })
+	b, api := newTestBot(t)
+	handle := textPromptHandler(cfg, newLRUCache[promptKey, string](10, time.Minute))
+
+	sender := &tele.User{ID: 1, Username: "alice"}
+	if err := updateUserSettings(cfg, sender, func(s *UserSettings) { s.Language = "German" }); err != nil {
+		t.Fatalf("updateUserSettings() error = %v", err)
+	}
+	if err := saveChatPersona(cfg, -100, "a pirate"); err != nil {
+		t.Fatalf("saveChatPersona() error = %v", err)
+	}
+
+	for i, text := range []string{"hello", "how are you", "still there?"} {
+		c := b.NewContext(tele.Update{Message: &tele.Message{
+			ID:     i + 1,
+			Sender: sender,
+			Chat:   &tele.Chat{ID: -100, Type: tele.ChatGroup},
+			Text:   text,
+		}})
+		if err := handle(c, text); err != nil {
+			t.Fatalf("handling %q: %v", text, err)
+		}
+	}
+	historySaves.Wait(sender.ID)
+
+	texts := api.Texts()
+	if len(texts) != 3 {
+		t.Fatalf("replies = %q, want two answers and the quota notice", texts)
+	}
+	if !strings.Contains(texts[2], "You've used all 2 messages for today") {
+		t.Errorf("third reply = %q, want the quota notice", texts[2])
+	}
+	for _, text := range texts {
+		if text == cfg.Greeting {
+			t.Error("a greeting was sent in STATELESS mode")
+		}
+	}
+	if settings, _ := getUserSettings(cfg, sender.ID); settings.Language != "German" {
+		t.Errorf("language = %q, want the setting kept in memory", settings.Language)
+	}
+	if got := chatPersona(cfg, -100); got != "a pirate" {
+		t.Errorf("chatPersona() = %q, want the persona kept in memory", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Limits for /stop. Gemini accepts at most five stop sequences per request.
+const (
+	maxStopSequences      = 5
+	maxStopSequenceLength = 64
+)
+
+const stopUsage = "Usage: /stop <sequence> to end answers where the sequence appears, /stop clear to remove all. Write \\n for a line break"
+
+// stopHandler manages the user's stop sequences. /stop <sequence> adds one,
+// /stop clear removes all and /stop alone lists them.
+func stopHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		payload := strings.TrimSpace(c.Message().Payload)
+
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+
+		if payload == "" {
+			if len(settings.StopSequences) == 0 {
+				return c.Send("You have no stop sequences\n\n" + stopUsage)
+			}
+			var sb strings.Builder
+			sb.WriteString("Your stop sequences:\n")
+			for _, seq := range settings.StopSequences {
+				sb.WriteString(strconv.Quote(seq) + "\n")
+			}
+			sb.WriteString("\n" + stopUsage)
+			return c.Send(sb.String())
+		}
+
+		if strings.EqualFold(payload, "clear") {
+			if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.StopSequences = nil }); err != nil {
+				updateLogger(c).Error("Error saving stop sequences", "err", err)
+				return c.Send("Error saving your preference")
+			}
+			return c.Send("Removed all stop sequences")
+		}
+
+		seq := strings.ReplaceAll(payload, `\n`, "\n")
+		if len([]rune(seq)) > maxStopSequenceLength {
+			return c.Send(fmt.Sprintf("Stop sequences can be at most %d characters long", maxStopSequenceLength))
+		}
+		for _, existing := range settings.StopSequences {
+			if existing == seq {
+				return c.Send("You already have that stop sequence")
+			}
+		}
+		if len(settings.StopSequences) >= maxStopSequences {
+			return c.Send(fmt.Sprintf("You can have at most %d stop sequences. Use /stop clear to start over", maxStopSequences))
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.StopSequences = append(s.StopSequences, seq) }); err != nil {
+			updateLogger(c).Error("Error saving stop sequences", "err", err)
+			return c.Send("Error saving your preference")
+		}
+		return c.Send("Answers will now stop at " + strconv.Quote(seq))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestStopHandler(t *testing.T) {
+	useSettingsCache(t)
+	store, cfg := newFakeUserStore(t, 0)
+	b, api := newTestBot(t)
+	sender := &tele.User{ID: 1, Username: "alice"}
+	stop := func(payload string) string {
+		t.Helper()
+		c := b.NewContext(tele.Update{Message: &tele.Message{
+			Sender:  sender,
+			Chat:    &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+			Text:    "/stop " + payload,
+			Payload: payload,
+		}})
+		if err := stopHandler(cfg)(c); err != nil {
+			t.Fatalf("/stop %s: %v", payload, err)
+		}
+		texts := api.Texts()
+		return texts[len(texts)-1]
+	}
+	stored := func() []string {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		for _, user := range store.users {
+			return user.Settings.StopSequences
+		}
+		return nil
+	}
+
+	steps := []struct {
+		payload    string
+		wantReply  string
+		wantStored []string
+	}{
+		{"", "You have no stop sequences", nil},
+		{"END", `Answers will now stop at "END"`, []string{"END"}},
+		{"END", "You already have that stop sequence", []string{"END"}},
+		{`\n\n`, `Answers will now stop at "\n\n"`, []string{"END", "\n\n"}},
+		{strings.Repeat("x", maxStopSequenceLength+1), "Stop sequences can be at most 64 characters long", []string{"END", "\n\n"}},
+		{"a", `Answers will now stop at "a"`, []string{"END", "\n\n", "a"}},
+		{"b", `Answers will now stop at "b"`, []string{"END", "\n\n", "a", "b"}},
+		{"c", `Answers will now stop at "c"`, []string{"END", "\n\n", "a", "b", "c"}},
+		{"d", "You can have at most 5 stop sequences", []string{"END", "\n\n", "a", "b", "c"}},
+		{"", `Your stop sequences:` + "\n" + `"END"`, []string{"END", "\n\n", "a", "b", "c"}},
+		{"clear", "Removed all stop sequences", nil},
+	}
+
+	for _, step := range steps {
+		if reply := stop(step.payload); !strings.HasPrefix(reply, step.wantReply) {
+			t.Errorf("/stop %s replied %q, want %q", step.payload, reply, step.wantReply)
+		}
+		if got := stored(); !slices.Equal(got, step.wantStored) {
+			t.Errorf("after /stop %s stored %q, want %q", step.payload, got, step.wantStored)
+		}
+	}
+}
+
+func TestStopSequencesInRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings UserSettings
+		want     []string
+	}{
+		{"none", UserSettings{}, nil},
+		{"set", UserSettings{StopSequences: []string{"END", "\n\n"}}, []string{"END", "\n\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent struct {
+				GenerationConfig *struct {
+					StopSequences []string `json:"stopSequences"`
+				} `json:"generationConfig"`
+			}
+			cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&sent)
+				fmt.Fprint(w, geminiText("hi"))
+			})
+			b, _ := newTestBot(t)
+			c := b.NewContext(tele.Update{Message: &tele.Message{
+				Sender: &tele.User{ID: 1},
+				Chat:   &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+				Text:   "hello",
+			}})
+
+			opts := replyOptions(cfg, c, tt.settings, "hello")
+			if _, err := generateReply(cfg, nil, "hello", opts); err != nil {
+				t.Fatalf("generateReply() error = %v", err)
+			}
+			var got []string
+			if sent.GenerationConfig != nil {
+				got = sent.GenerationConfig.StopSequences
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("generationConfig.stopSequences = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// errStoreNotFound is returned when the requested record doesn't exist.
+	errStoreNotFound = errors.New("record not found in store")
+	// errStoreUnavailable is returned when the store couldn't be reached or
+	// kept failing with server errors, so the call may succeed later.
+	errStoreUnavailable = errors.New("store is unavailable")
+)
+
+// storeRetryDelay is the wait before the first retry, doubled on every
+// following one.
+const storeRetryDelay = 200 * time.Millisecond
+
+// storeDown is set while the store is unreachable. Requests are then tried
+// once without retries, so handlers fall back quickly, and the first one that
+// succeeds clears it.
+var storeDown atomic.Bool
+
+// storeRequest sends a request to the Mokky store and decodes the JSON answer
+// into out, if set. Every attempt is bounded by MOKKY_TIMEOUT, and network
+// errors and 5xx answers are retried up to MOKKY_RETRIES times with backoff.
+func storeRequest(cfg *Config, method, path string, body interface{}, out interface{}) error {
+	var jsonData []byte
+	if body != nil {
+		var err error
+		if jsonData, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("error marshaling request body: %v", err)
+		}
+	}
+
+	delay := storeRetryDelay
+	for attempt := 0; ; attempt++ {
+		respBody, err := storeAttempt(cfg, method, cfg.MokkyURL+path, jsonData)
+		if err == nil {
+			if storeDown.CompareAndSwap(true, false) {
+				slog.Info("Store is reachable again")
+			}
+			if out == nil {
+				return nil
+			}
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return fmt.Errorf("error decoding API response: %v", err)
+			}
+			return nil
+		}
+
+		if !errors.Is(err, errStoreUnavailable) {
+			return err
+		}
+		if attempt >= cfg.StoreRetries || storeDown.Load() {
+			if storeDown.CompareAndSwap(false, true) {
+				slog.Error("Store is unreachable, conversations are kept in memory until it is back", "err", err)
+			}
+			return err
+		}
+
+		slog.Warn("Store request failed, retrying", "method", method, "path", path, "delay", delay, "err", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// storeAttempt sends a single request and returns the response body of a
+// successful (200 or 201) answer.
+func storeAttempt(cfg *Config, method, url string, jsonData []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StoreTimeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if jsonData != nil {
+		reqBody = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	if jsonData != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := (&http.Client{Transport: httpTransport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error sending request: %v", errStoreUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading response body: %v", errStoreUnavailable, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		return respBody, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errStoreNotFound
+	case resp.StatusCode >= 500:
+		return nil, fmt.Errorf("%w: API returned status code %d", errStoreUnavailable, resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("API returned unexpected status code: %d", resp.StatusCode)
+	}
+}
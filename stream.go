@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// streamContent sends reqBody to the streamGenerateContent endpoint and calls
+// onChunk for every partial response as it arrives. In mock mode the canned
+// response is delivered as a single chunk.
+func streamContent(cfg *Config, model string, reqBody interface{}, timeout time.Duration, onChunk func(GeminiResponse)) error {
+	jsonData, err := json.Marshal(requestForModel(model, reqBody))
+	if err != nil {
+		return fmt.Errorf("error marshaling request body: %v", err)
+	}
+
+	release, err := geminiLimiter.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if cfg.MockGemini {
+		body, err := mockGenerateContent(jsonData)
+		if err != nil {
+			return err
+		}
+		var chunk GeminiResponse
+		if err := json.Unmarshal(body, &chunk); err != nil {
+			return fmt.Errorf("%w: %v", errDecodeResponse, err)
+		}
+		onChunk(chunk)
+		return nil
+	}
+
+	client := &http.Client{Timeout: timeout, Transport: httpTransport}
+	req, err := newGeminiRequest(cfg, model, "streamGenerateContent", url.Values{"alt": {"sse"}}, jsonData)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			return fmt.Errorf("%w: %v", ErrTimeout, err)
+		}
+		return fmt.Errorf("error making request to Gemini API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		slog.Error("Gemini returned an error", "model", model, "status", resp.StatusCode, "body", string(body))
+		return newAPIStatusError(resp.StatusCode, string(body))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	// Chunks carrying inline images can be far larger than the default limit
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		if reason := blockReason([]byte(data)); reason != "" {
+			return fmt.Errorf("%w: %s", ErrSafetyBlocked, reason)
+		}
+
+		var chunk GeminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("%w: %v", errDecodeResponse, err)
+		}
+		onChunk(chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		if isTimeout(err) {
+			return fmt.Errorf("%w: %v", ErrTimeout, err)
+		}
+		return fmt.Errorf("error reading response stream: %v", err)
+	}
+	return nil
+}
+
+// streamReply works like generateReply but streams the answer, calling
+// onText with the text received so far whenever a new piece arrives.
+func streamReply(cfg *Config, history []Message, userMsg string, opts ReplyOptions, onText func(string)) (Message, error) {
+	reqBody := buildTextRequest(history, userMsg, opts)
+	reqBody.Tools = toolDeclarations()
+
+	var (
+		usage         TokenUsage
+		answer        string
+		continuations int
+		tokens        []LogprobCandidate
+	)
+	for round := 0; ; round++ {
+		var (
+			role         string
+			finishReason string
+			text         strings.Builder
+			thoughts     strings.Builder
+			parts        []Part
+			lastUsage    *UsageMetadata
+		)
+		onChunk := func(chunk GeminiResponse) {
+			// Every chunk reports the usage so far, only the last one counts
+			if chunk.UsageMetadata != nil {
+				lastUsage = chunk.UsageMetadata
+			}
+			if len(chunk.Candidates) == 0 {
+				return
+			}
+			content := chunk.Candidates[0].Content
+			if reason := chunk.Candidates[0].FinishReason; reason != "" {
+				finishReason = reason
+			}
+			if result := chunk.Candidates[0].LogprobsResult; result != nil {
+				tokens = append(tokens, result.ChosenCandidates...)
+			}
+			if role == "" {
+				role = content.Role
+			}
+			parts = append(parts, content.Parts...)
+			thoughts.WriteString(thoughtText(content.Parts))
+
+			if piece := candidateText(content.Parts); piece != "" {
+				text.WriteString(piece)
+				onText(answer + text.String())
+			}
+		}
+
+		model := opts.Model
+		err := streamContent(cfg, model, reqBody, cfg.TextTimeout, onChunk)
+		if text.Len() == 0 && len(parts) == 0 && shouldFallback(cfg, model, err) {
+			slog.Warn("Model failed, retrying with the fallback model", "model", model, "fallback", cfg.FallbackModel, "err", err)
+			model = cfg.FallbackModel
+			err = streamContent(cfg, model, reqBody, cfg.TextTimeout, onChunk)
+		}
+		if err != nil {
+			return Message{}, err
+		}
+		usage.AddMetadata(lastUsage)
+
+		var responses []Part
+		for _, part := range parts {
+			if part.FunctionCall != nil {
+				responses = append(responses, Part{FunctionResponse: callTool(part.FunctionCall)})
+			}
+		}
+
+		if len(responses) == 0 || round >= maxToolRounds {
+			if text.Len() > 0 && canContinue(cfg, opts, finishReason, continuations) {
+				// Fetch the rest of an answer cut off by the token limit
+				continuations++
+				answer += text.String()
+				reqBody.Contents = continueContents(reqBody.Contents, role, parts)
+				continue
+			}
+			if answer == "" && text.Len() == 0 {
+				return Message{}, errEmptyResponse
+			}
+			return Message{
+				Role:     responseRole(role),
+				Message:  cleanReply(cfg, answer+text.String(), userMsg),
+				Model:    model,
+				Usage:    usage,
+				Thoughts: thoughts.String(),
+				Debug:    logprobsSummary(tokens),
+			}, nil
+		}
+
+		reqBody.Contents = append(reqBody.Contents,
+			Content{Role: responseRole(role), Parts: parts},
+			Content{Role: "user", Parts: responses},
+		)
+	}
+}
+
+// streamingEnabled reports whether answers to the user are streamed, using
+// the operator's STREAMING default unless the user chose with /stream.
+func streamingEnabled(cfg *Config, settings UserSettings) bool {
+	// Only a single answer can be streamed
+	if settings.Candidates > 1 {
+		return false
+	}
+	if settings.Stream != nil {
+		return *settings.Stream
+	}
+	return cfg.Streaming
+}
+
+// streamHandler lets users turn streaming of answers on or off with
+// /stream on|off.
+func streamHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		var enabled bool
+		switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+		case "on":
+			enabled = true
+		case "off":
+			enabled = false
+		default:
+			return c.Send("Usage: /stream on to see answers as they are written, /stream off to get them in one piece")
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), func(s *UserSettings) { s.Stream = &enabled }); err != nil {
+			updateLogger(c).Error("Error saving stream setting", "err", err)
+			return c.Send("Error saving your preference")
+		}
+
+		if enabled {
+			return c.Send("Answers will be streamed as they are written")
+		}
+		return c.Send("Answers will be sent in one piece")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// generateStructured asks model to answer with JSON following schema,
+// checks that the answer is valid JSON and decodes it into a T. instruction is
+// the system instruction and parts is the content of the user turn.
+func generateStructured[T any](cfg *Config, model, instruction string, parts []Part, schema *Schema) (T, error) {
+	var result T
+
+	reqBody := GeminiRequest{
+		SystemInstruction: Content{
+			Parts: []Part{{Text: instruction}},
+		},
+		Contents: []Content{
+			{
+				Role:  "user",
+				Parts: parts,
+			},
+		},
+		GenerationConfig: &GenerationConfig{
+			ResponseMimeType: "application/json",
+			ResponseSchema:   schema,
+		},
+	}
+
+	body, _, err := generateWithFallback(cfg, model, reqBody, cfg.TextTimeout)
+	if err != nil {
+		return result, err
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		return result, fmt.Errorf("%w: %v", errDecodeResponse, err)
+	}
+	if len(geminiResp.Candidates) == 0 {
+		return result, errEmptyResponse
+	}
+
+	text := []byte(candidateText(geminiResp.Candidates[0].Content.Parts))
+	if !json.Valid(text) {
+		return result, fmt.Errorf("%w: answer is not valid JSON", errDecodeResponse)
+	}
+	if err := json.Unmarshal(text, &result); err != nil {
+		return result, fmt.Errorf("%w: invalid JSON answer: %v", errDecodeResponse, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestGenerateStructured(t *testing.T) {
+	type answer struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		want    answer
+		wantErr error
+	}{
+		{"valid JSON", geminiText(`{"name":"cat","count":2}`), answer{Name: "cat", Count: 2}, nil},
+		{"answer is not JSON", geminiText("Sure, here is a cat"), answer{}, errDecodeResponse},
+		{"answer doesn't match the type", geminiText(`{"name":3}`), answer{}, errDecodeResponse},
+		{"response is not JSON", "<html>", answer{}, errDecodeResponse},
+		{"no candidates", `{"candidates":[]}`, answer{}, errEmptyResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+				var req GeminiRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				if req.GenerationConfig == nil || req.GenerationConfig.ResponseMimeType != "application/json" || req.GenerationConfig.ResponseSchema == nil {
+					t.Errorf("generationConfig = %+v, want a JSON answer with the schema", req.GenerationConfig)
+				}
+				w.Write([]byte(tt.body))
+			})
+
+			got, err := generateStructured[answer](cfg, textModel, "Describe", []Part{{Text: "a cat"}}, &Schema{Type: "OBJECT"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("generateStructured() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("generateStructured() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const summarizeInstruction = "Summarize this conversation concisely. Keep the facts, decisions and open questions that matter for continuing it, and skip small talk."
+
+// summarizeHistory asks model for a concise summary of a conversation.
+func summarizeHistory(cfg *Config, model string, messages []Message) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		speaker := "User"
+		if msg.Role != "user" {
+			speaker = "Assistant"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", speaker, msg.Message)
+	}
+
+	reqBody := GeminiRequest{
+		SystemInstruction: Content{
+			Parts: []Part{{Text: summarizeInstruction}},
+		},
+		Contents: []Content{
+			{
+				Role:  "user",
+				Parts: []Part{{Text: transcript.String()}},
+			},
+		},
+	}
+
+	body, _, err := generateWithFallback(cfg, model, reqBody, cfg.TextTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		return "", fmt.Errorf("%w: %v", errDecodeResponse, err)
+	}
+	if len(geminiResp.Candidates) == 0 {
+		return "", errEmptyResponse
+	}
+
+	summary := strings.TrimSpace(candidateText(geminiResp.Candidates[0].Content.Parts))
+	if summary == "" {
+		return "", errEmptyResponse
+	}
+	return summary, nil
+}
+
+// compactHistory replaces the active session with a single exchange holding
+// the summary, so later prompts carry less context.
+func compactHistory(cfg *Config, telegramID int64, summary string) error {
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("no history found for this user")
+	}
+
+	deleteStoredImages(cfg, user.SessionMessages())
+	user.SetSessionMessages([]Message{
+		{Role: "user", Message: summaryPrompt, Summary: true},
+		{Role: "model", Message: summary, Summary: true},
+	})
+	return patchHistory(cfg, user)
+}
+
+// summarizeHandler answers /summarize with a recap of the conversation.
+// "/summarize --compact" also replaces the history with the recap.
+func summarizeHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		cfg := userConfig(cfg, c.Sender())
+		compact := strings.TrimSpace(c.Message().Payload) == "--compact"
+
+		messages, err := getUserMessages(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting previous messages", "err", err)
+			return c.Send("Error loading your history")
+		}
+		if len(messages) == 0 {
+			return c.Send("Your history is empty, there's nothing to summarize yet")
+		}
+
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+
+		stopTyping := keepTyping(c)
+		defer stopTyping()
+		thinking := startPlaceholder(c, cfg, replyTarget(c, settings))
+		defer thinking.Cancel()
+
+		summary, err := summarizeHistory(cfg, userModel(cfg, settings), messages)
+		stopTyping()
+		if err != nil {
+			updateLogger(c).Error("Error summarizing history", "err", err)
+			_, err = thinking.Resolve(replyErrorMessage(err))
+			return err
+		}
+
+		if compact {
+			if err := compactHistory(cfg, c.Sender().ID, summary); err != nil {
+				updateLogger(c).Error("Error compacting history", "err", err)
+				summary += "\n\n(Your history couldn't be replaced with this summary)"
+			} else {
+				summary += "\n\n(Your history was replaced with this summary)"
+			}
+		}
+
+		_, err = deliverReply(c, cfg, thinking, settings, summary, nil)
+		return err
+	}
+}
+
+// summaryPrompt is the user turn that introduces a stored context summary.
+const summaryPrompt = "Summarize our conversation so far."
+
+// estimateTokens roughly estimates the number of tokens of the messages,
+// counting about four characters per token.
+func estimateTokens(messages []Message) int {
+	chars := 0
+	for _, msg := range messages {
+		chars += utf8.RuneCountInString(msg.Message)
+	}
+	return chars / 4
+}
+
+// summarizeOldContext keeps long conversations within CONTEXT_SUMMARY_TOKENS.
+// When the history grows past it, the oldest half is replaced by a summary
+// exchange that is stored, so it is only computed once.
+func summarizeOldContext(cfg *Config, telegramID int64, model string, messages []Message) ([]Message, error) {
+	if cfg.ContextSummaryTokens <= 0 || estimateTokens(messages) <= cfg.ContextSummaryTokens {
+		return messages, nil
+	}
+
+	// The remaining half has to start with a user turn
+	half := len(messages) / 2
+	for half < len(messages) && messages[half].Role != "user" {
+		half++
+	}
+	if half < 2 || half >= len(messages) {
+		return messages, nil
+	}
+
+	slog.Info("Summarizing old context", "user_id", telegramID, "max_tokens", cfg.ContextSummaryTokens, "messages", half)
+
+	summary, err := summarizeHistory(cfg, model, messages[:half])
+	if err != nil {
+		return messages, fmt.Errorf("error summarizing old context: %v", err)
+	}
+
+	summaryTurns := []Message{
+		{Role: "user", Message: summaryPrompt, Summary: true},
+		{Role: "model", Message: summary, Summary: true},
+	}
+
+	// The passed messages may have their images stripped, so rewrite a fresh
+	// copy of the record
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return messages, fmt.Errorf("error saving context summary: %v", err)
+	}
+	if user == nil {
+		return messages, nil
+	}
+
+	stored := user.SessionMessages()
+	if len(stored) < half {
+		return messages, nil
+	}
+	deleteStoredImages(cfg, stored[:half])
+	user.SetSessionMessages(append(summaryTurns, stored[half:]...))
+	if err := patchHistory(cfg, user); err != nil {
+		return messages, fmt.Errorf("error saving context summary: %v", err)
+	}
+
+	return append(summaryTurns, messages[half:]...), nil
+}
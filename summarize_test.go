@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// longTurns returns n alternating turns of 40 characters, about 10 tokens
+// each.
+func longTurns(n int) []Message {
+	messages := turns(n)
+	for i := range messages {
+		messages[i].Message = fmt.Sprintf("%-40d", i)
+	}
+	return messages
+}
+
+func TestCompactHistory(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	store.users[1] = &UserMessages{ID: 1, TelegramID: 7, Messages: turns(6)}
+
+	if err := compactHistory(cfg, 7, "we talked"); err != nil {
+		t.Fatalf("compactHistory() error = %v", err)
+	}
+	stored := store.messages(7)
+	if len(stored) != 2 || stored[0].Message != summaryPrompt || stored[1].Message != "we talked" || !stored[0].Summary || !stored[1].Summary {
+		t.Errorf("stored turns = %+v, want the summary exchange", stored)
+	}
+
+	if err := compactHistory(cfg, 8, "nothing"); err == nil {
+		t.Error("compactHistory() of a user without history succeeded")
+	}
+}
+
+func TestSummarizeOldContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		messages    []Message
+		limit       int
+		wantSummary bool
+		wantKept    int
+	}{
+		{"under the limit", longTurns(6), 100, false, 6},
+		{"disabled", longTurns(20), 0, false, 20},
+		{"over the limit", longTurns(8), 50, true, 4},
+		{"half ends on a model turn", longTurns(10), 50, true, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, storeCfg := newFakeUserStore(t, 0)
+			store.users[1] = &UserMessages{ID: 1, TelegramID: 7, Messages: tt.messages}
+			var requests int
+			cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				fmt.Fprint(w, geminiText("we talked"))
+			})
+			cfg.MokkyURL, cfg.StoreTimeout = storeCfg.MokkyURL, storeCfg.StoreTimeout
+			cfg.ContextSummaryTokens = tt.limit
+
+			got, err := summarizeOldContext(cfg, 7, textModel, tt.messages)
+			if err != nil {
+				t.Fatalf("summarizeOldContext() error = %v", err)
+			}
+			if (requests > 0) != tt.wantSummary {
+				t.Errorf("sent %d summary requests, want a summary %v", requests, tt.wantSummary)
+			}
+
+			stored := store.messages(7)
+			if !tt.wantSummary {
+				if len(got) != len(tt.messages) || len(stored) != len(tt.messages) {
+					t.Errorf("kept %d turns and stored %d, want all %d", len(got), len(stored), len(tt.messages))
+				}
+				return
+			}
+
+			for name, messages := range map[string][]Message{"returned": got, "stored": stored} {
+				if len(messages) != 2+tt.wantKept {
+					t.Fatalf("%s %d turns, want the summary and %d turns", name, len(messages), tt.wantKept)
+				}
+				if messages[0].Message != summaryPrompt || messages[1].Message != "we talked" {
+					t.Errorf("%s turns start with %q, %q, want the summary exchange", name, messages[0].Message, messages[1].Message)
+				}
+				rest := messages[2:]
+				if rest[0].Role != "user" || rest[len(rest)-1].Message != tt.messages[len(tt.messages)-1].Message {
+					t.Errorf("%s turns after the summary = %v, want the most recent %d", name, rest, tt.wantKept)
+				}
+			}
+			if estimateTokens(got) > estimateTokens(tt.messages) {
+				t.Errorf("the summarized context has %d tokens, more than %d before", estimateTokens(got), estimateTokens(tt.messages))
+			}
+		})
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	messages := []Message{{Message: strings.Repeat("a", 10)}, {Message: strings.Repeat("я", 10)}}
+	if got := estimateTokens(messages); got != 5 {
+		t.Errorf("estimateTokens() = %d, want 5", got)
+	}
+}
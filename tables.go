@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// formatTables turns the Markdown tables of text into code blocks with aligned
+// columns, which Telegram shows in a monospace font. Rows with fewer cells
+// than the header are padded and extra cells get their own columns. Code
+// blocks are left untouched.
+func formatTables(text string) string {
+	if !strings.Contains(text, "|") {
+		return text
+	}
+
+	blocks := splitBlocks(text)
+	parts := make([]string, len(blocks))
+	for i, block := range blocks {
+		parts[i] = block.text
+		if block.opener == "" {
+			parts[i] = formatPlainTables(block.text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// formatPlainTables replaces the tables found in text without code blocks.
+// A table is a row of cells followed by a separator row, e.g. "|---|:-:|",
+// and the rows after it.
+func formatPlainTables(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		if i+1 >= len(lines) || !isTableRow(lines[i]) || !isTableSeparator(lines[i+1]) {
+			out = append(out, lines[i])
+			continue
+		}
+
+		rows := [][]string{tableCells(lines[i])}
+		j := i + 2
+		for ; j < len(lines) && isTableRow(lines[j]); j++ {
+			rows = append(rows, tableCells(lines[j]))
+		}
+		out = append(out, codeFence+"\n"+alignTable(rows)+"\n"+codeFence)
+		i = j - 1
+	}
+	return strings.Join(out, "\n")
+}
+
+// isTableRow reports whether line looks like a row of a Markdown table.
+func isTableRow(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.Contains(line, "|") && line != "|"
+}
+
+// isTableSeparator reports whether line separates the header of a Markdown
+// table from its rows.
+func isTableSeparator(line string) bool {
+	if !isTableRow(line) {
+		return false
+	}
+	for _, cell := range tableCells(line) {
+		cell = strings.TrimSuffix(strings.TrimPrefix(cell, ":"), ":")
+		if cell == "" || strings.Trim(cell, "-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// tableCells splits a table row into its trimmed cells. Pipes escaped with a
+// backslash stay in the cell.
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = strings.TrimSuffix(line, "|")
+	}
+
+	var (
+		cells []string
+		cell  strings.Builder
+	)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// alignTable lays rows out in columns as wide as their widest cell, with a
+// rule under the header.
+func alignTable(rows [][]string) string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var out []string
+	for r, row := range rows {
+		// Empty cells at the end of a row are left out
+		for len(row) > 0 && row[len(row)-1] == "" {
+			row = row[:len(row)-1]
+		}
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		}
+		out = append(out, strings.TrimRight(strings.Join(cells, " | "), " "))
+
+		if r == 0 {
+			rules := make([]string, len(widths))
+			for i, width := range widths {
+				rules[i] = strings.Repeat("-", width)
+			}
+			out = append(out, strings.Join(rules, "-+-"))
+		}
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestFormatTables(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "no table",
+			text: "plain text",
+			want: "plain text",
+		},
+		{
+			name: "pipe without table",
+			text: "a | b\nnext line",
+			want: "a | b\nnext line",
+		},
+		{
+			name: "table",
+			text: "People:\n| Name | Age |\n|---|--:|\n| Alice | 30 |\n| Bob | 4 |\nThat's all",
+			want: "People:\n```\nName  | Age\n------+----\nAlice | 30\nBob   | 4\n```\nThat's all",
+		},
+		{
+			name: "without outer pipes",
+			text: "a | b\n:-- | :-:\n1 | 2",
+			want: "```\na | b\n--+--\n1 | 2\n```",
+		},
+		{
+			name: "short and long rows",
+			text: "| a | b |\n|---|---|\n| 1 |\n| 1 | 2 | 3 |",
+			want: "```\na | b\n--+---+--\n1\n1 | 2 | 3\n```",
+		},
+		{
+			name: "escaped pipe",
+			text: "| op | meaning |\n|---|---|\n| a \\| b | or |",
+			want: "```\nop    | meaning\n------+--------\na | b | or\n```",
+		},
+		{
+			name: "wide characters",
+			text: "| город | n |\n|---|---|\n| Омск | 1 |",
+			want: "```\nгород | n\n------+--\nОмск  | 1\n```",
+		},
+		{
+			name: "table in a code block is kept",
+			text: "```\n| a | b |\n|---|---|\n```",
+			want: "```\n| a | b |\n|---|---|\n```",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTables(tt.text); got != tt.want {
+				t.Errorf("formatTables() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Limits for /thinking. -1 lets the model decide how much to think and 0
+// turns thinking off on models that allow it.
+const (
+	dynamicThinkingBudget = -1
+	maxThinkingBudget     = 32768
+)
+
+// ThinkingConfig controls the reasoning of models that think before they
+// answer.
+type ThinkingConfig struct {
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+}
+
+// thinkingModelPrefixes are the model families that accept a thinkingConfig.
+// Other models reject requests that contain one.
+var thinkingModelPrefixes = []string{"gemini-2.5", "gemini-3"}
+
+// supportsThinking reports whether model accepts a thinkingConfig.
+func supportsThinking(model string) bool {
+	for _, prefix := range thinkingModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(model, "thinking")
+}
+
+// thinkingConfig returns the thinking settings for a reply, or nil when the
+// model defaults apply.
+func (o ReplyOptions) thinkingConfig() *ThinkingConfig {
+	if o.ThinkingBudget == nil && !o.ShowThoughts {
+		return nil
+	}
+	return &ThinkingConfig{ThinkingBudget: o.ThinkingBudget, IncludeThoughts: o.ShowThoughts}
+}
+
+// thoughtText joins the thought summaries of a candidate.
+func thoughtText(parts []Part) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Thought && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "")
+}
+
+// withThoughts puts the model's thoughts, if any, above the answer.
+func withThoughts(thoughts, answer string) string {
+	thoughts = strings.TrimSpace(thoughts)
+	if thoughts == "" {
+		return answer
+	}
+	return "Thoughts:\n" + thoughts + "\n\nAnswer:\n" + answer
+}
+
+// thinkingHandler tunes the thinking budget with /thinking <n>, resets it
+// with /thinking default and shows or hides the model's thoughts with
+// /thinking show|hide.
+func thinkingHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		payload := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+		if payload == "" {
+			settings, err := getUserSettings(cfg, c.Sender().ID)
+			if err != nil {
+				updateLogger(c).Error("Error getting user settings", "err", err)
+			}
+			current := "model default"
+			if settings.ThinkingBudget != nil {
+				current = strconv.Itoa(*settings.ThinkingBudget)
+			}
+			shown := "hidden"
+			if settings.ShowThoughts {
+				shown = "shown"
+			}
+			return c.Send(fmt.Sprintf("Thinking budget: %s, thoughts are %s\n\nUsage: /thinking <%d-%d> to set the budget in tokens (%d lets the model decide, 0 turns thinking off), /thinking default to reset it, /thinking show|hide to see the model's thoughts", current, shown, dynamicThinkingBudget, maxThinkingBudget, dynamicThinkingBudget))
+		}
+
+		var (
+			update func(*UserSettings)
+			reply  string
+		)
+		switch payload {
+		case "show", "hide":
+			show := payload == "show"
+			update = func(s *UserSettings) { s.ShowThoughts = show }
+			reply = "The model's thoughts will be hidden"
+			if show {
+				reply = "The model's thoughts will be shown above answers"
+			}
+		case "default":
+			update = func(s *UserSettings) { s.ThinkingBudget = nil }
+			reply = "The model decides how much to think again"
+		default:
+			budget, err := strconv.Atoi(payload)
+			if err != nil || budget < dynamicThinkingBudget || budget > maxThinkingBudget {
+				return c.Send(fmt.Sprintf("Please choose a budget between %d and %d, default, show or hide", dynamicThinkingBudget, maxThinkingBudget))
+			}
+			update = func(s *UserSettings) { s.ThinkingBudget = &budget }
+			reply = fmt.Sprintf("Thinking budget set to %d tokens", budget)
+		}
+
+		if err := updateUserSettings(cfg, c.Sender(), update); err != nil {
+			updateLogger(c).Error("Error saving thinking setting", "err", err)
+			return c.Send("Error saving your preference")
+		}
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+		if !supportsThinking(userModel(cfg, settings)) {
+			reply += ". The current model doesn't support thinking, so the setting is ignored for now"
+		}
+		return c.Send(reply)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxToolRounds bounds how many function calls are resolved for a single
+// reply so a misbehaving model can't loop forever.
+const maxToolRounds = 5
+
+type Schema struct {
+	Type        string             `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+}
+
+type FunctionDeclaration struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Parameters  *Schema `json:"parameters,omitempty"`
+}
+
+type Tool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations"`
+}
+
+type FunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+type FunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// toolHandler executes a function call and returns the JSON object sent back
+// to Gemini as the function response.
+type toolHandler func(args map[string]interface{}) (map[string]interface{}, error)
+
+type registeredTool struct {
+	Declaration FunctionDeclaration
+	Handler     toolHandler
+}
+
+// registeredTools holds every tool offered to the text model, keyed by name.
+var registeredTools = map[string]registeredTool{}
+
+// registerTool makes a tool available to the text model.
+func registerTool(decl FunctionDeclaration, handler toolHandler) {
+	registeredTools[decl.Name] = registeredTool{Declaration: decl, Handler: handler}
+}
+
+func init() {
+	registerTool(FunctionDeclaration{
+		Name:        "calculator",
+		Description: "Evaluates an arithmetic expression with + - * / ^ and parentheses and returns the numeric result.",
+		Parameters: &Schema{
+			Type: "OBJECT",
+			Properties: map[string]*Schema{
+				"expression": {Type: "STRING", Description: "The expression to evaluate, for example (2 + 3) * 4"},
+			},
+			Required: []string{"expression"},
+		},
+	}, calculatorTool)
+}
+
+// toolDeclarations returns the declarations of all registered tools in a
+// stable order, or nil when there are none.
+func toolDeclarations() []Tool {
+	if len(registeredTools) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(registeredTools))
+	for name := range registeredTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var decls []FunctionDeclaration
+	for _, name := range names {
+		decls = append(decls, registeredTools[name].Declaration)
+	}
+	return []Tool{{FunctionDeclarations: decls}}
+}
+
+// callTool dispatches a function call to its registered handler. Errors are
+// reported back to the model inside the response rather than aborting the
+// reply.
+func callTool(call *FunctionCall) *FunctionResponse {
+	slog.Info("Calling tool", "tool", call.Name, "args", call.Args)
+
+	tool, ok := registeredTools[call.Name]
+	if !ok {
+		return &FunctionResponse{
+			Name:     call.Name,
+			Response: map[string]interface{}{"error": fmt.Sprintf("unknown function %q", call.Name)},
+		}
+	}
+
+	result, err := tool.Handler(call.Args)
+	if err != nil {
+		return &FunctionResponse{
+			Name:     call.Name,
+			Response: map[string]interface{}{"error": err.Error()},
+		}
+	}
+	return &FunctionResponse{Name: call.Name, Response: result}
+}
+
+func calculatorTool(args map[string]interface{}) (map[string]interface{}, error) {
+	expr, ok := args["expression"].(string)
+	if !ok || strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("expression argument is required")
+	}
+
+	result, err := evaluateExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"result": result}, nil
+}
+
+// evaluateExpression computes the value of a simple arithmetic expression.
+func evaluateExpression(expr string) (float64, error) {
+	p := &exprParser{input: expr}
+	value, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+	return value, nil
+}
+
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) parseSum() (float64, error) {
+	value, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return value, nil
+		}
+		p.pos++
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			value += rhs
+		} else {
+			value -= rhs
+		}
+	}
+}
+
+func (p *exprParser) parseProduct() (float64, error) {
+	value, err := p.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return value, nil
+		}
+		p.pos++
+		rhs, err := p.parsePower()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			value *= rhs
+		case '/':
+			if rhs == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			value /= rhs
+		case '%':
+			if rhs == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			value = math.Mod(value, rhs)
+		}
+	}
+}
+
+func (p *exprParser) parsePower() (float64, error) {
+	base, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	// Exponentiation is right associative
+	exp, err := p.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exp), nil
+}
+
+func (p *exprParser) parseUnary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.parseUnary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (float64, error) {
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		value, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] == '.' || (p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.input[start:p.pos], 64)
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	default:
+		return 0, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    float64
+		wantErr bool
+	}{
+		{"1 + 2", 3, false},
+		{"2 + 3 * 4", 14, false},
+		{"(2 + 3) * 4", 20, false},
+		{"10 - 4 - 3", 3, false},
+		{"8 / 4 / 2", 1, false},
+		{"2 ^ 3 ^ 2", 512, false},
+		{"(2 ^ 3) ^ 2", 64, false},
+		{"2 * 3 ^ 2", 18, false},
+		{"-3 + 5", 2, false},
+		{"7 % 4", 3, false},
+		{".5 * 4", 2, false},
+		{"1 / 0", 0, true},
+		{"5 % 0", 0, true},
+		{"10 ^ 400", 0, true},
+		{"", 0, true},
+		{"2 +", 0, true},
+		{"(1 + 2", 0, true},
+		{"1 + 2)", 0, true},
+		{"two plus two", 0, true},
+		{"1..2", 0, true},
+		{"3 $ 4", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := evaluateExpression(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluateExpression(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("evaluateExpression(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallTool(t *testing.T) {
+	tests := []struct {
+		name string
+		call FunctionCall
+		want map[string]interface{}
+	}{
+		{"result", FunctionCall{Name: "calculator", Args: map[string]interface{}{"expression": "6 * 7"}}, map[string]interface{}{"result": 42.0}},
+		{"invalid expression", FunctionCall{Name: "calculator", Args: map[string]interface{}{"expression": "1 / 0"}}, map[string]interface{}{"error": "division by zero"}},
+		{"missing argument", FunctionCall{Name: "calculator", Args: map[string]interface{}{}}, map[string]interface{}{"error": "expression argument is required"}},
+		{"unknown function", FunctionCall{Name: "weather"}, map[string]interface{}{"error": `unknown function "weather"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := callTool(&tt.call)
+			if got.Name != tt.call.Name || fmt.Sprint(got.Response) != fmt.Sprint(tt.want) {
+				t.Errorf("callTool() = %s %v, want %s %v", got.Name, got.Response, tt.call.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculatorRoundTrip(t *testing.T) {
+	var requests []GeminiRequest
+	cfg := newGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req GeminiRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"calculator","args":{"expression":"6 * 7"}}}]}}]}`)
+			return
+		}
+		fmt.Fprint(w, geminiText("6 times 7 is 42"))
+	})
+
+	reply, err := generateReply(cfg, nil, "What is 6 times 7?", ReplyOptions{Model: textModel})
+	if err != nil {
+		t.Fatalf("generateReply() error = %v", err)
+	}
+	if reply.Message != "6 times 7 is 42" {
+		t.Errorf("reply = %q, want the final answer", reply.Message)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(requests))
+	}
+	if len(requests[0].Tools) == 0 {
+		t.Error("the calculator wasn't declared")
+	}
+	contents := requests[1].Contents
+	if len(contents) != 3 {
+		t.Fatalf("second request has %d contents, want the prompt, the call and its response", len(contents))
+	}
+	if call := contents[1].Parts[0].FunctionCall; contents[1].Role != "model" || call == nil || call.Name != "calculator" {
+		t.Errorf("second content = %+v, want the model's function call", contents[1])
+	}
+	resp := contents[2].Parts[0].FunctionResponse
+	if contents[2].Role != "user" || resp == nil || resp.Name != "calculator" || resp.Response["result"] != 42.0 {
+		t.Errorf("third content = %+v, want the calculator result", contents[2])
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	tele "gopkg.in/telebot.v3"
+)
+
+// threadID returns the forum topic the update belongs to, or 0 for chats
+// without topics and for the General topic.
+func threadID(c tele.Context) int {
+	if msg := c.Message(); msg != nil && msg.TopicMessage {
+		return msg.ThreadID
+	}
+	return 0
+}
+
+// withThread adds the topic to a list of send options. An existing
+// *tele.SendOptions is copied rather than modified, since telebot lets it
+// replace earlier options.
+func withThread(thread int, opts []interface{}) []interface{} {
+	if thread == 0 {
+		return opts
+	}
+
+	result := make([]interface{}, 0, len(opts)+1)
+	found := false
+	for _, opt := range opts {
+		if sendOpts, ok := opt.(*tele.SendOptions); ok && sendOpts != nil {
+			withTopic := *sendOpts
+			withTopic.ThreadID = thread
+			opt = &withTopic
+			found = true
+		}
+		result = append(result, opt)
+	}
+	if !found {
+		result = append([]interface{}{&tele.SendOptions{ThreadID: thread}}, result...)
+	}
+	return result
+}
+
+// sendReply sends a message to the chat and topic of the update and returns
+// the sent message. Use it instead of c.Bot().Send when the message is needed.
+func sendReply(c tele.Context, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	return c.Bot().Send(c.Recipient(), what, withThread(threadID(c), opts)...)
+}
+
+// topicContext makes c.Send and c.Reply answer in the topic of the incoming
+// message.
+type topicContext struct {
+	tele.Context
+	thread int
+}
+
+func (c *topicContext) Send(what interface{}, opts ...interface{}) error {
+	return c.Context.Send(what, withThread(c.thread, opts)...)
+}
+
+func (c *topicContext) SendAlbum(a tele.Album, opts ...interface{}) error {
+	return c.Context.SendAlbum(a, withThread(c.thread, opts)...)
+}
+
+func (c *topicContext) Reply(what interface{}, opts ...interface{}) error {
+	return c.Context.Reply(what, withThread(c.thread, opts)...)
+}
+
+// topicMiddleware keeps replies in the forum topic they were asked in.
+func topicMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if thread := threadID(c); thread != 0 {
+			return next(&topicContext{Context: c, thread: thread})
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const translateSystemInstruction = "You are a translator. Translate the text you are given into the requested language. " +
+	"Return only the translation, keeping the meaning, tone and formatting of the original. " +
+	"Do not explain, comment on or answer the text."
+
+// translateSaveFlag makes /translate keep the exchange in the history.
+const translateSaveFlag = "--save"
+
+// languageCodes maps the ISO 639-1 codes of common languages to their names.
+var languageCodes = map[string]string{
+	"ar": "Arabic",
+	"bg": "Bulgarian",
+	"cs": "Czech",
+	"da": "Danish",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fa": "Persian",
+	"fi": "Finnish",
+	"fr": "French",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"hu": "Hungarian",
+	"id": "Indonesian",
+	"it": "Italian",
+	"ja": "Japanese",
+	"kk": "Kazakh",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"no": "Norwegian",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ro": "Romanian",
+	"ru": "Russian",
+	"sk": "Slovak",
+	"sr": "Serbian",
+	"sv": "Swedish",
+	"th": "Thai",
+	"tr": "Turkish",
+	"uk": "Ukrainian",
+	"uz": "Uzbek",
+	"vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+// parseTranslateArgs splits the payload of /translate into the target
+// language and whether the exchange should be saved. Languages are given by
+// code, e.g. "de", or by name, e.g. "german" or "Brazilian Portuguese".
+func parseTranslateArgs(payload string) (lang string, save bool, err error) {
+	var words []string
+	for _, field := range strings.Fields(payload) {
+		if strings.EqualFold(field, translateSaveFlag) {
+			save = true
+			continue
+		}
+		words = append(words, field)
+	}
+
+	lang = strings.Join(words, " ")
+	if lang == "" {
+		return "", false, fmt.Errorf("no language given")
+	}
+	if utf8.RuneCountInString(lang) > 40 {
+		return "", false, fmt.Errorf("language name %q is too long", lang)
+	}
+	for _, r := range lang {
+		if !unicode.IsLetter(r) && r != ' ' && r != '-' {
+			return "", false, fmt.Errorf("invalid language %q", lang)
+		}
+	}
+
+	if name, ok := languageCodes[strings.ToLower(lang)]; ok {
+		return name, save, nil
+	}
+	for _, name := range languageCodes {
+		if strings.EqualFold(name, lang) {
+			return name, save, nil
+		}
+	}
+	if len(words) == 1 && utf8.RuneCountInString(lang) <= 3 {
+		return "", false, fmt.Errorf("unknown language code %q", lang)
+	}
+	return lang, save, nil
+}
+
+// lastUserMessage returns the text of the latest user turn of messages.
+func lastUserMessage(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" && !messages[i].Summary && messages[i].Message != "" {
+			return messages[i].Message
+		}
+	}
+	return ""
+}
+
+// translateText asks model for a translation of text into lang. It returns
+// the model that produced it, which differs after a fallback, along with the
+// response.
+func translateText(cfg *Config, model, text, lang string) (GeminiResponse, string, error) {
+	reqBody := GeminiRequest{
+		SystemInstruction: Content{
+			Parts: []Part{{Text: translateSystemInstruction}},
+		},
+		Contents: []Content{
+			{
+				Role:  "user",
+				Parts: []Part{{Text: "Translate into " + lang + ":\n\n" + text}},
+			},
+		},
+	}
+
+	body, model, err := generateWithFallback(cfg, model, reqBody, cfg.TextTimeout)
+	if err != nil {
+		return GeminiResponse{}, model, err
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		return GeminiResponse{}, model, fmt.Errorf("%w: %v", errDecodeResponse, err)
+	}
+	if len(geminiResp.Candidates) == 0 || strings.TrimSpace(candidateText(geminiResp.Candidates[0].Content.Parts)) == "" {
+		return GeminiResponse{}, model, errEmptyResponse
+	}
+	return geminiResp, model, nil
+}
+
+// translateHandler answers /translate <lang> with a translation of the
+// message it replies to, or of the user's last message. Translations are kept
+// out of the history unless --save is added.
+func translateHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		cfg := userConfig(cfg, c.Sender())
+		lang, save, err := parseTranslateArgs(c.Message().Payload)
+		if err != nil {
+			return c.Send("Usage: /translate <language> [--save], e.g. /translate de or /translate Spanish, as a reply to a message or to translate your last one")
+		}
+
+		var text string
+		if replyTo := c.Message().ReplyTo; replyTo != nil {
+			text = replyTo.Text
+			if text == "" {
+				text = replyTo.Caption
+			}
+		} else {
+			messages, err := getUserMessages(cfg, c.Sender().ID)
+			if err != nil {
+				updateLogger(c).Error("Error getting previous messages", "err", err)
+				return c.Send("Error loading your history")
+			}
+			text = lastUserMessage(messages)
+		}
+		if strings.TrimSpace(text) == "" {
+			return c.Send("There's no text to translate. Reply to a message with /translate " + lang)
+		}
+
+		if ok, err := useDailyQuota(c, cfg); !ok {
+			return err
+		}
+
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+
+		stopTyping := keepTyping(c)
+		defer stopTyping()
+		thinking := startPlaceholder(c, cfg, replyTarget(c, settings))
+		defer thinking.Cancel()
+
+		requested := userModel(cfg, settings)
+		geminiResp, model, err := translateText(cfg, requested, text, lang)
+		stopTyping()
+		if err != nil {
+			updateLogger(c).Error("Error translating", "err", err)
+			_, err = thinking.Resolve(replyErrorMessage(err))
+			return err
+		}
+
+		translation := strings.TrimSpace(candidateText(geminiResp.Candidates[0].Content.Parts))
+		if save {
+			userTurn := Message{Role: "user", Message: "Translate into " + lang + ":\n\n" + text, MessageID: c.Message().ID}
+			modelTurn := Message{Role: "model", Message: translation, Model: model}
+			modelTurn.Usage.AddMetadata(geminiResp.UsageMetadata)
+			historySaves.Save(cfg, c.Sender().ID, c.Sender(), userTurn, modelTurn)
+		}
+
+		_, err = deliverReply(c, cfg, thinking, settings, translation+fallbackNote(requested, model), nil)
+		return err
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTranslateArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		wantLang string
+		wantSave bool
+		wantErr  bool
+	}{
+		{"code", "de", "German", false, false},
+		{"upper case code", "PT", "Portuguese", false, false},
+		{"name", "german", "German", false, false},
+		{"multi-word name", "Brazilian Portuguese", "Brazilian Portuguese", false, false},
+		{"name in another script", "русский", "русский", false, false},
+		{"save flag", "--save fr", "French", true, false},
+		{"save flag last", "Spanish --SAVE", "Spanish", true, false},
+		{"unknown code", "xx", "", false, true},
+		{"unknown name", "Klingon", "Klingon", false, false},
+		{"digits", "de1", "", false, true},
+		{"punctuation", "german!", "", false, true},
+		{"hyphenated name", "Serbo-Croatian", "Serbo-Croatian", false, false},
+		{"no language", "--save", "", false, true},
+		{"empty", "  ", "", false, true},
+		{"too long", strings.Repeat("a", 41), "", false, true},
+		{"long name in another script", strings.Repeat("я", 40), strings.Repeat("я", 40), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lang, save, err := parseTranslateArgs(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTranslateArgs(%q) error = %v, want error %v", tt.payload, err, tt.wantErr)
+			}
+			if lang != tt.wantLang || save != tt.wantSave {
+				t.Errorf("parseTranslateArgs(%q) = %q, %v, want %q, %v", tt.payload, lang, save, tt.wantLang, tt.wantSave)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpTransport carries every outgoing request, to Gemini, Mokky and the Bot
+// API, so connections are pooled and kept alive across requests instead of
+// being opened for each one. main replaces it with one tuned by the
+// configuration.
+var httpTransport http.RoundTripper = http.DefaultTransport
+
+// newHTTPTransport returns a transport like http.DefaultTransport with the
+// pool size and keep-alive settings of the configuration.
+func newHTTPTransport(cfg *Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: cfg.HTTPKeepAlive,
+	}).DialContext
+	transport.MaxIdleConns = max(transport.MaxIdleConns, cfg.HTTPIdleConnsPerHost*4)
+	transport.MaxIdleConnsPerHost = cfg.HTTPIdleConnsPerHost
+	transport.IdleConnTimeout = cfg.HTTPIdleConnTimeout
+	return transport
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// ttsModel turns text into speech for /say.
+const ttsModel = "gemini-2.5-flash-preview-tts"
+
+// defaultPCMRate is the sample rate of the speech returned by Gemini when its
+// mime type doesn't name one.
+const defaultPCMRate = 24000
+
+type SpeechConfig struct {
+	VoiceConfig VoiceConfig `json:"voiceConfig"`
+}
+
+type VoiceConfig struct {
+	PrebuiltVoiceConfig PrebuiltVoiceConfig `json:"prebuiltVoiceConfig"`
+}
+
+type PrebuiltVoiceConfig struct {
+	VoiceName string `json:"voiceName"`
+}
+
+// SpeechRequest asks the TTS model to read text aloud. The model accepts no
+// system instruction or safety settings.
+type SpeechRequest struct {
+	Contents         []Content        `json:"contents"`
+	GenerationConfig GenerationConfig `json:"generationConfig"`
+}
+
+// synthesizeSpeech reads text aloud with the TTS_VOICE voice and returns the
+// audio as a WAV file.
+func synthesizeSpeech(cfg *Config, text string) ([]byte, error) {
+	reqBody := SpeechRequest{
+		Contents: []Content{{Role: "user", Parts: []Part{{Text: text}}}},
+		GenerationConfig: GenerationConfig{
+			ResponseModalities: []string{"AUDIO"},
+			SpeechConfig: &SpeechConfig{
+				VoiceConfig: VoiceConfig{PrebuiltVoiceConfig: PrebuiltVoiceConfig{VoiceName: cfg.TTSVoice}},
+			},
+		},
+	}
+
+	body, err := generateContent(cfg, ttsModel, reqBody, cfg.TextTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		return nil, fmt.Errorf("%w: %v", errDecodeResponse, err)
+	}
+	if len(geminiResp.Candidates) == 0 {
+		return nil, errEmptyResponse
+	}
+
+	for _, part := range geminiResp.Candidates[0].Content.Parts {
+		if part.InlineData == nil || !strings.HasPrefix(part.InlineData.MimeType, "audio/") {
+			continue
+		}
+		audio, err := base64.StdEncoding.DecodeString(part.InlineData.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 audio data: %v", err)
+		}
+		if len(audio) == 0 {
+			return nil, fmt.Errorf("audio data is empty")
+		}
+		return speechWAV(audio, part.InlineData.MimeType), nil
+	}
+	return nil, fmt.Errorf("%w: no audio in response", errEmptyResponse)
+}
+
+// speechWAV wraps the raw 16-bit PCM returned by Gemini, e.g. with the mime
+// type "audio/L16;codec=pcm;rate=24000", in a WAV header. Audio in any other
+// format is returned unchanged.
+func speechWAV(audio []byte, mimeType string) []byte {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil || (!strings.EqualFold(mediaType, "audio/L16") && !strings.EqualFold(mediaType, "audio/pcm")) {
+		return audio
+	}
+
+	rate, err := strconv.Atoi(params["rate"])
+	if err != nil || rate <= 0 {
+		rate = defaultPCMRate
+	}
+
+	const channels, bitsPerSample = 1, 16
+	blockAlign := channels * bitsPerSample / 8
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(audio)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(rate))
+	binary.Write(&buf, binary.LittleEndian, uint32(rate*blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(audio)))
+	buf.Write(audio)
+	return buf.Bytes()
+}
+
+// sayHandler answers /say <prompt> with a spoken answer. Sent as a reply to a
+// message without a prompt, it reads that message aloud instead. Answers are
+// sent as text when speech can't be synthesized.
+func sayHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		cfg := userConfig(cfg, c.Sender())
+		prompt := strings.TrimSpace(c.Message().Payload)
+		replyTo := c.Message().ReplyTo
+
+		settings, err := getUserSettings(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error getting user settings", "err", err)
+		}
+
+		var text string
+		switch {
+		case prompt != "":
+			if ok, err := useDailyQuota(c, cfg); !ok {
+				return err
+			}
+			text, err = answerForSpeech(c, cfg, settings, prompt)
+			if err != nil {
+				updateLogger(c).Error("Error generating reply", "err", err)
+				return c.Send(replyErrorMessage(err))
+			}
+		case replyTo != nil && (replyTo.Text != "" || replyTo.Caption != ""):
+			text = replyTo.Text
+			if text == "" {
+				text = replyTo.Caption
+			}
+		default:
+			return c.Send("Usage: /say <question> to hear the answer, or reply to a message with /say to hear it read aloud")
+		}
+
+		c.Notify(tele.UploadingAudio)
+		audio, err := synthesizeSpeech(cfg, text)
+		if err != nil {
+			updateLogger(c).Error("Error synthesizing speech, sending text instead", "err", err)
+			_, err = deliverReply(c, cfg, noPlaceholder(c, replyTarget(c, settings)), settings, text, nil)
+			return err
+		}
+
+		voice := &tele.Audio{
+			File:     tele.FromReader(bytes.NewReader(audio)),
+			MIME:     "audio/wav",
+			FileName: "answer.wav",
+			Title:    "Answer",
+		}
+		if utf8.RuneCountInString(text) <= telegramCaptionLimit {
+			voice.Caption = text
+		}
+		if _, err := sendReply(c, voice); err != nil {
+			updateLogger(c).Error("Error sending speech, sending text instead", "err", err)
+			_, err = deliverReply(c, cfg, noPlaceholder(c, replyTarget(c, settings)), settings, text, nil)
+			return err
+		}
+		return nil
+	}
+}
+
+// answerForSpeech answers prompt in the context of the user's history and
+// saves the exchange like a text prompt.
+func answerForSpeech(c tele.Context, cfg *Config, settings UserSettings, prompt string) (string, error) {
+	stopTyping := keepTyping(c)
+	defer stopTyping()
+
+	prevMessages, err := getUserMessages(cfg, c.Sender().ID)
+	if err != nil {
+		updateLogger(c).Error("Error getting previous messages", "err", err)
+	}
+
+	modelTurn, err := generateReply(cfg, prevMessages, prompt, replyOptions(cfg, c, settings, prompt))
+	if err != nil {
+		return "", err
+	}
+
+	userTurn := Message{Role: "user", Message: prompt, MessageID: c.Message().ID}
+	historySaves.Save(cfg, c.Sender().ID, c.Sender(), userTurn, modelTurn)
+	return modelTurn.Message, nil
+}
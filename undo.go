@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// popLastExchange removes the last exchange from messages: the last user turn
+// and everything answering it. ok is false when there is no complete
+// exchange to remove.
+func popLastExchange(messages []Message) (kept, removed []Message, ok bool) {
+	if len(messages) < 2 {
+		return messages, nil, false
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[:i], messages[i:], true
+		}
+	}
+	return messages, nil, false
+}
+
+// undoLastExchange removes the last exchange of the user's active session
+// from the stored history and returns it, or nil if there is nothing to undo.
+func undoLastExchange(cfg *Config, telegramID int64) ([]Message, error) {
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	kept, removed, ok := popLastExchange(user.SessionMessages())
+	if !ok {
+		return nil, nil
+	}
+
+	user.SetSessionMessages(kept)
+	if err := patchHistory(cfg, user); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// undoHandler removes the last exchange of the current session with /undo.
+func undoHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		historySaves.Wait(c.Sender().ID)
+		removed, err := undoLastExchange(cfg, c.Sender().ID)
+		if err != nil {
+			updateLogger(c).Error("Error undoing last exchange", "err", err)
+			return c.Send("Error updating your history")
+		}
+		if removed == nil {
+			return c.Send("There is nothing to undo")
+		}
+		deleteStoredImages(cfg, removed)
+
+		return c.Send(fmt.Sprintf("Removed your last message and its answer: %q", contextSnippet(removed[0].Message)))
+	}
+}
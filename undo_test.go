@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPopLastExchange(t *testing.T) {
+	var (
+		q1 = Message{Role: "user", Message: "q1"}
+		a1 = Message{Role: "model", Message: "a1"}
+		q2 = Message{Role: "user", Message: "q2"}
+		a2 = Message{Role: "model", Message: "a2"}
+		// a2b is a second model turn answering q2, e.g. a candidate
+		a2b = Message{Role: "model", Message: "a2b"}
+	)
+
+	tests := []struct {
+		name        string
+		messages    []Message
+		wantKept    []Message
+		wantRemoved []Message
+		wantOK      bool
+	}{
+		{"empty", nil, nil, nil, false},
+		{"single turn", []Message{q1}, []Message{q1}, nil, false},
+		{"one exchange", []Message{q1, a1}, []Message{}, []Message{q1, a1}, true},
+		{"two exchanges", []Message{q1, a1, q2, a2}, []Message{q1, a1}, []Message{q2, a2}, true},
+		{"several answers", []Message{q1, a1, q2, a2, a2b}, []Message{q1, a1}, []Message{q2, a2, a2b}, true},
+		{"unanswered question", []Message{q1, a1, q2}, []Message{q1, a1}, []Message{q2}, true},
+		{"no user turn", []Message{a1, a2}, []Message{a1, a2}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, removed, ok := popLastExchange(tt.messages)
+			if ok != tt.wantOK {
+				t.Fatalf("popLastExchange() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("popLastExchange() kept = %v, want %v", kept, tt.wantKept)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("popLastExchange() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestUndoLastExchange(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	store.users[1] = &UserMessages{
+		ID:            1,
+		TelegramID:    100,
+		Messages:      []Message{{Role: "user", Message: "default"}, {Role: "model", Message: "answer"}},
+		ActiveSession: "work",
+		Sessions: map[string][]Message{"work": {
+			{Role: "user", Message: "q1"}, {Role: "model", Message: "a1"},
+			{Role: "user", Message: "q2"}, {Role: "model", Message: "a2"},
+		}},
+	}
+
+	removed, err := undoLastExchange(cfg, 100)
+	if err != nil {
+		t.Fatalf("undoLastExchange() error = %v", err)
+	}
+	if len(removed) != 2 || removed[0].Message != "q2" {
+		t.Errorf("undoLastExchange() = %v, want the q2 exchange", removed)
+	}
+
+	user := store.users[1]
+	if got := user.Sessions["work"]; len(got) != 2 || got[1].Message != "a1" {
+		t.Errorf("active session after undo = %v, want the q1 exchange", got)
+	}
+	if got := user.Messages; len(got) != 2 {
+		t.Errorf("default session after undo = %v, want it untouched", got)
+	}
+
+	// Unknown users have nothing to undo
+	if removed, err := undoLastExchange(cfg, 200); removed != nil || err != nil {
+		t.Errorf("undoLastExchange() for an unknown user = %v, %v, want nothing", removed, err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// defaultTopUsers is how many users /topusers lists without an argument.
+const defaultTopUsers = 10
+
+// TokenUsage counts the Gemini tokens spent on a user.
+type TokenUsage struct {
+	PromptTokens   int64 `json:"promptTokens"`
+	ResponseTokens int64 `json:"responseTokens"`
+}
+
+// Add adds other to the counters.
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.ResponseTokens += other.ResponseTokens
+}
+
+// AddMetadata adds the usage reported by a Gemini response, if any.
+func (u *TokenUsage) AddMetadata(meta *UsageMetadata) {
+	if meta == nil {
+		return
+	}
+	u.PromptTokens += int64(meta.PromptTokenCount)
+	u.ResponseTokens += int64(meta.CandidatesTokenCount)
+}
+
+// Total returns the sum of prompt and response tokens.
+func (u TokenUsage) Total() int64 {
+	return u.PromptTokens + u.ResponseTokens
+}
+
+// allUsers fetches every user record.
+func allUsers(cfg *Config) ([]UserMessages, error) {
+	var users []UserMessages
+	if err := storeRequest(cfg, "GET", "users", nil, &users); err != nil {
+		return nil, fmt.Errorf("error getting users from API: %w", err)
+	}
+	return users, nil
+}
+
+// topUsersHandler lists the users with the highest token usage to admins
+// with /topusers [N].
+func topUsersHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !isAdmin(cfg, c.Sender()) {
+			return c.Send("Only bot admins can use this command")
+		}
+
+		limit := defaultTopUsers
+		if payload := strings.TrimSpace(c.Message().Payload); payload != "" {
+			n, err := strconv.Atoi(payload)
+			if err != nil || n <= 0 {
+				return c.Send("Usage: /topusers [number of users]")
+			}
+			limit = n
+		}
+
+		users, err := allUsers(cfg)
+		if err != nil {
+			updateLogger(c).Error("Error loading users", "err", err)
+			return c.Send("Error loading users")
+		}
+
+		sort.Slice(users, func(i, j int) bool {
+			return users[i].Usage.Total() > users[j].Usage.Total()
+		})
+
+		var sb strings.Builder
+		sb.WriteString("Top users by token usage:\n")
+		for i, user := range users {
+			if i >= limit || user.Usage.Total() == 0 {
+				break
+			}
+			fmt.Fprintf(&sb, "\n%d. %s (%d) - %d tokens (%d prompt, %d response)",
+				i+1, user.Username, user.TelegramID, user.Usage.Total(), user.Usage.PromptTokens, user.Usage.ResponseTokens)
+		}
+		if len(users) == 0 || users[0].Usage.Total() == 0 {
+			sb.WriteString("\nNo usage recorded yet")
+		}
+		return c.Send(sb.String())
+	}
+}
+
+// resetUsageHandler lets admins clear all token counters with /resetusage.
+func resetUsageHandler(cfg *Config) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !isAdmin(cfg, c.Sender()) {
+			return c.Send("Only bot admins can use this command")
+		}
+
+		users, err := allUsers(cfg)
+		if err != nil {
+			updateLogger(c).Error("Error loading users", "err", err)
+			return c.Send("Error loading users")
+		}
+
+		failed := 0
+		for _, user := range users {
+			if user.Usage.Total() == 0 {
+				continue
+			}
+			if err := resetUserUsage(cfg, user.TelegramID); err != nil {
+				updateLogger(c).Error("Error resetting usage", "reset_user_id", user.TelegramID, "err", err)
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return c.Send(fmt.Sprintf("Usage counters were reset, but %d users couldn't be updated", failed))
+		}
+		return c.Send("Usage counters were reset")
+	}
+}
+
+// resetUserUsage clears the token counters of a user. Queued history saves
+// are written first and the record is reread under the user's lock, so a
+// save running at the same time can't write the old counters back.
+func resetUserUsage(cfg *Config, telegramID int64) error {
+	historySaves.Wait(telegramID)
+	defer userLocks.Lock(telegramID)()
+	user, err := findUser(cfg, telegramID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	return patchUserFields(cfg, user, map[string]interface{}{"usage": TokenUsage{}})
+}
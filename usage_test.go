@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestTokenUsageAddMetadata(t *testing.T) {
+	var usage TokenUsage
+	usage.AddMetadata(&UsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 5, TotalTokenCount: 15})
+	usage.AddMetadata(nil)
+	usage.AddMetadata(&UsageMetadata{PromptTokenCount: 7, CandidatesTokenCount: 3})
+
+	want := TokenUsage{PromptTokens: 17, ResponseTokens: 8}
+	if usage != want {
+		t.Errorf("usage = %+v, want %+v", usage, want)
+	}
+	if got := usage.Total(); got != 25 {
+		t.Errorf("Total() = %d, want 25", got)
+	}
+}
+
+func TestUsageAccumulatesAndResets(t *testing.T) {
+	store, cfg := newFakeUserStore(t, 0)
+	sender := &tele.User{ID: 1, Username: "alice"}
+
+	for _, meta := range []UsageMetadata{
+		{PromptTokenCount: 10, CandidatesTokenCount: 5},
+		{PromptTokenCount: 20, CandidatesTokenCount: 8},
+	} {
+		userTurn, modelTurn := exchange(0)
+		modelTurn.Usage.AddMetadata(&meta)
+		if err := saveMessage(cfg, sender.ID, sender, userTurn, modelTurn); err != nil {
+			t.Fatalf("saveMessage() error = %v", err)
+		}
+	}
+
+	user, err := findUser(cfg, sender.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (TokenUsage{PromptTokens: 30, ResponseTokens: 13}); user.Usage != want {
+		t.Errorf("stored usage = %+v, want %+v", user.Usage, want)
+	}
+
+	if err := resetUserUsage(cfg, sender.ID); err != nil {
+		t.Fatalf("resetUserUsage() error = %v", err)
+	}
+	store.mu.Lock()
+	usage := store.users[user.ID].Usage
+	store.mu.Unlock()
+	if usage != (TokenUsage{}) {
+		t.Errorf("usage after reset = %+v, want zero", usage)
+	}
+	if got := len(store.messages(sender.ID)); got != 4 {
+		t.Errorf("reset kept %d turns, want 4", got)
+	}
+}
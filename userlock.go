@@ -0,0 +1,48 @@
+package main
+
+import "sync"
+
+// userLocks serializes changes to user records. Mokky can only replace
+// fields, so every change is a read, modify and write that would otherwise
+// overwrite a concurrent one, e.g. a queued history save and /undo.
+var userLocks = newKeyedLocks()
+
+// keyedLocks hands out one mutex per user and drops it once nobody holds or
+// waits for it.
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[int64]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newKeyedLocks() *keyedLocks {
+	return &keyedLocks{locks: make(map[int64]*keyedLock)}
+}
+
+// Lock locks the record of the user and returns the function unlocking it.
+func (k *keyedLocks) Lock(telegramID int64) func() {
+	k.mu.Lock()
+	l := k.locks[telegramID]
+	if l == nil {
+		l = &keyedLock{}
+		k.locks[telegramID] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, telegramID)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// workJob is an update waiting for a worker.
+type workJob struct {
+	c       tele.Context
+	handler tele.HandlerFunc
+	// done marks the update as handled for the dedup checkpoint
+	done func()
+}
+
+// workerPool runs the handlers that call Gemini on a fixed number of workers
+// fed by a buffered queue, so update intake returns right away. Updates that
+// find the queue full are answered with a busy message.
+type workerPool struct {
+	mu     sync.Mutex
+	closed bool
+	jobs   chan workJob
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool starts WORKER_COUNT workers. It returns nil, meaning handlers
+// run directly, when the count is 0.
+func newWorkerPool(cfg *Config) *workerPool {
+	if cfg.WorkerCount <= 0 {
+		return nil
+	}
+
+	p := &workerPool{jobs: make(chan workJob, cfg.WorkQueueSize)}
+	p.wg.Add(cfg.WorkerCount)
+	for i := 0; i < cfg.WorkerCount; i++ {
+		go p.run()
+	}
+	slog.Info("Started workers", "workers", cfg.WorkerCount, "queue_size", cfg.WorkQueueSize)
+	return p
+}
+
+func (p *workerPool) run() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		// Panics would otherwise take down the worker and the bot with it
+		if err := recoverMiddleware(job.handler)(job.c); err != nil {
+			updateLogger(job.c).Error("Error handling update", "err", err)
+		}
+		job.done()
+	}
+}
+
+// middleware queues the handler for a worker instead of running it.
+func (p *workerPool) middleware(next tele.HandlerFunc) tele.HandlerFunc {
+	if p == nil {
+		return next
+	}
+	return func(c tele.Context) error {
+		// The update is only handled once a worker ran it
+		done := takeUpdateDone(c)
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			defer done()
+			return next(c)
+		}
+		select {
+		case p.jobs <- workJob{c: c, handler: next, done: done}:
+			p.mu.Unlock()
+			return nil
+		default:
+			p.mu.Unlock()
+			done()
+			updateLogger(c).Warn("Work queue is full, rejecting update")
+			return c.Send("I'm busy with other requests right now, please try again in a moment")
+		}
+	}
+}
+
+// Close stops accepting jobs and waits until the queued ones are handled.
+func (p *workerPool) Close() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	slog.Info("All queued updates were handled")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestWorkerPool(t *testing.T) {
+	pool := newWorkerPool(&Config{WorkerCount: 1, WorkQueueSize: 1})
+	b, api := newTestBot(t)
+	c := b.NewContext(tele.Update{Message: &tele.Message{
+		Sender: &tele.User{ID: 1},
+		Chat:   &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+	}})
+
+	var handled atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	blocking := pool.middleware(func(tele.Context) error {
+		close(started)
+		<-release
+		handled.Add(1)
+		return nil
+	})
+	counting := pool.middleware(func(tele.Context) error {
+		handled.Add(1)
+		return nil
+	})
+
+	// The first update keeps the only worker busy, the second fills the queue
+	if err := blocking(c); err != nil {
+		t.Fatalf("queueing the first update: %v", err)
+	}
+	<-started
+	if err := counting(c); err != nil {
+		t.Fatalf("queueing the second update: %v", err)
+	}
+	if err := counting(c); err != nil {
+		t.Fatalf("rejecting the third update: %v", err)
+	}
+	if texts := api.Texts(); len(texts) != 1 || texts[0] != "I'm busy with other requests right now, please try again in a moment" {
+		t.Errorf("replies = %q, want the busy message", texts)
+	}
+
+	// Close waits for the queued updates
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the queued updates were handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+	if got := handled.Load(); got != 2 {
+		t.Errorf("handled %d updates before Close returned, want 2", got)
+	}
+
+	// Updates arriving after Close are handled right away
+	if err := counting(c); err != nil || handled.Load() != 3 {
+		t.Errorf("update after Close: error %v, handled %d, want it handled", err, handled.Load())
+	}
+}
+
+func TestWorkerPoolRecoversPanics(t *testing.T) {
+	pool := newWorkerPool(&Config{WorkerCount: 1, WorkQueueSize: 2})
+	b, _ := newTestBot(t)
+	c := b.NewContext(tele.Update{Message: &tele.Message{Sender: &tele.User{ID: 1}, Chat: &tele.Chat{ID: 1}}})
+
+	var handled atomic.Bool
+	pool.middleware(func(tele.Context) error { panic("boom") })(c)
+	pool.middleware(func(tele.Context) error {
+		handled.Store(true)
+		return nil
+	})(c)
+	pool.Close()
+
+	if !handled.Load() {
+		t.Error("the worker stopped after a panic")
+	}
+}
+
+func TestWorkerPoolDisabled(t *testing.T) {
+	if pool := newWorkerPool(&Config{}); pool != nil {
+		t.Fatal("newWorkerPool() without workers isn't nil")
+	}
+	var pool *workerPool
+	ran := false
+	pool.middleware(func(tele.Context) error {
+		ran = true
+		return nil
+	})(nil)
+	if !ran {
+		t.Error("the handler didn't run directly")
+	}
+	pool.Close()
+}